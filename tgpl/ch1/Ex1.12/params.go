@@ -0,0 +1,162 @@
+package main
+
+import (
+	"digest_gopl/ch1/lissajous"
+	"fmt"
+	"image/color"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// params are the knobs of an animation, from the query parameters
+// cycles, res, size, nframes, delay, freq, phase, thickness and those
+// below.
+type params struct {
+	lissajous.Options
+	palette string // a key of palettes
+	seed    int64  // of the random frequency, if freq is not given
+	format  string // a key of formats, or "" to go by the Accept header
+}
+
+// palettes map names to colors: the background, then those of the
+// line, which takes them in turn along its length.
+var palettes = map[string]color.Palette{
+	"green": lissajous.Green,
+	"black": {color.Black, color.RGBA{0x00, 0xff, 0x00, 0xff}},
+	"rainbow": {
+		color.White,
+		color.RGBA{0xff, 0x00, 0x00, 0xff}, color.RGBA{0xff, 0x80, 0x00, 0xff}, color.RGBA{0xe0, 0xc0, 0x00, 0xff},
+		color.RGBA{0x00, 0xc0, 0x00, 0xff}, color.RGBA{0x00, 0x60, 0xff, 0xff}, color.RGBA{0x80, 0x00, 0xc0, 0xff},
+	},
+}
+
+const (
+	maxPixels = 1 << 26 // of all the frames together
+	maxPoints = 5e7     // computed for all the frames together
+	maxInk    = 4e8     // pixels set by the pen, thickness² a point, for all the frames together
+	maxSVG    = 2e6     // points in the paths of an SVG, some 12 bytes each
+)
+
+// parseParams returns the params of the request r: the defaults of
+// the book, overridden by the query parameters. Unless they give the
+// frequency, it is drawn from the seed, at random unless they give
+// that.
+func parseParams(r *http.Request) (params, error) {
+	p := params{palette: "green", seed: rand.Int63()}
+	if err := r.ParseForm(); err != nil {
+		return p, err
+	}
+	var names []string
+	for name := range r.Form {
+		names = append(names, name)
+	}
+	sort.Strings(names) // report the same error for the same request
+	for _, name := range names {
+		if len(r.Form[name]) > 1 {
+			return p, fmt.Errorf("parameter %s given more than once", name)
+		}
+	}
+	// the seed first, for the defaults
+	if seed := r.Form.Get("seed"); seed != "" {
+		if err := p.set("seed", seed); err != nil {
+			return p, err
+		}
+	}
+	p.Options = lissajous.Defaults(rand.New(rand.NewSource(p.seed)))
+	p.Thickness = 1
+	for _, name := range names {
+		if err := p.set(name, r.Form.Get(name)); err != nil {
+			return p, err
+		}
+	}
+	if err := p.validate(); err != nil {
+		return p, err
+	}
+	p.Palette = palettes[p.palette]
+	return p, nil
+}
+
+// set sets the knob name to value.
+func (p *params) set(name, value string) error {
+	var err error
+	intVar := func(v *int) { *v, err = strconv.Atoi(value) }
+	floatVar := func(v *float64) { *v, err = strconv.ParseFloat(value, 64) }
+	switch name {
+	case "cycles":
+		floatVar(&p.Cycles)
+	case "res":
+		floatVar(&p.Res)
+	case "size":
+		intVar(&p.Size)
+	case "nframes":
+		intVar(&p.Frames)
+	case "delay":
+		intVar(&p.Delay)
+	case "freq":
+		floatVar(&p.Freq)
+	case "phase":
+		floatVar(&p.Phase)
+	case "palette":
+		p.palette = value
+	case "thickness":
+		intVar(&p.Thickness)
+	case "seed":
+		p.seed, err = strconv.ParseInt(value, 10, 64)
+	case "format":
+		p.format = value
+	default:
+		return fmt.Errorf("unknown parameter %s", name)
+	}
+	if err != nil {
+		return fmt.Errorf("bad %s %q", name, value)
+	}
+	return nil
+}
+
+// validate reports the first knob of p that is out of range.
+func (p *params) validate() error {
+	switch {
+	case !(p.Cycles > 0 && p.Cycles <= 100):
+		return fmt.Errorf("cycles %g out of range (0, 100]", p.Cycles)
+	case !(p.Res >= 1e-5 && p.Res <= 1):
+		return fmt.Errorf("res %g out of range [1e-05, 1]", p.Res)
+	case p.Size < 1 || p.Size > 1000:
+		return fmt.Errorf("size %d out of range [1, 1000]", p.Size)
+	case p.Frames < 1 || p.Frames > 1000:
+		return fmt.Errorf("nframes %d out of range [1, 1000]", p.Frames)
+	case p.Delay < 1 || p.Delay > 1000:
+		return fmt.Errorf("delay %d out of range [1, 1000]", p.Delay)
+	case !(p.Freq >= 0 && p.Freq <= 100):
+		return fmt.Errorf("freq %g out of range [0, 100]", p.Freq)
+	case math.IsNaN(p.Phase) || math.IsInf(p.Phase, 0):
+		return fmt.Errorf("phase must be finite")
+	case p.Thickness < 1 || p.Thickness > p.Size:
+		return fmt.Errorf("thickness %d out of range [1, size]", p.Thickness)
+	case float64(p.Frames)*float64(2*p.Size+1)*float64(2*p.Size+1) > maxPixels:
+		return fmt.Errorf("nframes of size %d too many for %d pixels", p.Size, maxPixels)
+	case float64(p.Frames)*p.Cycles*2*math.Pi/p.Res > maxPoints:
+		return fmt.Errorf("res %g too fine for %g points in all", p.Res, maxPoints)
+	case float64(p.Frames)*p.Cycles*2*math.Pi/p.Res*float64(p.Thickness)*float64(p.Thickness) > maxInk:
+		return fmt.Errorf("thickness %d too great for %g pixels drawn in all", p.Thickness, maxInk)
+	}
+	if _, ok := palettes[p.palette]; !ok {
+		return fmt.Errorf("unknown palette %q", p.palette)
+	}
+	if _, ok := formats[p.format]; !ok && p.format != "" {
+		return fmt.Errorf("unknown format %q", p.format)
+	}
+	return p.checkFormat()
+}
+
+// checkFormat reports whether the output of p is too large in its
+// format, which may be known only after negotiation.
+func (p *params) checkFormat() error {
+	if p.format == "svg" && p.SVGPoints() > maxSVG {
+		return fmt.Errorf("res, cycles and nframes of size %d and freq %g too many for %g points of SVG",
+			p.Size, p.Freq, maxSVG)
+	}
+	return nil
+}
@@ -1,79 +1,100 @@
+// Server serves Lissajous animations whose every knob is a query
+// parameter, as an animated GIF, an animated PNG or an SVG animation.
 package main
 
 import (
-	"image"
-	"image/color"
-	"image/gif"
+	"bytes"
+	"digest_gopl/ch1/lissajous"
 	"io"
 	"log"
-	"math"
-	"math/rand"
+	"mime"
 	"net/http"
 	"strconv"
-	"time"
+	"strings"
 )
 
-var palette = []color.Color{color.White, color.RGBA{0x00, 0xff, 0x00, 0xff}} // slice
-
-const (
-	whiteIndex = 0 // first color in palette
-	greenIndex = 1 // next color in palette
-)
+// formats maps the names of the format parameter to the output
+// formats.
+var formats = map[string]struct {
+	contentType string
+	write       func(o *lissajous.Options, out io.Writer) error
+}{
+	"gif":  {"image/gif", (*lissajous.Options).GIF},
+	"apng": {"image/apng", (*lissajous.Options).APNG},
+	"svg":  {"image/svg+xml", (*lissajous.Options).SVG},
+}
 
-func main() {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if err := r.ParseForm(); err != nil {
-			log.Print(err)
+// negotiate returns the format of formats that the Accept header h
+// prefers, "gif" if h is empty or accepts any image, or "" if it
+// accepts none of them. Of types of equal quality, the first named
+// outright is preferred to any wildcard.
+func negotiate(h string) string {
+	if strings.TrimSpace(h) == "" {
+		return "gif"
+	}
+	best, bestQ, bestNamed := "", 0.0, false
+	for _, part := range strings.Split(h, ",") {
+		mediaType, attrs, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
 		}
-
-		for _, v := range r.Form {
-			// fmt.Println(v[0])
-			// fmt.Printf("%T", v[0]) // string
-			circles, err := strconv.Atoi(v[0])
-			if err != nil {
-				log.Print(err)
+		q := 1.0
+		if s, ok := attrs["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
 			}
-			lissajous(w, circles)
 		}
-	})
-	log.Fatal(http.ListenAndServe("localhost:8000", nil))
+		var format string
+		switch mediaType {
+		case "image/gif", "image/*", "*/*":
+			format = "gif"
+		case "image/apng":
+			format = "apng"
+		case "image/svg+xml":
+			format = "svg"
+		}
+		named := !strings.HasSuffix(mediaType, "*")
+		if format != "" && q > 0 && (q > bestQ || q == bestQ && named && !bestNamed) {
+			best, bestQ, bestNamed = format, q, named
+		}
+	}
+	return best
 }
 
-func lissajous(out io.Writer, cc int) {
-	const (
-		res     = 0.001
-		size    = 100
-		nframes = 64 // number of animation frames, 帧数
-		delay   = 8
-	)
-
-	cycles := float64(cc)
-
-	anim := gif.GIF{LoopCount: nframes} // struct, init LoopCount field with nframe, other fields stay the default zero value
-
-	rand.Seed(time.Now().UTC().UnixNano())
-	freq := rand.Float64() * 3.0
-	phase := 0.0
-
-	for i := 0; i < nframes; i++ {
-		rect := image.Rect(0, 0, 2*size+1, 2*size+1) // 201*201 画板
-		// 调色板；所有像素被初始化为画板的 zero value，即第 0 个颜色（白色）
-		img := image.NewPaletted(rect, palette)
-
-		// 将某些像素点设为绿色
-		for t := 0.0; t < cycles*2*math.Pi; t += res {
-			x := math.Sin(t)
-			y := math.Sin(t*freq + phase)
-			// 将某些像素点设为绿色
-			img.SetColorIndex(size+int(x*size+0.5), size+int(y*size+0.5), greenIndex)
+func handler(w http.ResponseWriter, r *http.Request) {
+	p, err := parseParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+	if p.format == "" {
+		if p.format = negotiate(r.Header.Get("Accept")); p.format == "" {
+			http.Error(w, "no format accepted of image/gif, image/apng and image/svg+xml", http.StatusNotAcceptable)
+			return
+		}
+		if err := p.checkFormat(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		phase += 0.1
-
-		// update Delay and Image fields of anim
-		anim.Delay = append(anim.Delay, delay)
-		anim.Image = append(anim.Image, img)
 	}
-	gif.EncodeAll(out, &anim)
+	f := formats[p.format]
+	var buf bytes.Buffer // so that an error may still be reported
+	if err := f.write(&p.Options, &buf); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", f.contentType)
+	w.Write(buf.Bytes())
+}
+
+func main() {
+	http.HandleFunc("/", handler)
+	log.Fatal(http.ListenAndServe("localhost:8000", nil))
 }
 
 // http://localhost:8000/?cycles=20
+// http://localhost:8000/?seed=42
+// http://localhost:8000/?freq=1.5&palette=rainbow&thickness=3&format=apng
+// http://localhost:8000/?size=200&nframes=32&delay=4&phase=0.2&palette=black&format=svg
+// curl -H 'Accept: image/svg+xml' 'localhost:8000/?cycles=3&freq=2'
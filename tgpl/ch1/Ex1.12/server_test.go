@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	for _, test := range []struct {
+		accept, want string
+	}{
+		{"", "gif"},
+		{"*/*", "gif"},
+		{"image/gif", "gif"},
+		{"image/svg+xml", "svg"},
+		{"image/apng, image/gif", "apng"},
+		{"image/gif;q=0.5, image/svg+xml", "svg"},
+		{"*/*, image/svg+xml", "svg"}, // named rather than a wildcard
+		{"image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8", "apng"},
+		{"text/html", ""},
+		{"image/svg+xml;q=0", ""},
+	} {
+		if got := negotiate(test.accept); got != test.want {
+			t.Errorf("negotiate(%q) = %q, want %q", test.accept, got, test.want)
+		}
+	}
+}
+
+func get(query, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/?"+query, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestParams(t *testing.T) {
+	for _, query := range []string{
+		"cycles=0",
+		"cycles=x",
+		"res=0",
+		"size=0",
+		"nframes=1001",
+		"delay=0",
+		"freq=-1",
+		"phase=NaN",
+		"palette=plaid",
+		"thickness=0",
+		"thickness=11&size=10",
+		"format=jpeg",
+		"size=1000&nframes=100", // too many pixels
+		"res=0.00001&cycles=100",
+		"size=1000&nframes=1&thickness=300&cycles=10&res=0.001", // too much ink
+		"thickness=15",
+		"size=1000&freq=100&cycles=79&res=0.00001&nframes=1&format=svg", // too much SVG
+		"cycles=1&cycles=2",
+		"color=red",
+	} {
+		if w := get(query, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+	for _, query := range []string{"thickness=14", "size=1000&nframes=1&thickness=300&cycles=0.1"} {
+		if _, err := parseParams(httptest.NewRequest("GET", "/?"+query, nil)); err != nil {
+			t.Errorf("%s: %v", query, err)
+		}
+	}
+	// the same, negotiated, and as a GIF
+	big := "size=1000&freq=100&cycles=79&res=0.00001&nframes=1"
+	if w := get(big, "image/svg+xml"); w.Code != http.StatusBadRequest {
+		t.Errorf("%s as image/svg+xml: status %d, want %d", big, w.Code, http.StatusBadRequest)
+	}
+	if _, err := parseParams(httptest.NewRequest("GET", "/?"+big, nil)); err != nil {
+		t.Errorf("%s: %v", big, err)
+	}
+	if w := get("size=10", "text/html"); w.Code != http.StatusNotAcceptable {
+		t.Errorf("Accept text/html: status %d, want %d", w.Code, http.StatusNotAcceptable)
+	}
+}
+
+const small = "size=20&nframes=3&freq=1.5&cycles=2&palette=rainbow&thickness=2"
+
+func TestGIF(t *testing.T) {
+	w := get(small+"&delay=5", "")
+	g, err := gif.DecodeAll(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 3 || g.Delay[2] != 5 || g.Image[0].Bounds().Dx() != 41 {
+		t.Errorf("%d frames of %v, delays %v", len(g.Image), g.Image[0].Bounds(), g.Delay)
+	}
+}
+
+func TestAPNG(t *testing.T) {
+	w := get(small+"&delay=5&format=apng", "")
+	if ct := w.Header().Get("Content-Type"); ct != "image/apng" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	data := w.Body.Bytes()
+	// its first frame is a PNG
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := parseParams(httptest.NewRequest("GET", "/?"+small, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := p.Frame(0)
+	for y := 0; y < 41; y++ {
+		for x := 0; x < 41; x++ {
+			if color.RGBAModel.Convert(img.At(x, y)) != color.RGBAModel.Convert(first.At(x, y)) {
+				t.Fatalf("pixel (%d, %d) is %v, want %v", x, y, img.At(x, y), first.At(x, y))
+			}
+		}
+	}
+}
+
+func TestSeed(t *testing.T) {
+	a, b, c := get("size=20&nframes=2&seed=1", ""), get("size=20&nframes=2&seed=1", ""), get("size=20&nframes=2&seed=2", "")
+	if !bytes.Equal(a.Body.Bytes(), b.Body.Bytes()) {
+		t.Error("two animations of seed 1 differ")
+	}
+	if bytes.Equal(a.Body.Bytes(), c.Body.Bytes()) {
+		t.Error("animations of seeds 1 and 2 are the same")
+	}
+}
+
+func TestSVG(t *testing.T) {
+	w := get(small, "image/svg+xml")
+	if ct := w.Header().Get("Content-Type"); ct != "image/svg+xml" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	svg := w.Body.String()
+	// a path for each of the 6 colors of the line, of 3 frames
+	if n := strings.Count(svg, "<path"); n != 6 {
+		t.Errorf("%d paths, want 6", n)
+	}
+	if n := strings.Count(svg, "<animate"); n != 6 {
+		t.Errorf("%d animations, want 6", n)
+	}
+	if !strings.Contains(svg, "repeatCount='4'") || !strings.Contains(svg, "stroke-width='2'") {
+		t.Errorf("SVG:\n%s", svg)
+	}
+}
@@ -20,6 +20,7 @@ func main() {
 			// fmt.Printf("%T\n", arg) // filename, string
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%v\n", err)
+				continue
 			}
 			countLines(f, counts, arg)
 			f.Close()
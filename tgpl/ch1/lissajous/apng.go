@@ -0,0 +1,135 @@
+package lissajous
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+)
+
+// An animated PNG is a PNG of its first frame, which viewers that do
+// not know APNG show, with chunks for the animation: acTL for the
+// number of frames and plays, an fcTL before the image data of each
+// frame for its size and delay, and the data of the frames after the
+// first in fdAT chunks, the data of IDAT chunks after a sequence
+// number. See https://wiki.mozilla.org/APNG_Specification.
+//
+// The frames are encoded by package png, from whose output the chunks
+// of the image data are taken.
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// APNG writes the animation as an animated PNG, which plays as many
+// times as the GIF, whose LoopCount is the number of times it repeats.
+func (o *Options) APNG(out io.Writer) error {
+	return encodeAPNG(out, o.AllFrames(), o.Delay, o.Frames+1)
+}
+
+// encodeAPNG writes the frames, all of the size and palette of the
+// first, as an animated PNG that shows each for delay hundredths of a
+// second and plays them plays times, or for ever if plays is 0.
+func encodeAPNG(out io.Writer, frames []*image.Paletted, delay, plays int) error {
+	w := &chunkWriter{w: out}
+	w.write(pngSignature)
+	var seq uint32 // of the fcTL and fdAT chunks
+	for i, frame := range frames {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, frame); err != nil {
+			return err
+		}
+		chunks, err := readChunks(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			for _, c := range chunks {
+				switch c.kind {
+				case "IHDR":
+					w.chunk("IHDR", c.data)
+					w.chunk("acTL", be32(uint32(len(frames))), be32(uint32(plays)))
+				case "PLTE", "tRNS":
+					w.chunk(c.kind, c.data)
+				}
+			}
+		}
+		b := frame.Bounds()
+		w.chunk("fcTL", be32(seq), be32(uint32(b.Dx())), be32(uint32(b.Dy())), be32(0), be32(0),
+			be16(uint16(delay)), be16(100),
+			[]byte{0, 0}) // dispose of nothing, draw over nothing
+		seq++
+		for _, c := range chunks {
+			if c.kind != "IDAT" {
+				continue
+			}
+			if i == 0 {
+				w.chunk("IDAT", c.data)
+			} else {
+				w.chunk("fdAT", be32(seq), c.data)
+				seq++
+			}
+		}
+	}
+	w.chunk("IEND")
+	return w.err
+}
+
+type chunk struct {
+	kind string
+	data []byte
+}
+
+// readChunks returns the chunks of the PNG data.
+func readChunks(data []byte) ([]chunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, fmt.Errorf("not a PNG")
+	}
+	data = data[len(pngSignature):]
+	var chunks []chunk
+	for len(data) > 0 {
+		if len(data) < 12 {
+			return nil, fmt.Errorf("short PNG chunk")
+		}
+		n := binary.BigEndian.Uint32(data)
+		if uint64(n) > uint64(len(data)-12) {
+			return nil, fmt.Errorf("PNG chunk of %d bytes past the end", n)
+		}
+		chunks = append(chunks, chunk{string(data[4:8]), data[8 : 8+n]})
+		data = data[12+n:]
+	}
+	return chunks, nil
+}
+
+// A chunkWriter writes PNG chunks, keeping the first error.
+type chunkWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *chunkWriter) write(b []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(b)
+	}
+}
+
+// chunk writes the chunk of the kind whose data are the parts.
+func (w *chunkWriter) chunk(kind string, parts ...[]byte) {
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	n := 0
+	for _, part := range parts {
+		crc.Write(part)
+		n += len(part)
+	}
+	w.write(be32(uint32(n)))
+	w.write([]byte(kind))
+	for _, part := range parts {
+		w.write(part)
+	}
+	w.write(be32(crc.Sum32()))
+}
+
+func be32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func be16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
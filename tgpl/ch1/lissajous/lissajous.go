@@ -0,0 +1,113 @@
+// Package lissajous draws animations of Lissajous figures, the curves
+// traced by two oscillators at right angles, as in the book's
+// lissajous program, and writes them as GIF, APNG or SVG.
+package lissajous
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"math"
+	"math/rand"
+)
+
+// Options describe an animation.
+type Options struct {
+	Cycles    float64       // number of complete x oscillator revolutions
+	Res       float64       // angular resolution
+	Size      int           // image canvas covers [-size..+size]
+	Frames    int           // number of animation frames
+	Delay     int           // delay between frames in 10ms units
+	Freq      float64       // relative frequency of y oscillator
+	Phase     float64       // phase difference added at each frame
+	Palette   color.Palette // the background, then the colors of the line
+	Thickness int           // of the line, in pixels; 0 means 1
+
+	// RandomColors colors each point of the line with one of the
+	// colors of the line picked by Rand, rather than with each of them
+	// in turn along its length.
+	RandomColors bool
+	Rand         *rand.Rand
+}
+
+// Green is the palette of the book: a green line on white.
+var Green = color.Palette{color.White, color.RGBA{0x00, 0xff, 0x00, 0xff}}
+
+// Defaults returns the options of the book, with a frequency drawn
+// from rng.
+func Defaults(rng *rand.Rand) Options {
+	return Options{
+		Cycles:  5,
+		Res:     0.001,
+		Size:    100,
+		Frames:  64,
+		Delay:   8,
+		Freq:    rng.Float64() * 3.0,
+		Phase:   0.1,
+		Palette: Green,
+		Rand:    rng,
+	}
+}
+
+// Curve calls f with the points (x, y), from -1 to +1, of frame i at
+// intervals of step, and the index in the palette of the color of each.
+func (o *Options) Curve(i int, step float64, f func(x, y float64, color uint8)) {
+	lines := len(o.Palette) - 1
+	phase := float64(i) * o.Phase
+	end := o.Cycles * 2 * math.Pi
+	for t := 0.0; t < end; t += step {
+		x := math.Sin(t)
+		y := math.Sin(t*o.Freq + phase)
+		var color uint8
+		if o.RandomColors {
+			color = uint8(1 + o.Rand.Intn(lines))
+		} else {
+			color = uint8(1 + int(t/end*float64(lines)))
+		}
+		f(x, y, color)
+	}
+}
+
+// Frame returns frame i, drawn with a square pen o.Thickness pixels
+// wide.
+func (o *Options) Frame(i int) *image.Paletted {
+	rect := image.Rect(0, 0, 2*o.Size+1, 2*o.Size+1)
+	img := image.NewPaletted(rect, o.Palette)
+	thickness := o.Thickness
+	if thickness < 1 {
+		thickness = 1
+	}
+	before := (thickness - 1) / 2 // of the pen, left of and above its point
+	size := float64(o.Size)
+	o.Curve(i, o.Res, func(x, y float64, color uint8) {
+		px := o.Size + int(x*size+0.5) - before
+		py := o.Size + int(y*size+0.5) - before
+		for dy := 0; dy < thickness; dy++ {
+			for dx := 0; dx < thickness; dx++ {
+				img.SetColorIndex(px+dx, py+dy, color) // those off the canvas are ignored
+			}
+		}
+	})
+	return img
+}
+
+// AllFrames returns all the frames.
+func (o *Options) AllFrames() []*image.Paletted {
+	var frames []*image.Paletted
+	for i := 0; i < o.Frames; i++ {
+		frames = append(frames, o.Frame(i))
+	}
+	return frames
+}
+
+// GIF writes the animation as an animated GIF. Like the book's, it
+// repeats as many times as it has frames.
+func (o *Options) GIF(out io.Writer) error {
+	anim := gif.GIF{LoopCount: o.Frames}
+	for _, img := range o.AllFrames() {
+		anim.Delay = append(anim.Delay, o.Delay)
+		anim.Image = append(anim.Image, img)
+	}
+	return gif.EncodeAll(out, &anim)
+}
@@ -0,0 +1,110 @@
+package lissajous
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"image/color"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "write the golden files of the tests")
+
+// small returns the options of the book, drawn from seed, for an
+// animation small enough to keep in testdata.
+func small(seed int64) Options {
+	o := Defaults(rand.New(rand.NewSource(seed)))
+	o.Size, o.Frames = 30, 4
+	return o
+}
+
+var colors = color.Palette{
+	color.White,
+	color.RGBA{0x00, 0xff, 0x00, 0xff}, color.RGBA{0xff, 0x00, 0x00, 0xff}, color.RGBA{0x00, 0x00, 0xff, 0xff},
+}
+
+// TestGolden checks that the animations of given seeds come out byte
+// for byte as they did when they were written with -update.
+func TestGolden(t *testing.T) {
+	book := small(1)
+	random := small(2)
+	random.Palette, random.RandomColors, random.Thickness = colors, true, 2
+	along := small(3)
+	along.Palette = colors
+
+	for _, test := range []struct {
+		file  string
+		o     Options
+		write func(*Options, io.Writer) error
+	}{
+		{"book.gif", book, (*Options).GIF},
+		{"random.gif", random, (*Options).GIF},
+		{"along.gif", along, (*Options).GIF},
+		{"along.apng", along, (*Options).APNG},
+		{"along.svg", along, (*Options).SVG},
+	} {
+		var buf bytes.Buffer
+		if err := test.write(&test.o, &buf); err != nil {
+			t.Fatalf("%s: %v", test.file, err)
+		}
+		path := filepath.Join("testdata", test.file)
+		if *update {
+			if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s: %d bytes differ from the %d of the golden file", test.file, buf.Len(), len(want))
+		}
+	}
+}
+
+func TestAPNG(t *testing.T) {
+	o := small(1)
+	o.Delay = 5
+	o.Frames = 3
+	var buf bytes.Buffer
+	if err := o.APNG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := readChunks(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	var seq []uint32
+	for _, c := range chunks {
+		kinds = append(kinds, c.kind)
+		switch c.kind {
+		case "acTL":
+			if frames, plays := binary.BigEndian.Uint32(c.data), binary.BigEndian.Uint32(c.data[4:]); frames != 3 || plays != 4 {
+				t.Errorf("acTL of %d frames, %d plays", frames, plays)
+			}
+		case "fcTL", "fdAT":
+			seq = append(seq, binary.BigEndian.Uint32(c.data))
+		}
+		if c.kind == "fcTL" && binary.BigEndian.Uint16(c.data[20:]) != 5 {
+			t.Errorf("fcTL delay %d", binary.BigEndian.Uint16(c.data[20:]))
+		}
+	}
+	got := strings.Join(kinds, " ")
+	if want := "IHDR acTL PLTE fcTL IDAT fcTL fdAT fcTL fdAT IEND"; got != want {
+		t.Errorf("chunks %s, want %s", got, want)
+	}
+	for i, n := range seq {
+		if n != uint32(i) {
+			t.Errorf("sequence numbers %v", seq)
+			break
+		}
+	}
+}
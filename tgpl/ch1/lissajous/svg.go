@@ -0,0 +1,90 @@
+package lissajous
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"strings"
+)
+
+// SVG writes the animation as an SVG image with a path for each color
+// of the line, whose outline an SMIL animation changes at each frame.
+func (o *Options) SVG(out io.Writer) error {
+	pal := o.Palette
+	size := float64(o.Size)
+	step := o.svgStep()
+
+	outlines := make([][]string, len(pal)-1) // of color 1 + i at each frame
+	for i := 0; i < o.Frames; i++ {
+		paths := make([]bytes.Buffer, len(outlines))
+		var px, py float64
+		var last uint8
+		o.Curve(i, step, func(x, y float64, color uint8) {
+			b := &paths[color-1]
+			switch {
+			case color == last:
+				b.WriteString(" ")
+			case last != 0:
+				// from where the line of the last color stopped
+				fmt.Fprintf(b, "M%.1f,%.1fL", px, py)
+			default:
+				b.WriteString("M")
+			}
+			px, py, last = size+x*size+0.5, size+y*size+0.5, color
+			fmt.Fprintf(b, "%.1f,%.1f", px, py)
+		})
+		for c := range outlines {
+			d := paths[c].String()
+			if d == "" {
+				d = "M0,0"
+			}
+			outlines[c] = append(outlines[c], d)
+		}
+	}
+
+	w := bufio.NewWriter(out)
+	side := 2*o.Size + 1
+	thickness := o.Thickness
+	if thickness < 1 {
+		thickness = 1
+	}
+	fmt.Fprintf(w, "<svg xmlns='http://www.w3.org/2000/svg' width='%d' height='%d' viewBox='0 0 %[1]d %[2]d'>\n", side, side)
+	fmt.Fprintf(w, "<rect width='100%%' height='100%%' fill='%s'/>\n", hex(pal[0]))
+	for c, frames := range outlines {
+		fmt.Fprintf(w, "<path fill='none' stroke='%s' stroke-width='%d' stroke-linejoin='round' d='%s'>",
+			hex(pal[c+1]), thickness, frames[0])
+		if len(frames) > 1 {
+			// each of the values for an equal part of dur; repeatCount
+			// counts the plays, as the LoopCount of a GIF does not
+			fmt.Fprintf(w, "\n<animate attributeName='d' calcMode='discrete' dur='%gs' repeatCount='%d' fill='freeze' values='%s'/>\n",
+				float64(o.Frames*o.Delay)/100, o.Frames+1, strings.Join(frames, ";"))
+		}
+		fmt.Fprintln(w, "</path>")
+	}
+	fmt.Fprintln(w, "</svg>")
+	return w.Flush()
+}
+
+// svgStep returns the step of t between the points of the paths of the
+// SVG. They need not be as close as those of a frame: they move at most
+// size·√(1 + freq²) pixels per radian, so at this step they are at most
+// 2 pixels apart.
+func (o *Options) svgStep() float64 {
+	return math.Max(o.Res, 2/(float64(o.Size)*math.Hypot(1, o.Freq)))
+}
+
+// SVGPoints returns the number of points in the paths of the SVG of the
+// animation, of all the frames together, which its size is about
+// proportional to.
+func (o *Options) SVGPoints() float64 {
+	return float64(o.Frames) * math.Ceil(o.Cycles*2*math.Pi/o.svgStep())
+}
+
+// hex returns c as #rrggbb.
+func hex(c color.Color) string {
+	r, g, b, _ := c.RGBA()
+	return fmt.Sprintf("#%02x%02x%02x", r>>8, g>>8, b>>8)
+}
@@ -0,0 +1,51 @@
+// The convert command reads an image from the standard input and
+// writes it in another format to the standard output. It reads GIF,
+// JPEG and PNG, and the formats of any other decoders imported here,
+// such as golang.org/x/image/webp.
+package main
+
+import (
+	"bufio"
+	"digest_gopl/ch10/imageconv"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	format  = flag.String("format", "jpeg", "output format: "+strings.Join(imageconv.Formats(), ", ")+", or empty for that of the input")
+	quality = flag.Int("quality", 95, "JPEG quality, 1 to 100")
+	frame   = flag.Int("frame", 0, "frame of an animated GIF to write as a still image")
+	palette = flag.String("palette", "", "GIF palette: plan9, websafe or adaptive; empty to keep those of GIF input")
+	colors  = flag.Int("colors", 0, "colors of the adaptive GIF palette, 2 to 256; 0 for 256")
+	dither  = flag.Bool("dither", false, "dither GIF output")
+)
+
+func main() {
+	flag.Parse()
+	out := bufio.NewWriter(os.Stdout)
+	kind, err := imageconv.Convert(out, os.Stdin, imageconv.Options{
+		Format:  *format,
+		Quality: *quality,
+		Frame:   *frame,
+		Palette: *palette,
+		Colors:  *colors,
+		Dither:  *dither,
+	})
+	if kind != "" {
+		fmt.Fprintln(os.Stderr, "Input format =", kind)
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "convert: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// go run ../mandelbrot | go run . >mandelbrot.jpg
+// go run ../mandelbrot -palette=classic | go run . -format=gif -colors=64 -dither >mandelbrot.gif
+// go run . -format=png -frame=10 <lissajous.gif >frame10.png
+// go run . -format=gif -palette=websafe <animated.gif >websafe.gif
@@ -0,0 +1,125 @@
+package fractal
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Deep zooms: a pixel of a window 1e-13 wide differs from its
+// neighbors by less than the precision of a complex128 near the set,
+// so they would all have the same color. Instead, the orbit of the
+// center of the window is computed once with big.Floats, and each pixel
+// follows the small difference of its own orbit from that one, which a
+// complex128 holds well: if Z(n) is the reference orbit of the center C
+// and z(n) = Z(n) + d(n) the orbit of the pixel c = C + dc, then
+//
+//	d(n+1) = 2·Z(n)·d(n) + d(n)² + dc
+
+// DirectSpacing is the smallest distance between pixels at which they
+// may be computed directly with complex128 values, leaving some 10 bits
+// of them to the rounding errors of the iterations. Closer pixels need
+// a Deep fractal.
+const DirectSpacing = 1e-12
+
+// MinSpacing is the least distance between the pixels of a Deep
+// fractal; the differences of their orbits from the reference orbit
+// must not underflow a float64.
+const MinSpacing = 1e-290
+
+// Precision returns the number of bits of mantissa needed to tell apart
+// the points spacing apart near the Mandelbrot set.
+func Precision(spacing float64) uint {
+	// 2 bits for the magnitude of the points, 32 for the iterations
+	return uint(math.Max(53, 2-math.Log2(spacing)+32))
+}
+
+// ParsePoint parses a point "x,y" with prec bits of precision.
+func ParsePoint(s string, prec uint) (x, y *big.Float, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("point %q is not x,y", s)
+	}
+	x, _, err = big.ParseFloat(strings.TrimSpace(parts[0]), 10, prec, big.ToNearestEven)
+	if err != nil {
+		return nil, nil, fmt.Errorf("point %q: %v", s, err)
+	}
+	y, _, err = big.ParseFloat(strings.TrimSpace(parts[1]), 10, prec, big.ToNearestEven)
+	if err != nil {
+		return nil, nil, fmt.Errorf("point %q: %v", s, err)
+	}
+	return x, y, nil
+}
+
+// A Deep is the Mandelbrot set around a center given to any precision.
+// Its At method takes the difference of a point from the center.
+type Deep struct {
+	orbit      []complex128 // the reference orbit of the center
+	iterations int
+}
+
+// NewDeep returns the Mandelbrot set of Iterations iterations around
+// (cx, cy), whose reference orbit it computes at their precision.
+func NewDeep(cx, cy *big.Float, iterations int) *Deep {
+	return &Deep{referenceOrbit(cx, cy, iterations), iterations}
+}
+
+func (d *Deep) At(dc complex128) Value {
+	n, z := perturbed(d.orbit, dc, d.iterations)
+	if n < 0 {
+		return Value{In: true}
+	}
+	return escaped(n, z, 2)
+}
+
+// referenceOrbit returns the orbit Z(0) = 0, Z(1), ... of the point
+// (cx, cy) computed at their precision, rounded to complex128 values,
+// to the first point past the bailout radius or to Z(iterations).
+func referenceOrbit(cx, cy *big.Float, iterations int) []complex128 {
+	prec := cx.Prec()
+	x, y := new(big.Float).SetPrec(prec), new(big.Float).SetPrec(prec)
+	xx, yy, xy := new(big.Float).SetPrec(prec), new(big.Float).SetPrec(prec), new(big.Float).SetPrec(prec)
+	orbit := []complex128{0}
+	for n := 0; n < iterations; n++ {
+		// (x + iy)² + c = x² - y² + cx + i(2xy + cy)
+		xx.Mul(x, x)
+		yy.Mul(y, y)
+		xy.Mul(x, y)
+		x.Sub(xx, yy).Add(x, cx)
+		y.Add(xy, xy).Add(y, cy)
+		fx, _ := x.Float64()
+		fy, _ := y.Float64()
+		orbit = append(orbit, complex(fx, fy))
+		if fx*fx+fy*fy > bailout*bailout {
+			break
+		}
+	}
+	return orbit
+}
+
+// perturbed returns the number of iterations after which the orbit of
+// the point dc from the start of the reference orbit escapes, and the
+// point it escapes to, or -1 if it stays within the bailout radius for
+// all of them.
+func perturbed(orbit []complex128, dc complex128, iterations int) (int, complex128) {
+	var d complex128 // z(n) - orbit[ref]
+	ref := 0
+	for n := 0; n < iterations; n++ {
+		d = (2*orbit[ref]+d)*d + dc
+		ref++
+		z := orbit[ref] + d
+		if abs2(z) > bailout*bailout {
+			return n, z
+		}
+		// Where z comes nearer 0 than the reference orbit is to it, or
+		// the reference orbit ends, go on from the start of the
+		// reference orbit, which z follows more closely. Otherwise
+		// the errors of d grow until the pixels around come out in a
+		// single color.
+		if abs2(z) < abs2(d) || ref == len(orbit)-1 {
+			d, ref = z, 0
+		}
+	}
+	return -1, 0
+}
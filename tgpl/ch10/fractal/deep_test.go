@@ -0,0 +1,61 @@
+package fractal
+
+import (
+	"math/big"
+	"testing"
+)
+
+// bigEscape returns the iteration at which the orbit of the point
+// (cx+dx, cy+dy) escapes, or -1, computed with big.Floats of 300 bits.
+func bigEscape(cx, cy *big.Float, dx, dy float64, iterations int) int {
+	const prec = 300
+	newFloat := func() *big.Float { return new(big.Float).SetPrec(prec) }
+	x0, y0 := newFloat().Add(cx, big.NewFloat(dx)), newFloat().Add(cy, big.NewFloat(dy))
+	x, y, xx, yy, xy := newFloat(), newFloat(), newFloat(), newFloat(), newFloat()
+	for n := 0; n < iterations; n++ {
+		xx.Mul(x, x)
+		yy.Mul(y, y)
+		xy.Mul(x, y)
+		x.Sub(xx, yy).Add(x, x0)
+		y.Add(xy, xy).Add(y, y0)
+		fx, _ := x.Float64()
+		fy, _ := y.Float64()
+		if fx*fx+fy*fy > bailout*bailout {
+			return n
+		}
+	}
+	return -1
+}
+
+// TestPerturbed checks the escape iterations of the pixels of deep
+// zooms against those computed in high precision throughout. A few may
+// differ, since a point that escapes late is sensitive to the slightest
+// error.
+func TestPerturbed(t *testing.T) {
+	const iterations = 3000
+	cx, cy, err := ParsePoint("-0.743643887037158704752191506114774,0.131825904205311970493132056385139", 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orbit := referenceOrbit(cx, cy, iterations)
+	for _, spacing := range []float64{1e-6, 1e-9, 1e-13} {
+		wrong, out := 0, 0
+		for py := -10; py < 10; py++ {
+			for px := -10; px < 10; px++ {
+				dx, dy := float64(px)*spacing, float64(py)*spacing
+				got, _ := perturbed(orbit, complex(dx, dy), iterations)
+				want := bigEscape(cx, cy, dx, dy, iterations)
+				if got != want {
+					wrong++
+				}
+				if want >= 0 {
+					out++
+				}
+			}
+		}
+		if wrong > 4 {
+			t.Errorf("spacing %g: %d of 400 pixels wrong", spacing, wrong)
+		}
+		t.Logf("spacing %g: %d of 400 escaped, %d wrong", spacing, out, wrong)
+	}
+}
@@ -0,0 +1,360 @@
+// Package fractal renders fractals of the complex plane in parallel,
+// with anti-aliasing and smooth coloring from named palettes.
+package fractal
+
+import (
+	"digest_gopl/ch7/eval"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Fractal computes the value of each point of the complex plane that
+// a Palette colors. Its At method is called from several goroutines at
+// once.
+type Fractal interface {
+	At(z complex128) Value
+}
+
+// A Value is the result of a Fractal at a point.
+type Value struct {
+	In    bool    // the point is in the set: it neither escaped nor converged
+	N     float64 // the smooth count of the iterations to escape or converge
+	ByHue bool    // whether to color by Hue, shaded by N, rather than by N
+	Hue   float64 // the position in the palette, from 0 to 1
+}
+
+// bailout is the radius of the circle that an orbit escapes. The
+// orbits of the Mandelbrot set stay within radius 2, but going on to a
+// greater radius makes the smooth count of iterations smoother.
+const bailout = 1 << 8
+
+// escaped returns the Value of a point whose orbit escaped to z at
+// iteration n. The smooth count of iterations, which interpolates
+// between whole ones by how far z overshot the bailout radius, gives
+// bands of color without steps. For the iteration z ↦ z^d + c,
+// |z| ≈ bailout^(d^t) at the fraction t of an iteration past the
+// bailout radius.
+func escaped(n int, z complex128, degree float64) Value {
+	t := math.Log(math.Log(cmplx.Abs(z))/math.Log(bailout)) / math.Log(degree)
+	return Value{N: math.Max(0, float64(n)+1-t)}
+}
+
+// Mandelbrot is the Mandelbrot set: the points c for which the orbit
+// of 0 under z ↦ z² + c stays within the circle of radius 2 for
+// Iterations iterations.
+type Mandelbrot struct{ Iterations int }
+
+func (m Mandelbrot) At(c complex128) Value {
+	var z complex128
+	for n := 0; n < m.Iterations; n++ {
+		z = z*z + c
+		if abs2(z) > bailout*bailout {
+			return escaped(n, z, 2)
+		}
+	}
+	return Value{In: true}
+}
+
+// Julia is the Julia set of z ↦ z² + C: the points z whose orbits stay
+// within the circle of radius max(2, |C|) for Iterations iterations.
+type Julia struct {
+	C          complex128
+	Iterations int
+}
+
+func (j Julia) At(z complex128) Value {
+	for n := 0; n < j.Iterations; n++ {
+		z = z*z + j.C
+		if abs2(z) > bailout*bailout {
+			return escaped(n, z, 2)
+		}
+	}
+	return Value{In: true}
+}
+
+// BurningShip is the set of the points c whose orbits of 0 under
+// z ↦ (|Re z| + i|Im z|)² + c stay bounded. It shows its ship the right
+// way up with the imaginary axis pointing down, as the rows of an image
+// do.
+type BurningShip struct{ Iterations int }
+
+func (b BurningShip) At(c complex128) Value {
+	var z complex128
+	for n := 0; n < b.Iterations; n++ {
+		z = complex(math.Abs(real(z)), math.Abs(imag(z)))
+		z = z*z + c
+		if abs2(z) > bailout*bailout {
+			return escaped(n, z, 2)
+		}
+	}
+	return Value{In: true}
+}
+
+// Multibrot is the set of the points c whose orbits of 0 under
+// z ↦ z^Degree + c stay bounded, a Mandelbrot set of Degree - 1 lobes.
+type Multibrot struct {
+	Degree     int // 2 or more
+	Iterations int
+}
+
+// maxDegree is the greatest degree of a Multibrot set that New makes.
+const maxDegree = 64
+
+func (m Multibrot) At(c complex128) Value {
+	var z complex128
+	for n := 0; n < m.Iterations; n++ {
+		z = power(z, m.Degree) + c
+		if abs2(z) > bailout*bailout {
+			return escaped(n, z, float64(m.Degree))
+		}
+	}
+	return Value{In: true}
+}
+
+// Formula is the set of the points c whose orbits of 0 under
+// z ↦ f(z, c) stay bounded, for f an expression of package eval in the
+// complex variables z and c, such as z*z*z + c or sin(z) + c. The
+// smooth count of iterations supposes that f is of degree 2 in z, as
+// for the Mandelbrot set, z*z + c.
+type Formula struct {
+	f          eval.Expr // checked, and with its definitions expanded
+	ctx        *eval.Context
+	iterations int
+}
+
+// NewFormula returns the Formula of the expression src.
+func NewFormula(src string, iterations int) (*Formula, error) {
+	e, err := eval.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[eval.Var]bool)
+	if err := e.Check(vars); err != nil {
+		return nil, err
+	}
+	for v := range vars {
+		if v != "z" && v != "c" {
+			return nil, fmt.Errorf("undefined variable: %s", v)
+		}
+	}
+	t, err := e.CheckType(eval.TypeEnv{"z": eval.Complex, "c": eval.Complex})
+	if err != nil {
+		return nil, err
+	}
+	if t == eval.Bool {
+		return nil, fmt.Errorf("%s is a bool, not a number", e)
+	}
+	// once, rather than at every iteration
+	if e, err = eval.Expand(e); err != nil {
+		return nil, err
+	}
+	return &Formula{e, &eval.Context{}, iterations}, nil
+}
+
+func (f *Formula) At(c complex128) Value {
+	var z complex128
+	env := eval.ValueEnv{"c": eval.ComplexValue(c)} // of this call, as At runs in parallel
+	for n := 0; n < f.iterations; n++ {
+		env["z"] = eval.ComplexValue(z)
+		v, err := eval.EvalTyped(f.f, f.ctx, env)
+		if err != nil {
+			return Value{In: true} // the overflow of Int arithmetic
+		}
+		z = v.Complex()
+		if abs2(z) > bailout*bailout {
+			return escaped(n, z, 2)
+		}
+	}
+	return Value{In: true}
+}
+
+// Newton is Newton's method for finding the roots of a polynomial: each
+// point is colored by the root its iterations converge to, darker the
+// more iterations it takes.
+type Newton struct {
+	p, dp      Poly
+	roots      []complex128
+	iterations int
+}
+
+// maxCoefficients is the most coefficients of a polynomial of Newton,
+// whose roots take time quadratic in their number to find.
+const maxCoefficients = 32
+
+// NewNewton returns Newton's method for p, of degree 1 to
+// maxCoefficients - 1, with a hue for each of the roots of p.
+func NewNewton(p Poly, iterations int) (*Newton, error) {
+	p = p.trim()
+	if len(p) < 2 {
+		return nil, fmt.Errorf("polynomial %v has no roots", []complex128(p))
+	}
+	if len(p) > maxCoefficients {
+		return nil, fmt.Errorf("polynomial of degree %d, more than %d", len(p)-1, maxCoefficients-1)
+	}
+	return &Newton{p, p.Derivative(), p.Roots(), iterations}, nil
+}
+
+func (f *Newton) At(z complex128) Value {
+	const tolerance = 1e-6
+	for n := 0; n < f.iterations; n++ {
+		step := f.p.Eval(z) / f.dp.Eval(z)
+		if cmplx.IsNaN(step) || cmplx.IsInf(step) { // at a root of p'
+			break
+		}
+		z -= step
+		if d := cmplx.Abs(step); d < tolerance {
+			return converged(n, d, tolerance, f.nearest(z), len(f.roots))
+		}
+	}
+	return Value{In: true}
+}
+
+// nearest returns the index of the root nearest z.
+func (f *Newton) nearest(z complex128) int {
+	best := 0
+	for i, root := range f.roots {
+		if cmplx.Abs(z-root) < cmplx.Abs(z-f.roots[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// converged returns the Value of a point whose iterations took a step
+// d < tolerance, toward root i of n roots, at iteration n. Near a
+// simple root the step is squared at each iteration, so log d doubles.
+func converged(n int, d, tolerance float64, i, roots int) Value {
+	t := 0.0
+	if d > 0 {
+		t = math.Min(1, math.Log2(math.Log(d)/math.Log(tolerance)))
+	}
+	return Value{N: math.Max(0, float64(n)-t), ByHue: true, Hue: (float64(i) + 0.5) / float64(roots)}
+}
+
+// A Func colors each point z by the argument of f(z), around the
+// palette, darker the greater |f(z)|.
+type Func func(z complex128) complex128
+
+func (f Func) At(z complex128) Value {
+	v := f(z)
+	if cmplx.IsNaN(v) || cmplx.IsInf(v) {
+		return Value{In: true}
+	}
+	hue := cmplx.Phase(v)/(2*math.Pi) + 0.5
+	return Value{N: math.Max(0, math.Log2(cmplx.Abs(v))*8), ByHue: true, Hue: hue}
+}
+
+// power returns z^d, for d ≥ 1, by repeated squaring.
+func power(z complex128, d int) complex128 {
+	for ; d&1 == 0; d >>= 1 {
+		z *= z
+	}
+	w := z
+	for d >>= 1; d > 0; d >>= 1 {
+		z *= z
+		if d&1 == 1 {
+			w *= z
+		}
+	}
+	return w
+}
+
+func abs2(z complex128) float64 { return real(z)*real(z) + imag(z)*imag(z) }
+
+// fractals maps the names of New to constructors of fractals from
+// their parameter, "" for the default, and number of iterations.
+var fractals = map[string]func(param string, iterations int) (Fractal, error){
+	"mandelbrot":  plain(func(iterations int) Fractal { return Mandelbrot{iterations} }),
+	"burningship": plain(func(iterations int) Fractal { return BurningShip{iterations} }),
+	"acos":        plain(func(int) Fractal { return Func(cmplx.Acos) }),
+	"sqrt":        plain(func(int) Fractal { return Func(cmplx.Sqrt) }),
+	"julia": func(param string, iterations int) (Fractal, error) {
+		if param == "" {
+			param = "-0.8+0.156i"
+		}
+		c, err := strconv.ParseComplex(param, 128)
+		if err != nil {
+			return nil, err
+		}
+		return Julia{c, iterations}, nil
+	},
+	"multibrot": func(param string, iterations int) (Fractal, error) {
+		if param == "" {
+			param = "3"
+		}
+		d, err := strconv.Atoi(param)
+		if err != nil || d < 2 || d > maxDegree {
+			return nil, fmt.Errorf("degree %q is not a whole number from 2 to %d", param, maxDegree)
+		}
+		return Multibrot{d, iterations}, nil
+	},
+	"formula": func(param string, iterations int) (Fractal, error) {
+		if param == "" {
+			param = "z*z + c"
+		}
+		return NewFormula(param, iterations)
+	},
+	"newton": func(param string, iterations int) (Fractal, error) {
+		if param == "" {
+			param = "1,0,0,0,-1" // z⁴ - 1
+		}
+		coeffs := strings.Split(param, ",")
+		if len(coeffs) > maxCoefficients {
+			return nil, fmt.Errorf("more than %d coefficients", maxCoefficients)
+		}
+		var p Poly
+		for _, s := range coeffs {
+			c, err := strconv.ParseComplex(strings.TrimSpace(s), 128)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, c)
+		}
+		return NewNewton(p, iterations)
+	},
+}
+
+// plain adapts the constructor of a fractal without a parameter.
+func plain(f func(iterations int) Fractal) func(string, int) (Fractal, error) {
+	return func(param string, iterations int) (Fractal, error) {
+		if param != "" {
+			return nil, fmt.Errorf("no parameter %q wanted", param)
+		}
+		return f(iterations), nil
+	}
+}
+
+// New returns the fractal of the given spec, iterating at most
+// iterations times at each point. The spec is a name, which some
+// fractals follow with a colon and a parameter in place of a default:
+//
+//	julia:C         the Julia set of z ↦ z² + C, such as julia:-0.8+0.156i
+//	multibrot:D     the Multibrot set of z ↦ z^D + c, D up to 64, such as multibrot:3
+//	newton:A,B,...  Newton's method for A·zⁿ + B·zⁿ⁻¹ + ..., up to 32 coefficients, such as newton:1,0,0,0,-1
+//	formula:F       the set of z ↦ F, an expression in z and c, such as formula:sin(z) + c
+func New(spec string, iterations int) (Fractal, error) {
+	name, param, _ := strings.Cut(spec, ":")
+	f, ok := fractals[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown fractal %q", name)
+	}
+	fr, err := f(param, iterations)
+	if err != nil {
+		return nil, fmt.Errorf("fractal %s: %v", spec, err)
+	}
+	return fr, nil
+}
+
+// Names returns the names of the fractals that New knows, in order.
+func Names() []string {
+	var names []string
+	for name := range fractals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,123 @@
+package fractal
+
+import (
+	"math/cmplx"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	for _, test := range []struct {
+		spec string
+		want Fractal // nil for an error
+	}{
+		{"mandelbrot", Mandelbrot{10}},
+		{"burningship", BurningShip{10}},
+		{"julia", Julia{-0.8 + 0.156i, 10}},
+		{"julia:0.285+0.01i", Julia{0.285 + 0.01i, 10}},
+		{"julia:-1", Julia{-1, 10}},
+		{"multibrot", Multibrot{3, 10}},
+		{"multibrot:5", Multibrot{5, 10}},
+		{"multibrot:1", nil},
+		{"multibrot:64", Multibrot{64, 10}},
+		{"multibrot:65", nil},
+		{"multibrot:100000000", nil},
+		{"multibrot:2.5", nil},
+		{"julia:x", nil},
+		{"mandelbrot:3", nil},
+		{"newton:0,0,7", nil}, // no roots
+		{"newton:1,x", nil},
+		{"newton:" + strings.Repeat("1,", 32) + "1", nil}, // 33 coefficients
+		{"plaid", nil},
+	} {
+		got, err := New(test.spec, 10)
+		if test.want == nil {
+			if err == nil {
+				t.Errorf("New(%q) = %v, want an error", test.spec, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("New(%q) = %v, %v, want %v", test.spec, got, err, test.want)
+		}
+	}
+}
+
+func TestMultibrot(t *testing.T) {
+	m, mb := Mandelbrot{50}, Multibrot{2, 50}
+	for _, c := range []complex128{0, -1, 0.3 + 0.5i, -0.75 + 0.1i, 2, -2.1i} {
+		if got, want := mb.At(c), m.At(c); got != want {
+			t.Errorf("Multibrot of degree 2 at %v = %v, Mandelbrot %v", c, got, want)
+		}
+	}
+}
+
+func TestFormula(t *testing.T) {
+	m := Mandelbrot{50}
+	for _, spec := range []string{"formula", "formula:z*z + c", "formula:let w = z; c + w*w"} {
+		f, err := New(spec, 50)
+		if err != nil {
+			t.Fatalf("New(%q): %v", spec, err)
+		}
+		for x := -2.0; x <= 1; x += 0.25 {
+			for y := -1.5; y <= 1.5; y += 0.25 {
+				c := complex(x, y)
+				if got, want := f.At(c), m.At(c); got != want {
+					t.Errorf("%s at %v = %v, Mandelbrot %v", spec, c, got, want)
+				}
+			}
+		}
+	}
+	for _, spec := range []string{
+		"formula:z +",           // syntax
+		"formula:z*z + x",       // undefined variable
+		"formula:sqrt(z, c)",    // arity
+		"formula:z < c",         // complex ordered
+		"formula:z == c",        // a bool
+		"formula:max(z, c)",     // no complex max
+		"formula:jn(2000, z)",   // bad order
+		"formula:f(t) = t; f()", // arity of a definition
+	} {
+		if f, err := New(spec, 10); err == nil {
+			t.Errorf("New(%q) = %v, want an error", spec, f)
+		}
+	}
+}
+
+func TestPower(t *testing.T) {
+	for _, z := range []complex128{0, 1, -1, 1i, 0.5 - 0.7i, 1.1 + 0.2i} {
+		want := z
+		for d := 1; d <= 20; d++ {
+			if got := power(z, d); cmplx.Abs(got-want) > 1e-12*cmplx.Abs(want) {
+				t.Errorf("power(%v, %d) = %v, want %v", z, d, got, want)
+			}
+			want *= z
+		}
+	}
+}
+
+func TestNewton(t *testing.T) {
+	for _, spec := range []string{"newton", "newton:1,0,-2,2", "newton:1,0,-3,2", "newton:1, -1i, 0, 1i"} {
+		fr, err := New(spec, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := fr.(*Newton)
+		// the points next to each root converge to it, each in a hue of
+		// its own
+		hues := make(map[float64]bool)
+		for _, root := range f.roots {
+			v := f.At(root + 1e-3)
+			if v.In || !v.ByHue || hues[v.Hue] {
+				t.Errorf("%s: %v next to root %v", spec, v, root)
+			}
+			hues[v.Hue] = true
+		}
+		// and every root is one of p
+		for _, root := range f.roots {
+			if cmplx.Abs(f.p.Eval(root)) > 1e-6 {
+				t.Errorf("%s: p(%v) = %v", spec, root, f.p.Eval(root))
+			}
+		}
+	}
+}
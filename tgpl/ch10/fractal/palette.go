@@ -0,0 +1,76 @@
+package fractal
+
+import (
+	"image/color"
+	"math"
+	"sort"
+)
+
+// A Palette is a cyclic gradient through evenly spaced colors, with
+// another color for the points in a set.
+type Palette struct {
+	Stops  []color.RGBA
+	Inside color.RGBA
+}
+
+var black = color.RGBA{0x00, 0x00, 0x00, 0xff}
+
+// Palettes maps names to the palettes there are.
+var Palettes = map[string]*Palette{
+	// as in the book: white, darker as the iterations grow
+	"gray": {Stops: []color.RGBA{{0xff, 0xff, 0xff, 0xff}, black}, Inside: black},
+	"fire": {Stops: []color.RGBA{
+		{0x00, 0x00, 0x00, 0xff}, {0x80, 0x00, 0x00, 0xff}, {0xff, 0x40, 0x00, 0xff},
+		{0xff, 0xc0, 0x00, 0xff}, {0xff, 0xff, 0xc0, 0xff},
+	}, Inside: black},
+	"ocean": {Stops: []color.RGBA{
+		{0x00, 0x07, 0x40, 0xff}, {0x00, 0x40, 0xa0, 0xff}, {0x00, 0xc0, 0xe0, 0xff}, {0xe0, 0xff, 0xff, 0xff},
+	}, Inside: black},
+	"rainbow": {Stops: []color.RGBA{
+		{0xff, 0x00, 0x00, 0xff}, {0xff, 0xff, 0x00, 0xff}, {0x00, 0xff, 0x00, 0xff},
+		{0x00, 0xff, 0xff, 0xff}, {0x00, 0x00, 0xff, 0xff}, {0xff, 0x00, 0xff, 0xff},
+	}, Inside: black},
+	// the gradient of Ultra Fractal's default coloring
+	"classic": {Stops: []color.RGBA{
+		{0x00, 0x07, 0x64, 0xff}, {0x20, 0x6b, 0xcb, 0xff}, {0xed, 0xff, 0xff, 0xff},
+		{0xff, 0xaa, 0x00, 0xff}, {0x00, 0x02, 0x00, 0xff},
+	}, Inside: black},
+}
+
+// PaletteNames returns the names of Palettes, in order.
+func PaletteNames() []string {
+	var names []string
+	for name := range Palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// At returns the color at t of the gradient, which repeats every 1.
+func (p *Palette) At(t float64) color.RGBA {
+	t -= math.Floor(t)
+	x := t * float64(len(p.Stops))
+	i := int(x)
+	if i >= len(p.Stops) { // t just below 1 rounded up
+		i = len(p.Stops) - 1
+	}
+	a, b := p.Stops[i], p.Stops[(i+1)%len(p.Stops)]
+	f := x - float64(i)
+	mix := func(a, b uint8) uint8 { return uint8(float64(a) + f*(float64(b)-float64(a)) + 0.5) }
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 0xff}
+}
+
+// Color returns the color of v, going once through the gradient every
+// cycle iterations.
+func (p *Palette) Color(v Value, cycle float64) color.RGBA {
+	switch {
+	case v.In:
+		return p.Inside
+	case v.ByHue:
+		c := p.At(v.Hue)
+		shade := 1 / (1 + v.N/cycle)
+		return color.RGBA{uint8(float64(c.R) * shade), uint8(float64(c.G) * shade), uint8(float64(c.B) * shade), 0xff}
+	}
+	return p.At(v.N / cycle)
+}
@@ -0,0 +1,119 @@
+package fractal
+
+import (
+	"math"
+	"math/cmplx"
+	"sort"
+)
+
+// A Poly is a polynomial with complex coefficients, from that of the
+// highest power of z down to the constant.
+type Poly []complex128
+
+// trim returns p without leading zero coefficients.
+func (p Poly) trim() Poly {
+	for len(p) > 0 && p[0] == 0 {
+		p = p[1:]
+	}
+	return p
+}
+
+// Degree returns the degree of p, or -1 if p is zero.
+func (p Poly) Degree() int { return len(p.trim()) - 1 }
+
+// Eval returns p(z).
+func (p Poly) Eval(z complex128) complex128 {
+	var v complex128
+	for _, c := range p {
+		v = v*z + c
+	}
+	return v
+}
+
+// Derivative returns the derivative of p.
+func (p Poly) Derivative() Poly {
+	p = p.trim()
+	if len(p) < 2 {
+		return nil
+	}
+	n := len(p) - 1
+	d := make(Poly, n)
+	for i := range d {
+		d[i] = p[i] * complex(float64(n-i), 0)
+	}
+	return d
+}
+
+// Roots returns the distinct roots of p, in order of their arguments
+// from 0 to 2π. It improves guesses at all of them at once by the
+// Durand–Kerner method: each moves by p(r)/∏(r - s) over the others s,
+// its Newton step for p divided by the factors of the other roots.
+// Roots nearer together than 1e-4 of the bound on them are taken for
+// one repeated root, which the method comes near only slowly.
+func (p Poly) Roots() []complex128 {
+	p = p.trim()
+	n := len(p) - 1
+	if n < 1 {
+		return nil
+	}
+	monic := make(Poly, len(p))
+	bound := 0.0 // on the roots: 1 + max |a(i)/a(n)|
+	for i, c := range p {
+		monic[i] = c / p[0]
+		if i > 0 {
+			bound = math.Max(bound, cmplx.Abs(monic[i]))
+		}
+	}
+	bound++
+
+	// start from points around a circle, off the axes of symmetry
+	guesses := make([]complex128, n)
+	for i := range guesses {
+		guesses[i] = cmplx.Rect(bound, 2*math.Pi*float64(i)/float64(n)+0.4)
+	}
+	for iter := 0; iter < 1000; iter++ {
+		change := 0.0
+		for i, r := range guesses {
+			d := complex(1, 0)
+			for j, s := range guesses {
+				if j != i {
+					d *= r - s
+				}
+			}
+			if d == 0 {
+				continue
+			}
+			step := monic.Eval(r) / d
+			guesses[i] = r - step
+			change = math.Max(change, cmplx.Abs(step))
+		}
+		if change < 1e-15*bound {
+			break
+		}
+	}
+
+	// The guesses at a repeated root are spread around it, so their mean
+	// is nearer it than any of them.
+	var roots []complex128
+	var counts []int
+next:
+	for _, r := range guesses {
+		for i, s := range roots {
+			if cmplx.Abs(r-s) < 1e-4*bound {
+				counts[i]++
+				roots[i] += (r - s) / complex(float64(counts[i]), 0)
+				continue next
+			}
+		}
+		roots, counts = append(roots, r), append(counts, 1)
+	}
+	arg := func(z complex128) float64 {
+		a := cmplx.Phase(z)
+		if a < -1e-9 { // not just below the positive real axis
+			a += 2 * math.Pi
+		}
+		return a
+	}
+	sort.Slice(roots, func(i, j int) bool { return arg(roots[i]) < arg(roots[j]) })
+	return roots
+}
@@ -0,0 +1,50 @@
+package fractal
+
+import (
+	"math/cmplx"
+	"testing"
+)
+
+func TestRoots(t *testing.T) {
+	for _, test := range []struct {
+		p    Poly
+		want []complex128
+	}{
+		{Poly{1, 0, 0, 0, -1}, []complex128{1, 1i, -1, -1i}},
+		{Poly{0, 0, 2, -4}, []complex128{2}}, // leading zeros
+		{Poly{1, 0, 1}, []complex128{1i, -1i}},
+		{Poly{1, -2i}, []complex128{2i}},
+		{Poly{1, 0, -3, 2}, []complex128{1, -2}},             // (z - 1)²(z + 2)
+		{Poly{1, -3, 3, -1}, []complex128{1}},                // (z - 1)³
+		{Poly{1, -1000 - 1i, 1000i}, []complex128{1000, 1i}}, // (z - 1000)(z - i)
+		{Poly{5}, nil},
+	} {
+		got := test.p.Roots()
+		if len(got) != len(test.want) {
+			t.Errorf("%v.Roots() = %v, want %v", test.p, got, test.want)
+			continue
+		}
+		for i, root := range got {
+			// a triple root comes out only within about the cube
+			// root of the rounding errors of p
+			want := test.want[i]
+			if cmplx.Abs(root-want) > 1e-4*cmplx.Abs(want) {
+				t.Errorf("%v.Roots() = %v, want %v", test.p, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	p := Poly{1, 0, -3, 2} // z³ - 3z + 2
+	if got := p.Eval(2i); got != -14i+2 {
+		t.Errorf("p(2i) = %v, want %v", got, 2-14i)
+	}
+	if got, want := p.Derivative(), (Poly{3, 0, -3}); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("p' = %v, want %v", got, want)
+	}
+	if got := (Poly{0, 0, 7}).Degree(); got != 0 {
+		t.Errorf("degree of 7 = %d, want 0", got)
+	}
+}
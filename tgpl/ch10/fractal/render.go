@@ -0,0 +1,88 @@
+package fractal
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"runtime"
+	"sync"
+)
+
+// A Renderer draws the window Min..Max of the complex plane of a
+// Fractal, with Min at the top left corner of the image and Max at the
+// bottom right.
+type Renderer struct {
+	Fractal  Fractal
+	Palette  *Palette
+	Min, Max complex128
+	Samples  int     // per pixel along each axis, averaged to smooth edges; 0 means 1
+	Cycle    float64 // iterations per cycle through the palette; 0 means 32
+	Workers  int     // goroutines rendering rows; 0 means runtime.NumCPU()
+}
+
+// Render returns an image of the window width by height pixels. The
+// rows are shared out among the workers as each becomes free, since
+// some take far longer than others. If ctx is done first, Render stops
+// and returns ctx.Err().
+func (r *Renderer) Render(ctx context.Context, width, height int) (*image.RGBA, error) {
+	workers := r.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	rows := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for py := range rows {
+				r.row(img, py)
+			}
+		}()
+	}
+	var err error
+loop:
+	for py := 0; py < height; py++ {
+		select {
+		case rows <- py:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break loop
+		}
+	}
+	close(rows)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// row renders row py of img.
+func (r *Renderer) row(img *image.RGBA, py int) {
+	samples, cycle := r.Samples, r.Cycle
+	if samples <= 0 {
+		samples = 1
+	}
+	if cycle <= 0 {
+		cycle = 32
+	}
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	dx := (real(r.Max) - real(r.Min)) / float64(width)
+	dy := (imag(r.Max) - imag(r.Min)) / float64(height)
+	n := float64(samples * samples)
+	for px := 0; px < width; px++ {
+		// average the colors at the centers of a grid of subpixels
+		var red, green, blue float64
+		for i := 0; i < samples; i++ {
+			y := imag(r.Min) + (float64(py)+(float64(i)+0.5)/float64(samples))*dy
+			for j := 0; j < samples; j++ {
+				x := real(r.Min) + (float64(px)+(float64(j)+0.5)/float64(samples))*dx
+				c := r.Palette.Color(r.Fractal.At(complex(x, y)), cycle)
+				red, green, blue = red+float64(c.R), green+float64(c.G), blue+float64(c.B)
+			}
+		}
+		img.SetRGBA(px, py, color.RGBA{uint8(red/n + 0.5), uint8(green/n + 0.5), uint8(blue/n + 0.5), 0xff})
+	}
+}
@@ -0,0 +1,108 @@
+package fractal
+
+import (
+	"bytes"
+	"context"
+	"image/color"
+	"testing"
+)
+
+// halfPlane is the set of points left of x = 0, outside which the
+// points escape at once.
+type halfPlane struct{}
+
+func (halfPlane) At(z complex128) Value { return Value{In: real(z) < 0} }
+
+func TestRenderWorkers(t *testing.T) {
+	r := Renderer{Fractal: Mandelbrot{100}, Palette: Palettes["classic"], Min: -2 - 2i, Max: 2 + 2i, Samples: 2}
+	r.Workers = 1
+	one, err := r.Render(context.Background(), 64, 48)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Workers = 7
+	seven, err := r.Render(context.Background(), 64, 48)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(one.Pix, seven.Pix) {
+		t.Error("images rendered by 1 and 7 workers differ")
+	}
+}
+
+func TestRenderSamples(t *testing.T) {
+	// the middle pixel of 3 spans x = -0.5 to 0.5; the subpixels
+	// inside are black and those outside white
+	for _, test := range []struct {
+		samples int
+		want    uint8 // the gray of the middle pixel
+	}{
+		{1, 0xff}, // its center x = 0 is outside
+		{2, 0x80}, // half the subpixels are inside
+		{3, 0xaa}, // one column of three is inside
+		{4, 0x80},
+		{0, 0xff}, // meaning 1
+	} {
+		r := Renderer{Fractal: halfPlane{}, Palette: Palettes["gray"], Min: -1.5 - 1i, Max: 1.5 + 1i, Samples: test.samples}
+		img, err := r.Render(context.Background(), 3, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := img.RGBAAt(1, 0); got != (color.RGBA{test.want, test.want, test.want, 0xff}) {
+			t.Errorf("samples %d: middle pixel %v, want gray %#x", test.samples, got, test.want)
+		}
+	}
+}
+
+func TestRenderCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := Renderer{Fractal: Mandelbrot{100}, Palette: Palettes["gray"], Min: -2 - 2i, Max: 2 + 2i}
+	if img, err := r.Render(ctx, 1000, 1000); err != context.Canceled || img != nil {
+		t.Errorf("Render after cancel = %v, %v, want nil, %v", img, err, context.Canceled)
+	}
+}
+
+func TestPalette(t *testing.T) {
+	p := &Palette{Stops: []color.RGBA{{0, 0, 0, 0xff}, {200, 100, 0, 0xff}}, Inside: color.RGBA{1, 2, 3, 0xff}}
+	for _, test := range []struct {
+		t    float64
+		want color.RGBA
+	}{
+		{0, color.RGBA{0, 0, 0, 0xff}},
+		{0.25, color.RGBA{100, 50, 0, 0xff}},
+		{0.5, color.RGBA{200, 100, 0, 0xff}},
+		{0.75, color.RGBA{100, 50, 0, 0xff}}, // and back
+		{1, color.RGBA{0, 0, 0, 0xff}},
+		{-0.25, color.RGBA{100, 50, 0, 0xff}},
+		{3.5, color.RGBA{200, 100, 0, 0xff}},
+	} {
+		if got := p.At(test.t); got != test.want {
+			t.Errorf("At(%g) = %v, want %v", test.t, got, test.want)
+		}
+	}
+	if got := p.Color(Value{In: true, N: 5}, 32); got != p.Inside {
+		t.Errorf("Color of a point inside = %v, want %v", got, p.Inside)
+	}
+	if got, want := p.Color(Value{N: 16}, 32), p.At(0.5); got != want {
+		t.Errorf("Color of 16 iterations, cycle 32 = %v, want %v", got, want)
+	}
+}
+
+// TestSmooth checks that the smooth count of iterations varies
+// continuously across the bands of whole iterations.
+func TestSmooth(t *testing.T) {
+	m := Mandelbrot{1000}
+	// along the real axis left of the set, where the count falls as
+	// the points go farther from -2
+	const from, to, steps = -2.1, -6.0, 10000
+	x := func(i int) float64 { return from + (to-from)*float64(i)/steps }
+	prev := m.At(complex(x(0), 0))
+	for i := 1; i <= steps; i++ {
+		v := m.At(complex(x(i), 0))
+		if v.In || v.N > prev.N || prev.N-v.N > 0.01 {
+			t.Fatalf("count goes from %g to %g at %g", prev.N, v.N, x(i))
+		}
+		prev = v
+	}
+}
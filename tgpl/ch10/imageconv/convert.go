@@ -0,0 +1,170 @@
+// Package imageconv converts images from any format registered with
+// package image to GIF, JPEG or PNG, keeping the frames of animated
+// GIFs.
+package imageconv
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// Options control the output of Convert.
+type Options struct {
+	Format  string // "gif", "jpeg" ("jpg") or "png"; "" for the format of the input
+	Quality int    // of JPEG, 1 to 100; 0 for jpeg.DefaultQuality
+	Frame   int    // of an animated GIF, to write in a format of still images
+
+	// Palette is the palette of GIF output: "plan9" or "websafe", or
+	// "adaptive", a median cut of the colors of each frame to at most
+	// Colors colors, 0 for 256. "" keeps the palettes of GIF input and
+	// is "adaptive" for other input.
+	Palette string
+	Colors  int
+	Dither  bool // diffuse the errors of the palette, Floyd–Steinberg
+}
+
+var palettes = map[string]color.Palette{
+	"plan9":    palette.Plan9,
+	"websafe":  palette.WebSafe,
+	"adaptive": nil,
+}
+
+// Formats returns the output formats, in order.
+func Formats() []string { return []string{"gif", "jpeg", "png"} }
+
+func (o *Options) check() error {
+	switch o.Format {
+	case "", "gif", "jpeg", "jpg", "png":
+	default:
+		return fmt.Errorf("unknown output format %q", o.Format)
+	}
+	if o.Quality < 0 || o.Quality > 100 {
+		return fmt.Errorf("quality %d not 1 to 100", o.Quality)
+	}
+	if _, ok := palettes[o.Palette]; !ok && o.Palette != "" {
+		return fmt.Errorf("unknown palette %q", o.Palette)
+	}
+	if o.Colors != 0 && (o.Colors < 2 || o.Colors > 256) {
+		return fmt.Errorf("colors %d not 2 to 256", o.Colors)
+	}
+	if o.Colors != 0 && o.Palette != "adaptive" && o.Palette != "" {
+		return fmt.Errorf("colors apply to the adaptive palette, not %s", o.Palette)
+	}
+	return nil
+}
+
+// Convert reads an image from in and writes it to out as opts say,
+// returning the name of the format of the input.
+func Convert(out io.Writer, in io.Reader, opts Options) (format string, err error) {
+	if err := opts.check(); err != nil {
+		return "", err
+	}
+	// image.Decode peeks at the magic number of the format through br
+	// too, so no bytes are lost.
+	br := bufio.NewReader(in)
+	if magic, _ := br.Peek(4); bytes.Equal(magic, []byte("GIF8")) {
+		g, err := gif.DecodeAll(br)
+		if err != nil {
+			return "gif", err
+		}
+		return "gif", convertGIF(out, g, opts)
+	}
+	img, format, err := image.Decode(br)
+	if err != nil {
+		return format, err
+	}
+	if opts.Format == "" {
+		opts.Format = format
+	}
+	return format, encode(out, img, opts)
+}
+
+// convertGIF writes g, all of it as GIF, or the frame opts.Frame in a
+// format of still images.
+func convertGIF(out io.Writer, g *gif.GIF, opts Options) error {
+	if opts.Format != "" && opts.Format != "gif" {
+		if opts.Frame < 0 || opts.Frame >= len(g.Image) {
+			return fmt.Errorf("no frame %d of %d", opts.Frame, len(g.Image))
+		}
+		return encode(out, composite(g, opts.Frame), opts)
+	}
+	if opts.Palette != "" || opts.Colors != 0 {
+		for i, frame := range g.Image {
+			g.Image[i] = paletted(frame, opts)
+		}
+		g.Config.ColorModel = nil // a global palette no longer
+		g.BackgroundIndex = 0
+	}
+	return gif.EncodeAll(out, g)
+}
+
+// encode writes img in opts.Format.
+func encode(out io.Writer, img image.Image, opts Options) error {
+	switch opts.Format {
+	case "gif":
+		return gif.Encode(out, paletted(img, opts), nil)
+	case "jpeg", "jpg":
+		quality := opts.Quality
+		if quality == 0 {
+			quality = jpeg.DefaultQuality // which Encode would take for 1
+		}
+		return jpeg.Encode(out, flatten(img), &jpeg.Options{Quality: quality})
+	case "png":
+		return png.Encode(out, img)
+	}
+	return fmt.Errorf("cannot write %s", opts.Format)
+}
+
+// composite returns frame n of g as it is seen: drawn over what the
+// frames before it left after their disposal.
+func composite(g *gif.GIF, n int) *image.RGBA {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	for _, frame := range g.Image {
+		bounds = bounds.Union(frame.Rect)
+	}
+	canvas := image.NewRGBA(bounds)
+	for i, frame := range g.Image[:n+1] {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var saved *image.RGBA
+		if disposal == gif.DisposalPrevious && i < n {
+			saved = image.NewRGBA(bounds)
+			copy(saved.Pix, canvas.Pix)
+		}
+		draw.Draw(canvas, frame.Rect, frame, frame.Rect.Min, draw.Over)
+		if i == n {
+			break
+		}
+		switch disposal {
+		case gif.DisposalBackground: // taken to be transparent, as by browsers
+			draw.Draw(canvas, frame.Rect, image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = saved
+		}
+	}
+	return canvas
+}
+
+// flatten returns img drawn over white, for formats without
+// transparency.
+func flatten(img image.Image) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	b := img.Bounds()
+	flat := image.NewRGBA(b)
+	draw.Draw(flat, b, image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, b, img, b.Min, draw.Over)
+	return flat
+}
@@ -0,0 +1,223 @@
+package imageconv
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+var (
+	red   = color.RGBA{0xff, 0x00, 0x00, 0xff}
+	green = color.RGBA{0x00, 0xff, 0x00, 0xff}
+	blue  = color.RGBA{0x00, 0x00, 0xff, 0xff}
+)
+
+// stripes returns a 30 by 10 image of red, green and blue stripes.
+func stripes() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 30, 10))
+	for x := 0; x < 30; x++ {
+		for y := 0; y < 10; y++ {
+			img.Set(x, y, []color.RGBA{red, green, blue}[x/10])
+		}
+	}
+	return img
+}
+
+// animation returns a GIF of two frames: 4 by 4 of red, then a green
+// pixel at (1, 1) over it, the rest transparent.
+func animation(disposal byte) *gif.GIF {
+	pal := color.Palette{red, green, color.RGBA{}}
+	first := image.NewPaletted(image.Rect(0, 0, 4, 4), pal)
+	second := image.NewPaletted(image.Rect(1, 1, 3, 3), pal)
+	for i := range second.Pix {
+		second.Pix[i] = 2
+	}
+	second.SetColorIndex(1, 1, 1)
+	return &gif.GIF{
+		Image:     []*image.Paletted{first, second},
+		Delay:     []int{10, 20},
+		Disposal:  []byte{disposal, 0},
+		LoopCount: 3,
+		Config:    image.Config{ColorModel: pal, Width: 4, Height: 4},
+	}
+}
+
+func encodeGIF(t *testing.T, g *gif.GIF) []byte {
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestConvertStill(t *testing.T) {
+	var in bytes.Buffer
+	png.Encode(&in, stripes())
+	for _, test := range []struct {
+		format string
+		decode func([]byte) (image.Image, error)
+	}{
+		{"jpeg", func(b []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(b)) }},
+		{"gif", func(b []byte) (image.Image, error) { return gif.Decode(bytes.NewReader(b)) }},
+		{"", func(b []byte) (image.Image, error) { return png.Decode(bytes.NewReader(b)) }}, // as the input
+	} {
+		var out bytes.Buffer
+		format, err := Convert(&out, bytes.NewReader(in.Bytes()), Options{Format: test.format, Quality: 90})
+		if err != nil || format != "png" {
+			t.Fatalf("Convert to %q = %q, %v", test.format, format, err)
+		}
+		img, err := test.decode(out.Bytes())
+		if err != nil {
+			t.Fatalf("%q: %v", test.format, err)
+		}
+		// the middles of the stripes, which JPEG keeps near enough
+		for i, want := range []color.RGBA{red, green, blue} {
+			r, g, b, _ := img.At(i*10+5, 5).RGBA()
+			if d := diff(r>>8, uint32(want.R)) + diff(g>>8, uint32(want.G)) + diff(b>>8, uint32(want.B)); d > 24 {
+				t.Errorf("%q: stripe %d is %v, want %v", test.format, i, img.At(i*10+5, 5), want)
+			}
+		}
+	}
+}
+
+func TestJPEGQuality(t *testing.T) {
+	var in bytes.Buffer
+	png.Encode(&in, stripes())
+	encode := func(quality int) []byte {
+		var out bytes.Buffer
+		if _, err := Convert(&out, bytes.NewReader(in.Bytes()), Options{Format: "jpeg", Quality: quality}); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+	if !bytes.Equal(encode(0), encode(jpeg.DefaultQuality)) {
+		t.Errorf("quality 0 is not jpeg.DefaultQuality")
+	}
+	if bytes.Equal(encode(0), encode(1)) {
+		t.Errorf("quality 0 is 1")
+	}
+}
+
+func diff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestConvertAnimation(t *testing.T) {
+	in := encodeGIF(t, animation(gif.DisposalNone))
+	for _, opts := range []Options{{}, {Palette: "adaptive", Colors: 4, Dither: true}, {Palette: "websafe"}} {
+		var out bytes.Buffer
+		if _, err := Convert(&out, bytes.NewReader(in), opts); err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		g, err := gif.DecodeAll(&out)
+		if err != nil {
+			t.Fatalf("%+v: %v", opts, err)
+		}
+		if len(g.Image) != 2 || g.Delay[1] != 20 || g.LoopCount != 3 {
+			t.Errorf("%+v: %d frames, delays %v, loop count %d", opts, len(g.Image), g.Delay, g.LoopCount)
+			continue
+		}
+		second := g.Image[1]
+		if second.Rect != image.Rect(1, 1, 3, 3) {
+			t.Errorf("%+v: second frame at %v", opts, second.Rect)
+		}
+		if _, _, _, a := second.At(2, 2).RGBA(); a != 0 {
+			t.Errorf("%+v: transparent pixel became %v", opts, second.At(2, 2))
+		}
+		if r, g, b, _ := second.At(1, 1).RGBA(); r>>8 > 0x40 || g>>8 < 0xc0 || b>>8 > 0x40 {
+			t.Errorf("%+v: green pixel became %v", opts, second.At(1, 1))
+		}
+	}
+}
+
+func TestConvertFrame(t *testing.T) {
+	for _, test := range []struct {
+		disposal byte
+		frame    int
+		want     color.Color // at (2, 2)
+	}{
+		{gif.DisposalNone, 0, red},
+		{gif.DisposalNone, 1, red}, // through the transparent pixel
+		{gif.DisposalBackground, 1, color.RGBA{}},
+		{gif.DisposalPrevious, 1, color.RGBA{}},
+	} {
+		var out bytes.Buffer
+		in := encodeGIF(t, animation(test.disposal))
+		if _, err := Convert(&out, bytes.NewReader(in), Options{Format: "png", Frame: test.frame}); err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := color.RGBAModel.Convert(img.At(2, 2)); got != test.want {
+			t.Errorf("disposal %d, frame %d: %v, want %v", test.disposal, test.frame, got, test.want)
+		}
+		if test.frame == 1 && color.RGBAModel.Convert(img.At(1, 1)) != green {
+			t.Errorf("disposal %d, frame 1: %v, want green", test.disposal, img.At(1, 1))
+		}
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	gifIn := encodeGIF(t, animation(gif.DisposalNone))
+	for _, test := range []struct {
+		in   string
+		opts Options
+		want string
+	}{
+		{string(gifIn), Options{Format: "bmp"}, "unknown output format"},
+		{string(gifIn), Options{Quality: 101}, "quality"},
+		{string(gifIn), Options{Colors: 1}, "colors 1 not 2 to 256"},
+		{string(gifIn), Options{Colors: -1}, "colors -1 not 2 to 256"},
+		{string(gifIn), Options{Colors: 257}, "colors 257 not 2 to 256"},
+		{string(gifIn), Options{Palette: "plan9", Colors: 16}, "adaptive"},
+		{string(gifIn), Options{Palette: "pastel"}, "unknown palette"},
+		{string(gifIn), Options{Format: "png", Frame: 2}, "no frame 2"},
+		{"not an image", Options{}, "unknown format"},
+	} {
+		_, err := Convert(new(bytes.Buffer), strings.NewReader(test.in), test.opts)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%+v: error %v, want %q", test.opts, err, test.want)
+		}
+	}
+}
+
+func TestMedianCut(t *testing.T) {
+	img := stripes()
+	if got := medianCut(img, 8); len(got) != 3 {
+		t.Errorf("palette of 8 for 3 colors: %v", got)
+	}
+	got := medianCut(img, 2)
+	if len(got) != 2 {
+		t.Fatalf("palette of 2: %v", got)
+	}
+	// red alone, then the mean of green and blue, or some such split
+	for _, c := range got {
+		if c == red || c == green || c == blue {
+			return
+		}
+	}
+	t.Errorf("palette of 2 keeps none of the colors: %v", got)
+}
+
+// TestMedianCutDescending checks the box of colors that come in
+// descending order.
+func TestMedianCutDescending(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	dark, light := color.RGBA{100, 0, 0, 0xff}, color.RGBA{200, 0, 0, 0xff}
+	img.Set(0, 0, light)
+	img.Set(1, 0, dark)
+	got := medianCut(img, 2)
+	if len(got) != 2 || !(got[0] == dark && got[1] == light || got[0] == light && got[1] == dark) {
+		t.Errorf("palette of 2 for %v then %v: %v", light, dark, got)
+	}
+}
@@ -0,0 +1,128 @@
+package imageconv
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// paletted returns img drawn with the palette of opts, with a
+// transparent color as well if img has transparent pixels.
+func paletted(img image.Image, opts Options) *image.Paletted {
+	colors := opts.Colors
+	if colors == 0 {
+		colors = 256
+	}
+	b := img.Bounds()
+	transparent := false
+	for y := b.Min.Y; y < b.Max.Y && !transparent; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a < 0x8000 {
+				transparent = true
+				break
+			}
+		}
+	}
+	if transparent {
+		colors--
+	}
+	pal := palettes[opts.Palette]
+	if pal == nil {
+		pal = medianCut(img, colors)
+	} else if len(pal) > colors { // keep room for transparency
+		pal = pal[:colors]
+	}
+	if transparent {
+		pal = append(pal[:len(pal):len(pal)], color.RGBA{})
+	}
+	p := image.NewPaletted(b, pal)
+	var drawer draw.Drawer = draw.Src
+	if opts.Dither {
+		drawer = draw.FloydSteinberg
+	}
+	drawer.Draw(p, b, img, b.Min)
+	return p
+}
+
+// medianCut returns a palette of at most n colors for the opaque pixels
+// of img: it splits the box of their colors in two at the median of
+// its longest side, then the box of the others with the longest side,
+// and so on, and takes the mean of each box.
+func medianCut(img image.Image, n int) color.Palette {
+	var pixels [][3]uint8
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A >= 0x80 {
+				pixels = append(pixels, [3]uint8{c.R, c.G, c.B})
+			}
+		}
+	}
+	if len(pixels) == 0 {
+		return color.Palette{color.Black}
+	}
+
+	type box struct {
+		pixels [][3]uint8
+		side   int // the longest, 0 for red, 1 green, 2 blue
+		length int
+	}
+	newBox := func(pixels [][3]uint8) box {
+		bx := box{pixels: pixels}
+		for side := 0; side < 3; side++ {
+			lo, hi := 255, 0
+			for _, p := range pixels {
+				v := int(p[side])
+				if v < lo {
+					lo = v
+				}
+				if v > hi {
+					hi = v
+				}
+			}
+			if hi-lo > bx.length || side == 0 {
+				bx.side, bx.length = side, hi-lo
+			}
+		}
+		return bx
+	}
+	boxes := []box{newBox(pixels)}
+	for len(boxes) < n {
+		longest := 0
+		for i, bx := range boxes {
+			if bx.length > boxes[longest].length {
+				longest = i
+			}
+		}
+		bx := boxes[longest]
+		if bx.length == 0 {
+			break // one color in each
+		}
+		sort.Slice(bx.pixels, func(i, j int) bool { return bx.pixels[i][bx.side] < bx.pixels[j][bx.side] })
+		// split at the median, but between distinct values of the side
+		mid := len(bx.pixels) / 2
+		for mid > 0 && bx.pixels[mid-1][bx.side] == bx.pixels[mid][bx.side] {
+			mid--
+		}
+		if mid == 0 {
+			for mid < len(bx.pixels) && bx.pixels[mid][bx.side] == bx.pixels[0][bx.side] {
+				mid++
+			}
+		}
+		boxes[longest] = newBox(bx.pixels[:mid])
+		boxes = append(boxes, newBox(bx.pixels[mid:]))
+	}
+
+	pal := make(color.Palette, len(boxes))
+	for i, bx := range boxes {
+		var sum [3]int
+		for _, p := range bx.pixels {
+			sum[0], sum[1], sum[2] = sum[0]+int(p[0]), sum[1]+int(p[1]), sum[2]+int(p[2])
+		}
+		n := len(bx.pixels)
+		pal[i] = color.RGBA{uint8((sum[0] + n/2) / n), uint8((sum[1] + n/2) / n), uint8((sum[2] + n/2) / n), 0xff}
+	}
+	return pal
+}
@@ -2,83 +2,83 @@
 // License: https://creativecommons.org/licenses/by-nc-sa/4.0/
 
 // See page 61.
-//!+
 
-// Mandelbrot emits a PNG image of the Mandelbrot fractal.
+// Mandelbrot emits a PNG image of the Mandelbrot fractal, or of
+// another of package fractal.
 package main
 
 import (
-	"image"
-	"image/color"
+	"context"
+	"digest_gopl/ch10/fractal"
+	"flag"
+	"fmt"
 	"image/png"
-	"math/cmplx"
+	"math"
 	"os"
+	"strings"
 )
 
-func main() {
-	const (
-		xmin, ymin, xmax, ymax = -2, -2, +2, +2
-		width, height          = 1024, 1024
-	)
+var (
+	name       = flag.String("fractal", "mandelbrot", "fractal to draw: "+strings.Join(fractal.Names(), ", ")+", some with a parameter, as in julia:0.285+0.01i")
+	palette    = flag.String("palette", "gray", "colors: "+strings.Join(fractal.PaletteNames(), ", "))
+	center     = flag.String("center", "0,0", "center `x,y` of the image, to any number of digits")
+	zoom       = flag.Float64("zoom", 1, "magnification of the square -2..+2 in the image")
+	iterations = flag.Int("iterations", 200, "iterations after which a point is taken to be in the set")
+	size       = flag.Int("size", 1024, "width and height of the image in pixels")
+	samples    = flag.Int("samples", 1, "samples per pixel along each axis, for anti-aliasing")
+	cycle      = flag.Float64("cycle", 32, "iterations per cycle through the palette")
+)
 
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
-	for py := 0; py < height; py++ {
-		y := float64(py)/height*(ymax-ymin) + ymin
-		for px := 0; px < width; px++ {
-			x := float64(px)/width*(xmax-xmin) + xmin
-			z := complex(x, y)
-			// Image point (px, py) represents complex value z.
-			img.Set(px, py, mandelbrot(z))
-		}
+func main() {
+	flag.Parse()
+	width, height := *size, *size
+	spacing := 4 / *zoom / float64(width) // between pixels
+	if !(*zoom > 0 && spacing >= fractal.MinSpacing && !math.IsInf(spacing, 0)) || *iterations < 1 || width < 1 {
+		fail(fmt.Errorf("need a zoom from 0 to %g, iterations and a size", 4/fractal.MinSpacing/float64(width)))
+	}
+	pal, ok := fractal.Palettes[*palette]
+	if !ok {
+		fail(fmt.Errorf("unknown palette %q", *palette))
+	}
+	cx, cy, err := fractal.ParsePoint(*center, fractal.Precision(spacing))
+	if err != nil {
+		fail(err)
 	}
-	png.Encode(os.Stdout, img) // NOTE: ignoring errors
-}
-
-func mandelbrot(z complex128) color.Color {
-	const iterations = 200
-	const contrast = 15
 
-	var v complex128
-	for n := uint8(0); n < iterations; n++ {
-		v = v*v + z
-		if cmplx.Abs(v) > 2 {
-			return color.Gray{255 - contrast*n}
+	// the window, from the center unless the fractal is Deep
+	half := complex(float64(width)/2*spacing, float64(height)/2*spacing)
+	r := fractal.Renderer{Palette: pal, Min: -half, Max: half, Samples: *samples, Cycle: *cycle}
+	if *name == "mandelbrot" && spacing < fractal.DirectSpacing {
+		r.Fractal = fractal.NewDeep(cx, cy, *iterations)
+	} else {
+		if r.Fractal, err = fractal.New(*name, *iterations); err != nil {
+			fail(err)
 		}
+		x, _ := cx.Float64()
+		y, _ := cy.Float64()
+		r.Min, r.Max = complex(x, y)-half, complex(x, y)+half
+	}
+	img, err := r.Render(context.Background(), width, height)
+	if err != nil {
+		fail(err)
+	}
+	if err := png.Encode(os.Stdout, img); err != nil {
+		fail(err)
 	}
-	return color.Black
-}
-
-//!-
-
-// Some other interesting functions:
-
-func acos(z complex128) color.Color {
-	v := cmplx.Acos(z)
-	blue := uint8(real(v)*128) + 127
-	red := uint8(imag(v)*128) + 127
-	return color.YCbCr{192, blue, red}
 }
 
-func sqrt(z complex128) color.Color {
-	v := cmplx.Sqrt(z)
-	blue := uint8(real(v)*128) + 127
-	red := uint8(imag(v)*128) + 127
-	return color.YCbCr{128, blue, red}
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "mandelbrot: %v\n", err)
+	os.Exit(1)
 }
 
-// f(x) = x^4 - 1
-//
-// z' = z - f(z)/f'(z)
-//    = z - (z^4 - 1) / (4 * z^3)
-//    = z - (z - 1/z^3) / 4
-func newton(z complex128) color.Color {
-	const iterations = 37
-	const contrast = 7
-	for i := uint8(0); i < iterations; i++ {
-		z -= (z - 1/(z*z*z)) / 4
-		if cmplx.Abs(z*z*z*z-1) < 1e-6 {
-			return color.Gray{255 - contrast*i}
-		}
-	}
-	return color.Black
-}
+// go run . >mandelbrot.png
+// go run . -palette=classic -samples=3 >smooth.png
+// go run . -center=-0.75,0.1 -zoom=100 -iterations=1000 -palette=fire >seahorses.png
+// go run . -fractal=newton -palette=rainbow >newton.png
+// go run . -fractal=newton:1,0,-2,2 -palette=rainbow >newton3.png
+// go run . -fractal=julia:0.285+0.01i -palette=ocean -samples=2 >julia.png
+// go run . -fractal=burningship -center=-1.75,-0.035 -zoom=25 -palette=fire >ship.png
+// go run . -fractal='formula:z*z*z + c' -palette=rainbow >cubic.png
+// go run . -center=-0.743643887037158704752191506114774,0.131825904205311970493132056385139 \
+//	-zoom=1e25 -iterations=5000 -size=512 -palette=ocean >deep.png
@@ -0,0 +1,186 @@
+// Tiles serves the fractals of package fractal as the square tiles of a
+// slippy map, /tiles/{fractal}/{z}/{x}/{y}.png, for a viewer such as
+// Leaflet to pan and zoom, keeping the tiles it renders on disk.
+package main
+
+import (
+	"bytes"
+	"context"
+	"digest_gopl/ch10/fractal"
+	"flag"
+	"fmt"
+	"image/png"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	cacheDir   = flag.String("cache", filepath.Join(os.TempDir(), "tiles"), "directory of the rendered tiles")
+	iterations = flag.Int("iterations", 200, "iterations at zoom 0, 50 more at each level")
+	samples    = flag.Int("samples", 2, "samples per pixel along each axis, for anti-aliasing")
+	cycle      = flag.Float64("cycle", 32, "iterations per cycle through the palette")
+)
+
+const (
+	tileSize = 256 // pixels along each side
+	maxZoom  = 60  // keeps the tiles numbered within an int64
+	// The tile of zoom 0 is the square -2-2i..2+2i, halved along each
+	// axis at each level.
+	extent = 4
+)
+
+// A tile is tile (x, y) of the 2^z by 2^z of zoom z, numbered from the
+// top left.
+type tile struct {
+	fractal string
+	palette string
+	z, x, y int64
+}
+
+// parseTile parses the path /tiles/{fractal}/{z}/{x}/{y}.png and the
+// palette of the query. It leaves the fractal to be checked when the
+// tile is rendered, as making some is costly and cached tiles need not.
+func parseTile(r *http.Request) (*tile, error) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/tiles/"), "/")
+	if len(parts) != 4 || !strings.HasSuffix(parts[3], ".png") {
+		return nil, fmt.Errorf("%s is not /tiles/{fractal}/{z}/{x}/{y}.png", r.URL.Path)
+	}
+	t := &tile{fractal: parts[0], palette: r.URL.Query().Get("palette")}
+	if t.palette == "" {
+		t.palette = "classic"
+	}
+	if _, ok := fractal.Palettes[t.palette]; !ok {
+		return nil, fmt.Errorf("unknown palette %q", t.palette)
+	}
+	var err error
+	if t.z, err = strconv.ParseInt(parts[1], 10, 64); err != nil || t.z < 0 || t.z > maxZoom {
+		return nil, fmt.Errorf("bad zoom %q, not 0 to %d", parts[1], maxZoom)
+	}
+	n := int64(1) << uint(t.z)
+	if t.x, err = strconv.ParseInt(parts[2], 10, 64); err != nil || t.x < 0 || t.x >= n {
+		return nil, fmt.Errorf("bad x %q at zoom %d", parts[2], t.z)
+	}
+	y := strings.TrimSuffix(parts[3], ".png")
+	if t.y, err = strconv.ParseInt(y, 10, 64); err != nil || t.y < 0 || t.y >= n {
+		return nil, fmt.Errorf("bad y %q at zoom %d", y, t.z)
+	}
+	return t, nil
+}
+
+// path returns the file of the tile in the cache. The settings of the
+// flags are part of it, so that tiles rendered with other settings are
+// not served.
+func (t *tile) path() string {
+	settings := fmt.Sprintf("%s-%s-i%d-s%d-c%g", t.fractal, t.palette, *iterations, *samples, *cycle)
+	return filepath.Join(*cacheDir, settings, fmt.Sprint(t.z), fmt.Sprint(t.x), fmt.Sprint(t.y)+".png")
+}
+
+// render renders the tile as a PNG, unless ctx is done first.
+func (t *tile) render(ctx context.Context) ([]byte, error) {
+	size := extent / float64(int64(1)<<uint(t.z)) // of the tile in the plane
+	spacing := size / tileSize
+	n := *iterations + 50*int(t.z) // the detail at the edges of a set grows with the zoom
+	r := fractal.Renderer{Palette: fractal.Palettes[t.palette], Samples: *samples, Cycle: *cycle}
+	r.Min = complex(-extent/2+float64(t.x)*size, -extent/2+float64(t.y)*size)
+	r.Max = r.Min + complex(size, size)
+	if t.fractal == "mandelbrot" && spacing < fractal.DirectSpacing {
+		// the center of the tile, (2x+1)·size/2 - 2, exactly
+		prec := fractal.Precision(spacing)
+		center := func(i int64) *big.Float {
+			c := new(big.Float).SetPrec(prec).SetInt64(2*i + 1)
+			c.SetMantExp(c, 1-int(t.z))
+			return c.Sub(c, big.NewFloat(extent/2))
+		}
+		r.Fractal = fractal.NewDeep(center(t.x), center(t.y), n)
+		r.Min, r.Max = -complex(size/2, size/2), complex(size/2, size/2)
+	} else {
+		r.Fractal, _ = fractal.New(t.fractal, n)
+	}
+	img, err := r.Render(ctx, tileSize, tileSize)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// store writes data to the file at path, by way of a temporary file so
+// that no other request reads a partly written tile.
+func store(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tile-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func serveTile(w http.ResponseWriter, r *http.Request) {
+	t, err := parseTile(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	path := t.path()
+	if f, err := os.Open(path); err == nil {
+		defer f.Close()
+		if info, err := f.Stat(); err == nil {
+			http.ServeContent(w, r, "", info.ModTime(), f)
+			return
+		}
+	}
+
+	// Only tiles of known fractals are ever cached.
+	if _, err := fractal.New(t.fractal, 0); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	// The context of the request is done when the client goes away, as a
+	// viewer does with the tiles that leave the screen before they come.
+	data, err := t.render(r.Context())
+	if err != nil {
+		if r.Context().Err() == nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if err := store(path, data); err != nil {
+		log.Print(err) // serve it all the same
+	}
+	http.ServeContent(w, r, "", time.Now(), bytes.NewReader(data))
+}
+
+func main() {
+	flag.Parse()
+	http.HandleFunc("/", viewer)
+	http.HandleFunc("/tiles/", serveTile)
+	log.Fatal(http.ListenAndServe("localhost:8000", nil))
+}
+
+// go run . -cache /tmp/tiles
+// localhost:8000/
+// localhost:8000/tiles/mandelbrot/0/0/0.png
+// localhost:8000/tiles/newton/3/4/2.png?palette=rainbow
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image/color"
+	"image/png"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestServeTile(t *testing.T) {
+	*cacheDir = t.TempDir()
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		serveTile(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+
+	for i := 0; i < 2; i++ { // rendered, then from the cache
+		w := get("/tiles/mandelbrot/1/0/1.png?palette=fire")
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d: %s", i, w.Code, w.Body)
+		}
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if b := img.Bounds(); b.Dx() != tileSize || b.Dy() != tileSize {
+			t.Errorf("request %d: tile of %v", i, b)
+		}
+	}
+	tl := &tile{fractal: "mandelbrot", palette: "fire", z: 1, x: 0, y: 1}
+	if _, err := os.Stat(tl.path()); err != nil {
+		t.Errorf("tile not cached: %v", err)
+	}
+
+	// a cached tile is served without making its fractal, which would
+	// fail for this one
+	tl = &tile{fractal: "plaid", palette: "fire", z: 1, x: 0, y: 1}
+	if err := store(tl.path(), []byte("cached")); err != nil {
+		t.Fatal(err)
+	}
+	if w := get("/tiles/plaid/1/0/1.png?palette=fire"); w.Code != http.StatusOK || w.Body.String() != "cached" {
+		t.Errorf("cached tile of plaid: status %d, body %q", w.Code, w.Body)
+	}
+
+	for _, url := range []string{
+		"/tiles/mandelbrot/1/2/0.png", // x beyond the 2 tiles of zoom 1
+		"/tiles/mandelbrot/1/0/-1.png",
+		"/tiles/mandelbrot/61/0/0.png",
+		"/tiles/mandelbrot/0/0/0.jpg",
+		"/tiles/mandelbrot/0/0.png",
+		"/tiles/mandelbrot/0/0/0.png?palette=plaid",
+		"/tiles/plaid/0/0/0.png",
+		"/tiles/julia:x/0/0/0.png",
+	} {
+		if w := get(url); w.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want %d", url, w.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestServeTileCanceled(t *testing.T) {
+	*cacheDir = t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // as by a client gone before the tile is done
+	req := httptest.NewRequest("GET", "/tiles/mandelbrot/3/2/5.png", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	serveTile(w, req)
+	if w.Body.Len() > 0 {
+		t.Errorf("response of %d bytes to a canceled request", w.Body.Len())
+	}
+	tl := &tile{fractal: "mandelbrot", palette: "classic", z: 3, x: 2, y: 5}
+	if _, err := os.Stat(tl.path()); !os.IsNotExist(err) {
+		t.Errorf("canceled tile cached: %v", err)
+	}
+}
+
+// TestDeepTile checks that a tile too deep to render directly, on the
+// edge of the Mandelbrot set, shows its detail.
+func TestDeepTile(t *testing.T) {
+	defer func(n int) { *samples = n }(*samples)
+	*samples = 1
+	const z = 36
+	n := math.Ldexp(1, z) / extent
+	x, y := -0.743643887037158704752191506114774, 0.131825904205311970493132056385139
+	tl := &tile{fractal: "mandelbrot", palette: "classic", z: z, x: int64((x + extent/2) * n), y: int64((y + extent/2) * n)}
+	data, err := tl.render(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	colors := make(map[color.Color]bool)
+	for py := 0; py < tileSize; py++ {
+		for px := 0; px < tileSize; px++ {
+			colors[img.At(px, py)] = true
+		}
+	}
+	if len(colors) < 1000 {
+		t.Errorf("deep tile %v has only %d colors", tl, len(colors))
+	}
+}
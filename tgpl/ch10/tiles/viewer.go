@@ -0,0 +1,63 @@
+package main
+
+import (
+	"digest_gopl/ch10/fractal"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// page is a Leaflet map of the tiles, with a choice of palette and of
+// fractal, which may be typed with a parameter. The tiles cover the
+// whole map of the default projection, so its latitudes and longitudes
+// mean nothing here.
+var page = template.Must(template.New("viewer").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>fractals</title>
+<link rel="stylesheet" href="https://unpkg.com/leaflet@1.9.4/dist/leaflet.css">
+<script src="https://unpkg.com/leaflet@1.9.4/dist/leaflet.js"></script>
+<style>
+html, body, #map { height: 100%; margin: 0; background: #000; }
+#controls { position: absolute; top: 10px; right: 10px; z-index: 1000; }
+</style>
+</head>
+<body>
+<div id="map"></div>
+<div id="controls">
+<input id="fractal" list="fractals" value="mandelbrot" title="a fractal, such as julia:0.285+0.01i">
+<datalist id="fractals">{{range .Fractals}}<option>{{.}}</option>{{end}}</datalist>
+<select id="palette">{{range .Palettes}}<option{{if eq . "classic"}} selected{{end}}>{{.}}</option>{{end}}</select>
+</div>
+<script>
+var map = L.map('map', {center: [0, 0], zoom: 2, maxZoom: {{.MaxZoom}}});
+var layer = L.tileLayer('', {noWrap: true, maxZoom: {{.MaxZoom}}, keepBuffer: 1}).addTo(map);
+var fractal = document.getElementById('fractal'), palette = document.getElementById('palette');
+function update() {
+	layer.setUrl('/tiles/' + encodeURIComponent(fractal.value) + '/{z}/{x}/{y}.png?palette=' + palette.value);
+}
+fractal.onchange = palette.onchange = update;
+update();
+</script>
+</body>
+</html>
+`))
+
+// viewerZoom is the deepest zoom of the viewer, beyond which the
+// latitudes and longitudes of Leaflet lose their precision.
+const viewerZoom = 40
+
+func viewer(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	data := struct {
+		Fractals, Palettes []string
+		MaxZoom            int
+	}{fractal.Names(), fractal.PaletteNames(), viewerZoom}
+	if err := page.Execute(w, data); err != nil {
+		log.Print(err)
+	}
+}
@@ -1,37 +1,302 @@
+// Fetch downloads URLs to local files named after the last elements of
+// their paths, after any redirects, or if they have none index-H and an
+// extension for the Content-Type, with H a hash of the host and path.
+//
+// A file downloaded before is only downloaded again if it has changed:
+// its ETag and Last-Modified headers, kept in a hidden file beside it,
+// go back to the server as If-None-Match and If-Modified-Since. A
+// download goes to the file with .part added until it is complete, then
+// takes the place of the file at once, so that the file is never cut
+// short. If a download stops partway, the next picks up where it
+// stopped with a Range request, unless the resource changed meanwhile.
+// Index pages are revalidated but not resumed.
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 )
 
-func fetch(url string) (filename string, n int64, err error) {
-	resp, err := http.Get(url)
+var dir = flag.String("dir", ".", "directory of the downloaded files")
+
+// validators identify a version of a resource, in the headers of its
+// response, and the URL of the resource, so that they are only sent
+// back for it.
+type validators struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// metaPath returns the hidden file of the validators of file.
+func metaPath(file string) string {
+	d, base := filepath.Split(file)
+	return filepath.Join(d, "."+base+".fetch")
+}
+
+// readMeta returns the validators of file, none if they are lost.
+func readMeta(file string) validators {
+	var v validators
+	if data, err := os.ReadFile(metaPath(file)); err == nil {
+		json.Unmarshal(data, &v)
+	}
+	return v
+}
+
+func writeMeta(file string, v validators) error {
+	data, err := json.Marshal(v)
 	if err != nil {
-		return "", 0, err
+		return err
 	}
+	return os.WriteFile(metaPath(file), data, 0644)
+}
 
-	defer resp.Body.Close()
+// localPath returns the file for u, or "" if it is an index page not
+// downloaded before, whose extension comes from its Content-Type.
+// Index pages are named index-H, with H a hash of the host and path of
+// u, so that each has a file of its own.
+func localPath(u *url.URL) (string, error) {
+	if u.Path != "" && !strings.HasSuffix(u.Path, "/") {
+		return filepath.Join(*dir, path.Base(u.Path)), nil
+	}
+	// an index page, which may have been downloaded with any extension
+	matches, err := filepath.Glob(filepath.Join(*dir, indexName(u)+".*"))
+	if err != nil {
+		return "", err
+	}
+	var indexes []string
+	for _, m := range matches {
+		if !strings.HasSuffix(m, ".part") {
+			indexes = append(indexes, m)
+		}
+	}
+	if len(indexes) == 1 {
+		return indexes[0], nil
+	}
+	return "", nil
+}
+
+// indexName returns the name of the index page u, less its extension.
+func indexName(u *url.URL) string {
+	sum := sha256.Sum256([]byte(canonical(u)))
+	return fmt.Sprintf("index-%x", sum[:4])
+}
+
+// canonical returns u as its validators are kept for, the same with or
+// without a path of / and a fragment.
+func canonical(u *url.URL) string {
+	c := *u
+	if c.Path == "" {
+		c.Path, c.RawPath = "/", ""
+	}
+	c.Fragment, c.RawFragment = "", ""
+	return c.String()
+}
+
+// extension returns the extension of files of the media type ct.
+func extension(ct string) string {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return ""
+	}
+	switch mt { // rather than the first of several in alphabetical order
+	case "text/html":
+		return ".html"
+	case "text/plain":
+		return ".txt"
+	case "image/jpeg":
+		return ".jpg"
+	}
+	if exts, err := mime.ExtensionsByType(mt); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// A download is the state of a fetch, for the request last sent: the
+// URL as asked for, then as redirected.
+type download struct {
+	local  string // the file of the URL, "" for an index page not downloaded before
+	offset int64  // of the data asked for, past that of the part
+}
 
-	local := path.Base(resp.Request.URL.Path)
-	if local == "/" {
-		local = "index.html"
+// prepare sets up d for req, adding to req the headers that make it
+// conditional on the file of its URL or ask for the rest of its part.
+// The validators are only sent if they are of the same URL.
+func (d *download) prepare(req *http.Request) error {
+	for _, h := range []string{"If-None-Match", "If-Modified-Since", "Range", "If-Range"} {
+		req.Header.Del(h) // of the URL before a redirect
+	}
+	local, err := localPath(req.URL)
+	if err != nil {
+		return err
+	}
+	d.local, d.offset = local, 0
+	if local == "" {
+		return nil
 	}
+	if _, err := os.Stat(local); err == nil {
+		v := readMeta(local)
+		if v.URL != canonical(req.URL) {
+			return nil
+		}
+		if v.ETag != "" {
+			req.Header.Set("If-None-Match", v.ETag)
+		}
+		if v.LastModified != "" {
+			req.Header.Set("If-Modified-Since", v.LastModified)
+		}
+	} else if info, err := os.Stat(local + ".part"); err == nil && info.Size() > 0 {
+		// only the rest, as long as the resource is the same
+		v := readMeta(local + ".part")
+		validator := v.ETag
+		if validator == "" {
+			validator = v.LastModified
+		}
+		if validator != "" && v.URL == canonical(req.URL) {
+			d.offset = info.Size()
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", d.offset))
+			req.Header.Set("If-Range", validator)
+		}
+	}
+	return nil
+}
 
-	f, err := os.Create(local)
+// fetch downloads the URL unless the local file of it is up to date,
+// and returns the name and length of the local file and how it came
+// to be: "fetched", "resumed" or "not modified". The file is named
+// after the URL that redirects lead to.
+func fetch(rawurl string) (filename string, n int64, how string, err error) {
+	req, err := http.NewRequest("GET", rawurl, nil)
+	if err != nil {
+		return "", 0, "", err
+	}
+	var d download
+	if err := d.prepare(req); err != nil {
+		return "", 0, "", err
+	}
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		return d.prepare(req)
+	}}
+	resp, err := client.Do(req)
 	if err != nil {
-		return "", 0, err
+		return "", 0, "", err
+	}
+	defer resp.Body.Close()
+	rawurl = resp.Request.URL.String()
+	local, offset := d.local, d.offset
+	switch {
+	case resp.StatusCode == http.StatusNotModified && local != "":
+		info, err := os.Stat(local)
+		if err != nil {
+			return "", 0, "", err
+		}
+		return local, info.Size(), "not modified", nil
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// There is no more: the part was complete, but not renamed.
+		var size int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes */%d", &size); err == nil && size == offset {
+			return local, offset, "resumed", finish(local, resp)
+		}
+		// Or the resource is now shorter, so start over.
+		if err := os.Remove(local + ".part"); err != nil {
+			return "", 0, "", err
+		}
+		resp.Body.Close()
+		return fetch(rawurl)
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		var start int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != offset {
+			return "", 0, "", fmt.Errorf("%s: asked for bytes from %d, got %q", rawurl, offset, resp.Header.Get("Content-Range"))
+		}
+		how = "resumed"
+	case resp.StatusCode == http.StatusOK:
+		offset, how = 0, "fetched" // all of it, the part being out of date if there is one
+	default:
+		return "", 0, "", fmt.Errorf("%s: %s", rawurl, resp.Status)
 	}
 
+	if local == "" {
+		local = filepath.Join(*dir, indexName(resp.Request.URL)+extension(resp.Header.Get("Content-Type")))
+	}
+	part := local + ".part"
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if how == "fetched" {
+		flags |= os.O_TRUNC
+		v := validators{canonical(resp.Request.URL), resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")}
+		if err := writeMeta(part, v); err != nil {
+			return "", 0, "", err
+		}
+	}
+	f, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return "", 0, "", err
+	}
 	n, err = io.Copy(f, resp.Body)
+	if err == nil {
+		err = f.Sync() // before the rename, lest a crash leave it empty
+	}
 	if closeErr := f.Close(); err == nil {
 		err = closeErr
 	}
-	return local, n, err
+	if err != nil {
+		return part, offset + n, how, err // for the next to resume
+	}
+	if err := finish(local, resp); err != nil {
+		return "", 0, "", err
+	}
+	return local, offset + n, how, nil
 }
 
-func main() {
+// finish puts the complete part of local, and its validators, in the
+// place of local.
+func finish(local string, resp *http.Response) error {
+	part := local + ".part"
+	// The validators of the file go first, so that if the rest is cut
+	// short, the file is downloaded again rather than taken for the
+	// version of the validators it had.
+	if err := os.Remove(metaPath(local)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(part, local); err != nil {
+		return err
+	}
+	if err := os.Rename(metaPath(part), metaPath(local)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(local, t, t)
+	}
+	return nil
+}
 
+func main() {
+	flag.Parse()
+	status := 0
+	for _, url := range flag.Args() {
+		local, n, how, err := fetch(url)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fetch %s: %v\n", url, err)
+			status = 1
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s => %s (%d bytes, %s).\n", url, local, n, how)
+	}
+	os.Exit(status)
 }
+
+// go run . https://go.dev/doc/gopl.pdf https://go.dev/
+// go run . -dir=/tmp https://go.dev/dl/go1.22.0.src.tar.gz   # again after ^C to resume
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resource is served with http.ServeContent, which answers conditional
+// and Range requests.
+type resource struct {
+	etag     string
+	modtime  time.Time
+	ctype    string
+	content  []byte
+	brokenAt int    // if nonzero, the next response stops after this many bytes
+	redirect string // if not "", the path it is found at instead
+}
+
+func server(t *testing.T, resources map[string]*resource) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, ok := resources[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if res.redirect != "" {
+			http.Redirect(w, r, res.redirect, http.StatusFound)
+			return
+		}
+		if res.brokenAt > 0 {
+			w.Header().Set("ETag", res.etag)
+			w.Header().Set("Content-Length", "1000000")
+			w.Write(res.content[:res.brokenAt])
+			w.(http.Flusher).Flush()
+			res.brokenAt = 0
+			panic(http.ErrAbortHandler) // closes the connection
+		}
+		w.Header().Set("ETag", res.etag)
+		if res.ctype != "" {
+			w.Header().Set("Content-Type", res.ctype)
+		}
+		http.ServeContent(w, r, "", res.modtime, bytes.NewReader(res.content))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func check(t *testing.T, url, wantFile, wantHow string, want []byte) {
+	t.Helper()
+	local, n, how, err := fetch(url)
+	if err != nil {
+		t.Fatalf("fetch %s: %v", url, err)
+	}
+	if local != filepath.Join(*dir, wantFile) || n != int64(len(want)) || how != wantHow {
+		t.Errorf("fetch %s = %s, %d, %s, want %s, %d, %s", url, local, n, how, wantFile, len(want), wantHow)
+	}
+	if got, err := os.ReadFile(local); err != nil || !bytes.Equal(got, want) {
+		t.Errorf("%s holds %q (%v), want %q", local, got, err, want)
+	}
+	if _, err := os.Stat(local + ".part"); !os.IsNotExist(err) {
+		t.Errorf("%s.part is left: %v", local, err)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	*dir = t.TempDir()
+	modtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	data := &resource{etag: `"v1"`, modtime: modtime, content: []byte("version 1")}
+	s := server(t, map[string]*resource{"/a/data.txt": data})
+
+	check(t, s.URL+"/a/data.txt", "data.txt", "fetched", data.content)
+	if info, err := os.Stat(filepath.Join(*dir, "data.txt")); err != nil || !info.ModTime().Equal(modtime) {
+		t.Errorf("data.txt modified %v (%v), want %v", info.ModTime(), err, modtime)
+	}
+	check(t, s.URL+"/a/data.txt", "data.txt", "not modified", data.content)
+
+	data.etag, data.modtime, data.content = `"v2"`, modtime.Add(time.Hour), []byte("version 2, longer")
+	check(t, s.URL+"/a/data.txt", "data.txt", "fetched", data.content)
+
+	if _, _, _, err := fetch(s.URL + "/missing"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("fetch /missing: %v, want 404", err)
+	}
+}
+
+func TestResume(t *testing.T) {
+	*dir = t.TempDir()
+	content := []byte(strings.Repeat("0123456789", 100))
+	big := &resource{etag: `"big"`, modtime: time.Now(), content: content, brokenAt: 300}
+	s := server(t, map[string]*resource{"/big": big})
+
+	local, n, _, err := fetch(s.URL + "/big")
+	if err == nil || local != filepath.Join(*dir, "big.part") || n != 300 {
+		t.Fatalf("fetch of a broken /big = %s, %d, %v, want big.part, 300, an error", local, n, err)
+	}
+	if _, err := os.Stat(filepath.Join(*dir, "big")); !os.IsNotExist(err) {
+		t.Errorf("big exists after a broken fetch: %v", err)
+	}
+	// only the rest is sent, but n counts the part too
+	check(t, s.URL+"/big", "big", "resumed", content)
+
+	// a part of a version since replaced is fetched again in full
+	os.Remove(filepath.Join(*dir, "big"))
+	big.brokenAt = 500
+	fetch(s.URL + "/big")
+	big.etag, big.content = `"bigger"`, append(content, "abc"...)
+	check(t, s.URL+"/big", "big", "fetched", big.content)
+}
+
+// TestComplete checks a part that was complete but not renamed.
+func TestComplete(t *testing.T) {
+	*dir = t.TempDir()
+	content := []byte(strings.Repeat("0123456789", 100))
+	big := &resource{etag: `"big"`, modtime: time.Now(), content: content}
+	s := server(t, map[string]*resource{"/big": big})
+
+	part := filepath.Join(*dir, "big.part")
+	os.WriteFile(part, content, 0644)
+	writeMeta(part, validators{URL: s.URL + "/big", ETag: big.etag})
+	check(t, s.URL+"/big", "big", "resumed", content)
+	check(t, s.URL+"/big", "big", "not modified", content)
+
+	// a part longer than the resource, with its validators, as if the
+	// resource were cut short without a new ETag
+	os.Remove(filepath.Join(*dir, "big"))
+	os.WriteFile(part, append(content, "more"...), 0644)
+	writeMeta(part, validators{URL: s.URL + "/big", ETag: big.etag})
+	check(t, s.URL+"/big", "big", "fetched", content)
+}
+
+func TestRedirect(t *testing.T) {
+	*dir = t.TempDir()
+	data := &resource{etag: `"d"`, modtime: time.Now(), content: []byte("data")}
+	s := server(t, map[string]*resource{"/latest": {redirect: "/v2/data.txt"}, "/v2/data.txt": data})
+
+	check(t, s.URL+"/latest", "data.txt", "fetched", data.content)
+	check(t, s.URL+"/latest", "data.txt", "not modified", data.content)
+	check(t, s.URL+"/v2/data.txt", "data.txt", "not modified", data.content)
+}
+
+func TestIndex(t *testing.T) {
+	*dir = t.TempDir()
+	page := &resource{etag: `"p"`, modtime: time.Now(), ctype: "text/html; charset=utf-8", content: []byte("<p>hi</p>")}
+	list := &resource{etag: `"l"`, modtime: time.Now(), ctype: "application/json", content: []byte("[]")}
+	s := server(t, map[string]*resource{"/": page, "/list/": list})
+	index := func(rawurl string) string {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return indexName(u)
+	}
+
+	check(t, s.URL, index(s.URL)+".html", "fetched", page.content)
+	check(t, s.URL+"/", index(s.URL+"/")+".html", "not modified", page.content)
+
+	// another index page in the same directory has a file of its own
+	check(t, s.URL+"/list/", index(s.URL+"/list/")+".json", "fetched", list.content)
+	check(t, s.URL+"/list/", index(s.URL+"/list/")+".json", "not modified", list.content)
+	check(t, s.URL+"/", index(s.URL+"/")+".html", "not modified", page.content)
+
+	// and the validators of a file are only sent for its URL
+	other := server(t, map[string]*resource{"/data.txt": {etag: `"p"`, modtime: time.Now(), content: []byte("other")}})
+	first := server(t, map[string]*resource{"/data.txt": {etag: `"p"`, modtime: time.Now(), content: []byte("first")}})
+	check(t, first.URL+"/data.txt", "data.txt", "fetched", []byte("first"))
+	check(t, other.URL+"/data.txt", "data.txt", "fetched", []byte("other"))
+}
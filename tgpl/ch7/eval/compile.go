@@ -0,0 +1,353 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+)
+
+// ---- bytecode ----
+
+// A Program is an Expr compiled to a flat sequence of instructions for a
+// stack machine. Variables are resolved to slots and functions to their
+// implementations at compile time, so running a Program does no map
+// lookups and no interface dispatch. Let bindings occupy further slots
+// after those of the variables, and functions defined by blocks are
+// inlined at each call.
+type Program struct {
+	code     []instr
+	consts   []float64
+	funcs    []Func
+	vars     []Var
+	nslots   int // len(vars) plus the number of local slots
+	maxStack int
+}
+
+type opcode uint8
+
+const (
+	bcConst     opcode = iota // push consts[arg]
+	bcLoad                    // push slots[arg]
+	bcStore                   // x => ; slots[arg] = x
+	bcNeg                     // x => -x
+	bcNot                     // x => !x
+	bcBool                    // x => x != 0
+	bcAdd                     // x y => x+y
+	bcSub                     // x y => x-y
+	bcMul                     // x y => x*y
+	bcDiv                     // x y => x/y
+	bcLT                      // x y => x<y
+	bcLE                      // x y => x<=y
+	bcGT                      // x y => x>y
+	bcGE                      // x y => x>=y
+	bcEQ                      // x y => x==y
+	bcNE                      // x y => x!=y
+	bcCall                    // arg1 ... argn => funcs[arg](args), n = nargs
+	bcJump                    // pc = arg
+	bcJumpFalse               // x => ; if x == 0 { pc = arg }
+)
+
+type instr struct {
+	op    opcode
+	nargs uint16 // number of arguments, for bcCall
+	arg   int32
+}
+
+var compareCodes = map[rune]opcode{
+	'<': bcLT, opLE: bcLE, '>': bcGT, opGE: bcGE, opEQ: bcEQ, opNE: bcNE,
+}
+
+var binaryCodes = map[rune]opcode{
+	'+': bcAdd, '-': bcSub, '*': bcMul, '/': bcDiv,
+}
+
+// Compile checks expr and compiles it to a Program whose inputs are
+// the values of vars, in order. Calls are resolved against DefaultFuncs.
+func Compile(expr Expr, vars []Var) (*Program, error) {
+	return CompileFuncs(expr, vars, nil)
+}
+
+// CompileFuncs is like Compile but resolves calls against funcs.
+// If funcs is nil, DefaultFuncs is used.
+func CompileFuncs(expr Expr, vars []Var, funcs FuncTable) (*Program, error) {
+	ctx := &Context{Funcs: funcs}
+	used := make(map[Var]bool)
+	if err := expr.CheckContext(ctx, used); err != nil {
+		return nil, err
+	}
+	c := &compiler{
+		ctx:    ctx,
+		prog:   &Program{vars: vars, nslots: len(vars)},
+		consts: make(map[float64]int),
+		funcs:  make(map[string]int),
+	}
+	root := &compileScope{slots: make(map[Var]int)}
+	for i, v := range vars {
+		if _, ok := root.slots[v]; ok {
+			return nil, fmt.Errorf("duplicate variable %s", v)
+		}
+		root.slots[v] = i
+	}
+	for v := range used {
+		if _, ok := root.slots[v]; !ok {
+			return nil, fmt.Errorf("undefined variable: %s", v)
+		}
+	}
+	c.compile(expr, root)
+	return c.prog, nil
+}
+
+type compiler struct {
+	ctx    *Context
+	prog   *Program
+	consts map[float64]int // index of each constant in prog.consts
+	funcs  map[string]int  // index of each function in prog.funcs
+	depth  int             // stack depth at the current instruction
+}
+
+// A compileScope holds the names visible at some point of the program:
+// variables with their slots, and functions defined by blocks.
+type compileScope struct {
+	slots map[Var]int
+	funcs map[string]*compileFunc
+	outer *compileScope
+}
+
+type compileFunc struct {
+	def   *def
+	scope *compileScope // where the function was defined
+}
+
+// store emits code to pop the top of the stack into a new slot.
+func (c *compiler) store() int {
+	slot := c.prog.nslots
+	c.prog.nslots++
+	c.emit(bcStore, slot, -1)
+	return slot
+}
+
+func (c *compiler) emit(op opcode, arg int, push int) int {
+	c.prog.code = append(c.prog.code, instr{op: op, arg: int32(arg)})
+	c.depth += push
+	if c.depth > c.prog.maxStack {
+		c.prog.maxStack = c.depth
+	}
+	return len(c.prog.code) - 1
+}
+
+// patch makes the jump at pc go to the next instruction to be emitted.
+func (c *compiler) patch(pc int) {
+	c.prog.code[pc].arg = int32(len(c.prog.code))
+}
+
+func (c *compiler) constant(f float64) {
+	i, ok := c.consts[f]
+	if !ok || math.Signbit(f) != math.Signbit(c.prog.consts[i]) {
+		i = len(c.prog.consts)
+		c.prog.consts = append(c.prog.consts, f)
+		c.consts[f] = i
+	}
+	c.emit(bcConst, i, +1)
+}
+
+func (c *compiler) compile(e Expr, s *compileScope) {
+	switch e := e.(type) {
+	case Var:
+		for sc := s; sc != nil; sc = sc.outer {
+			if slot, ok := sc.slots[e]; ok {
+				c.emit(bcLoad, slot, +1)
+				return
+			}
+		}
+		panic(fmt.Sprintf("eval: unresolved variable %s", e))
+	case literal:
+		c.constant(float64(e))
+	case unary:
+		c.compile(e.x, s)
+		switch e.op {
+		case '-':
+			c.emit(bcNeg, 0, 0)
+		case '!':
+			c.emit(bcNot, 0, 0)
+		}
+	case binary:
+		c.compile(e.x, s)
+		c.compile(e.y, s)
+		c.emit(binaryCodes[e.op], 0, -1)
+	case compare:
+		c.compile(e.x, s)
+		c.compile(e.y, s)
+		c.emit(compareCodes[e.op], 0, -1)
+	case logical:
+		// x && y compiles to:         x || y compiles to:
+		//     x                           x
+		//     jumpfalse L1                jumpfalse L1
+		//     y                           const 1
+		//     bool                        jump L2
+		//     jump L2                 L1: y
+		// L1: const 0                     bool
+		// L2:                         L2:
+		c.compile(e.x, s)
+		l1 := c.emit(bcJumpFalse, 0, -1)
+		if e.op == opAnd {
+			c.compile(e.y, s)
+			c.emit(bcBool, 0, 0)
+		} else {
+			c.constant(1)
+		}
+		l2 := c.emit(bcJump, 0, 0)
+		c.depth--
+		c.patch(l1)
+		if e.op == opAnd {
+			c.constant(0)
+		} else {
+			c.compile(e.y, s)
+			c.emit(bcBool, 0, 0)
+		}
+		c.patch(l2)
+	case conditional:
+		c.compile(e.cond, s)
+		l1 := c.emit(bcJumpFalse, 0, -1)
+		c.compile(e.t, s)
+		l2 := c.emit(bcJump, 0, 0)
+		c.depth--
+		c.patch(l1)
+		c.compile(e.f, s)
+		c.patch(l2)
+	case call:
+		for _, arg := range e.args {
+			c.compile(arg, s)
+		}
+		for sc := s; sc != nil; sc = sc.outer {
+			if f, ok := sc.funcs[e.fn]; ok {
+				// inline: pop the arguments into the parameters' slots
+				params := make(map[Var]int)
+				for i := len(f.def.params) - 1; i >= 0; i-- {
+					params[f.def.params[i]] = c.store()
+				}
+				c.compile(f.def.body, &compileScope{slots: params, outer: f.scope})
+				return
+			}
+		}
+		i, ok := c.funcs[e.fn]
+		if !ok {
+			f, _ := c.ctx.lookupFunc(e.fn)
+			i = len(c.prog.funcs)
+			c.prog.funcs = append(c.prog.funcs, f)
+			c.funcs[e.fn] = i
+		}
+		pc := c.emit(bcCall, i, 1-len(e.args))
+		c.prog.code[pc].nargs = uint16(len(e.args))
+	case block:
+		funcs := &compileScope{funcs: make(map[string]*compileFunc), outer: s}
+		for i := range e.defs {
+			if d := &e.defs[i]; d.fn {
+				funcs.funcs[d.name] = &compileFunc{d, funcs}
+			}
+		}
+		lets := &compileScope{slots: make(map[Var]int), outer: funcs}
+		for _, d := range e.defs {
+			if !d.fn {
+				c.compile(d.body, lets)
+				lets.slots[Var(d.name)] = c.store()
+			}
+		}
+		c.compile(e.body, lets)
+	default:
+		panic(fmt.Sprintf("cannot compile %T", e))
+	}
+}
+
+// Vars returns the variables whose values Run expects, in order.
+func (p *Program) Vars() []Var { return p.vars }
+
+// Run executes the program with vals[i] as the value of the i'th
+// variable passed to Compile and returns the result.
+// Run is safe to call from multiple goroutines.
+func (p *Program) Run(vals []float64) float64 { return p.RunBuf(vals, nil) }
+
+// ScratchLen returns the length of scratch that RunBuf needs.
+func (p *Program) ScratchLen() int { return p.nslots + p.maxStack }
+
+// RunBuf is like Run but works in scratch rather than memory of its
+// own, if it is at least ScratchLen long, so that a caller running p
+// many times need not allocate each time. Calls that run at once need
+// scratch of their own.
+func (p *Program) RunBuf(vals, scratch []float64) float64 {
+	if len(vals) != len(p.vars) {
+		panic(fmt.Sprintf("eval: Run with %d values, want %d", len(vals), len(p.vars)))
+	}
+	mem := scratch
+	if len(mem) < p.ScratchLen() {
+		mem = make([]float64, p.ScratchLen())
+	}
+	slots, stack := mem[:p.nslots], mem[p.nslots:]
+	copy(slots, vals)
+	sp := 0 // number of values on the stack
+	code := p.code
+	for pc := 0; pc < len(code); pc++ {
+		in := code[pc]
+		switch in.op {
+		case bcConst:
+			stack[sp] = p.consts[in.arg]
+			sp++
+		case bcLoad:
+			stack[sp] = slots[in.arg]
+			sp++
+		case bcStore:
+			sp--
+			slots[in.arg] = stack[sp]
+		case bcNeg:
+			stack[sp-1] = -stack[sp-1]
+		case bcNot:
+			stack[sp-1] = boolToFloat(stack[sp-1] == 0)
+		case bcBool:
+			stack[sp-1] = boolToFloat(stack[sp-1] != 0)
+		case bcAdd:
+			sp--
+			stack[sp-1] += stack[sp]
+		case bcSub:
+			sp--
+			stack[sp-1] -= stack[sp]
+		case bcMul:
+			sp--
+			stack[sp-1] *= stack[sp]
+		case bcDiv:
+			sp--
+			stack[sp-1] /= stack[sp]
+		case bcLT:
+			sp--
+			stack[sp-1] = boolToFloat(stack[sp-1] < stack[sp])
+		case bcLE:
+			sp--
+			stack[sp-1] = boolToFloat(stack[sp-1] <= stack[sp])
+		case bcGT:
+			sp--
+			stack[sp-1] = boolToFloat(stack[sp-1] > stack[sp])
+		case bcGE:
+			sp--
+			stack[sp-1] = boolToFloat(stack[sp-1] >= stack[sp])
+		case bcEQ:
+			sp--
+			stack[sp-1] = boolToFloat(stack[sp-1] == stack[sp])
+		case bcNE:
+			sp--
+			stack[sp-1] = boolToFloat(stack[sp-1] != stack[sp])
+		case bcCall:
+			n := int(in.nargs)
+			v := p.funcs[in.arg].Impl(stack[sp-n : sp])
+			sp -= n
+			stack[sp] = v
+			sp++
+		case bcJump:
+			pc = int(in.arg) - 1
+		case bcJumpFalse:
+			sp--
+			if stack[sp] == 0 {
+				pc = int(in.arg) - 1
+			}
+		default:
+			panic(fmt.Sprintf("eval: bad opcode %d", in.op))
+		}
+	}
+	return stack[0]
+}
@@ -0,0 +1,132 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	exprs := []string{
+		"sqrt(A / pi)",
+		"pow(x, 3) + pow(y, 3)",
+		"5 / 9 * (F - 32)",
+		"-x * -x + +y",
+		"x < 0 ? -x : x",
+		"if(x == 0, 1, sin(x) / x)",
+		"x >= 1 && y <= 2 || !(x != y)",
+		"x > 0 || 1 / x > 0",
+		"max(x, y, A, 3) - min(F)",
+		"x < -1 ? -1 : x > 1 ? 1 : x",
+	}
+	vars := []Var{"x", "y", "A", "pi", "F"}
+	envs := []Env{
+		{"x": 0, "y": 0, "A": 0, "pi": math.Pi, "F": 0},
+		{"x": 1, "y": 2, "A": 87616, "pi": math.Pi, "F": -40},
+		{"x": -3, "y": -3, "A": 1, "pi": math.Pi, "F": 212},
+		{"x": 12, "y": 1, "A": -1, "pi": math.Pi, "F": 32},
+	}
+	for _, s := range exprs {
+		expr, err := Parse(s)
+		if err != nil {
+			t.Error(err) // parse error
+			continue
+		}
+		prog, err := Compile(expr, vars)
+		if err != nil {
+			t.Errorf("Compile(%s): %v", s, err)
+			continue
+		}
+		scratch := make([]float64, prog.ScratchLen()) // reused, as left by the last run
+		for _, env := range envs {
+			vals := make([]float64, len(vars))
+			for i, v := range vars {
+				vals[i] = env[v]
+			}
+			got, want := prog.Run(vals), expr.Eval(env)
+			if got != want && !(math.IsNaN(got) && math.IsNaN(want)) {
+				t.Errorf("%s.Run() in %v = %g, want %g", s, env, got, want)
+			}
+			if buf := prog.RunBuf(vals, scratch); buf != got && !(math.IsNaN(buf) && math.IsNaN(got)) {
+				t.Errorf("%s.RunBuf() in %v = %g, want %g", s, env, buf, got)
+			}
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		vars []Var
+		want string
+	}{
+		{"x + y", []Var{"x"}, "undefined variable: y"},
+		{"x", []Var{"x", "x"}, "duplicate variable x"},
+		{"frob(x)", []Var{"x"}, `unknown function "frob"`},
+		{"sqrt(x, x)", []Var{"x"}, "call to sqrt has 2 args, want 1"},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Error(err) // parse error
+			continue
+		}
+		_, err = Compile(expr, test.vars)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("Compile(%s, %v) = %v, want %q", test.expr, test.vars, err, test.want)
+		}
+	}
+}
+
+// surfaceExpr is typical of the expressions given to the surface plotter.
+const surfaceExpr = "sin(-x)*pow(1.5,-r)"
+
+// BenchmarkEval and BenchmarkRun evaluate surfaceExpr over the
+// 100x100 grid of the surface plotter, by walking the tree and by
+// running the compiled program respectively.
+//
+//	$ go test -bench=. -run=NONE digest_gopl/ch7/eval
+func BenchmarkEval(b *testing.B) {
+	expr, err := Parse(surfaceExpr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for n := 0; n < b.N; n++ {
+		for i := 0; i < 100; i++ {
+			for j := 0; j < 100; j++ {
+				x, y := float64(i-50)*0.3, float64(j-50)*0.3
+				expr.Eval(Env{"x": x, "y": y, "r": math.Hypot(x, y)})
+			}
+		}
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	expr, err := Parse(surfaceExpr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	prog, err := Compile(expr, []Var{"x", "y", "r"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, buf := range []bool{false, true} {
+		b.Run(fmt.Sprintf("buf=%t", buf), func(b *testing.B) {
+			b.ReportAllocs()
+			vals := make([]float64, 3)
+			var scratch []float64
+			if buf {
+				scratch = make([]float64, prog.ScratchLen())
+			}
+			for n := 0; n < b.N; n++ {
+				for i := 0; i < 100; i++ {
+					for j := 0; j < 100; j++ {
+						x, y := float64(i-50)*0.3, float64(j-50)*0.3
+						vals[0], vals[1], vals[2] = x, y, math.Hypot(x, y)
+						prog.RunBuf(vals, scratch)
+					}
+				}
+			}
+		})
+	}
+}
@@ -12,9 +12,25 @@ func TestCoverage(t *testing.T) {
 		env   Env
 		want  string // expected error from Parse/Check or result from Eval
 	}{
-		{"x % 2", nil, "unexpected '%'"},
-		{"!true", nil, "unexpected '!'"},
-		{"log(10)", nil, `unknown function "log"`},
+		{"x % 2", nil, "1:3: unexpected '%'"},
+		{"x ! y", nil, "1:3: unexpected '!'"},
+		{"x = 1", nil, "1:1: left side of '=' must be f(params)"},
+		{"x & y", nil, "1:3: unexpected '&'"},
+		{"x && && y", nil, "1:6: unexpected '&&'"},
+		{"x ? 1", nil, "1:6: got end of file, want ':'"},
+		{"if(x, 1)", nil, "1:1: call to if has 2 args, want 3"},
+		{"x < 1 ? frob(x) : 0", nil, `unknown function "frob"`},
+		{"x <= 1 && sqrt(1, 2)", nil, "call to sqrt has 2 args, want 1"},
+		{"!(x == 2) + (x != 2)", Env{"x": 2}, "0"},
+		{"x < y ? x : y", Env{"x": 2, "y": 3}, "2"},
+		{"frob(10)", nil, `unknown function "frob"`},
+		{"max()", nil, "call to max has 0 args, want at least 1"},
+		{"atan2(1)", nil, "call to atan2 has 1 args, want 2"},
+		{"log(10)", nil, "2.30259"},
+		{"max(x, 3, y)", Env{"x": 1, "y": 2}, "3"},
+		{"min(x)", Env{"x": 7}, "7"},
+		{"4 * atan2(1, 1)", nil, "3.14159"},
+		{"floor(hypot(3, 4.5))", nil, "5"},
 		{"sqrt(1, 2)", nil, "call to sqrt has 2 args, want 1"},
 		{"sqrt(A / pi)", Env{"A": 87616, "pi": math.Pi}, "167"},
 		{"pow(x, 3) + pow(y, 3)", Env{"x": 9, "y": 10}, "1729"},
@@ -0,0 +1,355 @@
+package eval
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ---- definitions ----
+
+// A block is a sequence of definitions followed by the expression that
+// uses them, e.g., let a = x*x; f(t) = sin(t)/t; f(a) + r
+//
+// Scoping rules:
+//   - a let binding is visible in the definitions after it and in the
+//     final expression, where it shadows any variable of the same name
+//     in the environment and any earlier let of the same name;
+//   - a function is visible throughout the block, including in its own
+//     body and those of the other functions, and shadows any function
+//     of the same name in the FuncTable or an enclosing block;
+//   - a function body sees only its parameters, the functions, and the
+//     enclosing environment; it may not use a name bound by a let of
+//     its block. Functions may not be recursive.
+type block struct {
+	defs []def
+	body Expr
+	at   pos
+}
+
+// A def is one definition of a block.
+type def struct {
+	name   string
+	fn     bool  // whether this defines a function rather than a let
+	params []Var // the function's parameters
+	body   Expr
+}
+
+// scopes returns the context in which the function bodies of b are
+// evaluated, with ctx as the enclosing context, and the initially empty
+// context of its let bindings.
+func (b block) scopes(ctx *Context) (funcs, lets *Context) {
+	funcs = &Context{Funcs: make(FuncTable), outer: ctx}
+	for _, d := range b.defs {
+		if d.fn {
+			d := d
+			funcs.Funcs[d.name] = Func{
+				Arity: len(d.params),
+				Impl: func(args []float64) float64 {
+					env := make(Env, len(args))
+					for i, p := range d.params {
+						env[p] = args[i]
+					}
+					return d.body.EvalContext(&Context{Env: env, outer: funcs})
+				},
+			}
+		}
+	}
+	return funcs, &Context{Env: make(Env), outer: funcs}
+}
+
+func (b block) Eval(env Env) float64 { return b.EvalContext(&Context{Env: env}) }
+func (b block) EvalContext(ctx *Context) float64 {
+	_, lets := b.scopes(ctx)
+	for _, d := range b.defs {
+		if !d.fn {
+			lets.Env[Var(d.name)] = d.body.EvalContext(lets)
+		}
+	}
+	return b.body.EvalContext(lets)
+}
+
+func (b block) Check(vars map[Var]bool) error { return b.CheckContext(&Context{}, vars) }
+func (b block) CheckContext(ctx *Context, vars map[Var]bool) error {
+	funcs, lets := b.scopes(ctx)
+
+	letNames := make(map[Var]bool)
+	for _, d := range b.defs {
+		if !d.fn {
+			letNames[Var(d.name)] = true
+		}
+	}
+
+	calls := make(map[string][]string) // the block's functions called by each one
+	for _, d := range b.defs {
+		if !d.fn {
+			continue
+		}
+		if _, ok := calls[d.name]; ok {
+			return checkErrorf(b, "function %s redefined", d.name)
+		}
+		calls[d.name] = nil
+		local := make(map[Var]bool)
+		for _, p := range d.params {
+			if local[p] {
+				return checkErrorf(b, "duplicate parameter %s of function %s", p, d.name)
+			}
+			local[p] = true
+		}
+		used := make(map[Var]bool)
+		if err := d.body.CheckContext(&Context{outer: funcs}, used); err != nil {
+			return err
+		}
+		for v := range used {
+			if local[v] {
+				continue
+			}
+			if letNames[v] {
+				return checkErrorf(b, "function %s uses %s, which is defined by let", d.name, v)
+			}
+			vars[v] = true
+		}
+		calledIn(d.body, func(name string) {
+			if _, ok := funcs.Funcs[name]; ok {
+				calls[d.name] = append(calls[d.name], name)
+			}
+		})
+	}
+	if err := checkRecursion(calls); err != nil {
+		return &CheckError{b, err.Error()}
+	}
+
+	bound := make(map[Var]bool) // let names visible so far
+	checkFree := func(e Expr) error {
+		used := make(map[Var]bool)
+		if err := e.CheckContext(lets, used); err != nil {
+			return err
+		}
+		for v := range used {
+			if !bound[v] {
+				vars[v] = true
+			}
+		}
+		return nil
+	}
+	for _, d := range b.defs {
+		if !d.fn {
+			if err := checkFree(d.body); err != nil {
+				return err
+			}
+			bound[Var(d.name)] = true
+		}
+	}
+	return checkFree(b.body)
+}
+
+// calledIn calls visit for each function that e calls, other than
+// those defined by blocks within e.
+func calledIn(e Expr, visit func(name string)) {
+	switch e := e.(type) {
+	case unary:
+		calledIn(e.x, visit)
+	case binary:
+		calledIn(e.x, visit)
+		calledIn(e.y, visit)
+	case compare:
+		calledIn(e.x, visit)
+		calledIn(e.y, visit)
+	case logical:
+		calledIn(e.x, visit)
+		calledIn(e.y, visit)
+	case conditional:
+		calledIn(e.cond, visit)
+		calledIn(e.t, visit)
+		calledIn(e.f, visit)
+	case call:
+		visit(e.fn)
+		for _, arg := range e.args {
+			calledIn(arg, visit)
+		}
+	case block:
+		local := make(map[string]bool)
+		for _, d := range e.defs {
+			if d.fn {
+				local[d.name] = true
+			}
+		}
+		inner := func(name string) {
+			if !local[name] {
+				visit(name)
+			}
+		}
+		for _, d := range e.defs {
+			calledIn(d.body, inner)
+		}
+		calledIn(e.body, inner)
+	}
+}
+
+// checkRecursion reports an error if the call graph has a cycle.
+func checkRecursion(calls map[string][]string) error {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(f string) error
+	visit = func(f string) error {
+		switch state[f] {
+		case visiting:
+			for i, g := range path {
+				if g == f {
+					cycle := append(path[i:], f)
+					return fmt.Errorf("recursive function %s: %s", f, strings.Join(cycle, " -> "))
+				}
+			}
+		case done:
+			return nil
+		}
+		state[f] = visiting
+		path = append(path, f)
+		for _, g := range calls[f] {
+			if err := visit(g); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[f] = done
+		return nil
+	}
+
+	// visit in a fixed order so that the error is deterministic
+	var names []string
+	for f := range calls {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+	for _, f := range names {
+		if err := visit(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b block) String() string {
+	var buf strings.Builder
+	for _, d := range b.defs {
+		if d.fn {
+			params := make([]string, len(d.params))
+			for i, p := range d.params {
+				params[i] = string(p)
+			}
+			fmt.Fprintf(&buf, "%s(%s) = ", d.name, strings.Join(params, ", "))
+		} else {
+			fmt.Fprintf(&buf, "let %s = ", d.name)
+		}
+		buf.WriteString(operand(d.body, precConditional))
+		buf.WriteString("; ")
+	}
+	buf.WriteString(operand(b.body, precConditional))
+	return buf.String()
+}
+
+// ---- expansion ----
+
+type expandPanic string
+
+// Expand returns an expression equivalent to e with all definitions
+// inlined: each use of a let binding is replaced by its value, and each
+// call of a function defined in a block by the function's body. It
+// reports an error if a function is recursive.
+func Expand(e Expr) (_ Expr, err error) {
+	defer func() {
+		switch x := recover().(type) {
+		case nil:
+			// no panic
+		case expandPanic:
+			err = fmt.Errorf("%s", x)
+		default:
+			// unexpected panic: resume state of panic.
+			panic(x)
+		}
+	}()
+	return expand(e, nil, make(map[*def]bool)), nil
+}
+
+// An expandScope holds the definitions visible during expansion.
+type expandScope struct {
+	vars  map[Var]Expr // expanded values of lets or arguments
+	funcs map[string]*expandFunc
+	outer *expandScope
+}
+
+type expandFunc struct {
+	def   *def
+	scope *expandScope // where the function was defined
+}
+
+func expand(e Expr, s *expandScope, active map[*def]bool) Expr {
+	switch e := e.(type) {
+	case Var:
+		for ; s != nil; s = s.outer {
+			if x, ok := s.vars[e]; ok {
+				return x
+			}
+		}
+		return e
+	case literal:
+		return e
+	case unary:
+		return unary{e.op, expand(e.x, s, active), e.at}
+	case binary:
+		return binary{e.op, expand(e.x, s, active), expand(e.y, s, active), e.at}
+	case compare:
+		return compare{e.op, expand(e.x, s, active), expand(e.y, s, active), e.at}
+	case logical:
+		return logical{e.op, expand(e.x, s, active), expand(e.y, s, active), e.at}
+	case conditional:
+		return conditional{expand(e.cond, s, active), expand(e.t, s, active), expand(e.f, s, active), e.at}
+	case call:
+		var args []Expr
+		for _, arg := range e.args {
+			args = append(args, expand(arg, s, active))
+		}
+		for sc := s; sc != nil; sc = sc.outer {
+			f, ok := sc.funcs[e.fn]
+			if !ok {
+				continue
+			}
+			if active[f.def] {
+				panic(expandPanic(fmt.Sprintf("recursive function %s", e.fn)))
+			}
+			if len(args) != len(f.def.params) {
+				panic(expandPanic(fmt.Sprintf("call to %s has %d args, want %d",
+					e.fn, len(args), len(f.def.params))))
+			}
+			params := make(map[Var]Expr)
+			for i, p := range f.def.params {
+				params[p] = args[i]
+			}
+			active[f.def] = true
+			body := expand(f.def.body, &expandScope{vars: params, outer: f.scope}, active)
+			delete(active, f.def)
+			return body
+		}
+		return call{e.fn, args, e.at}
+	case block:
+		funcs := &expandScope{funcs: make(map[string]*expandFunc), outer: s}
+		for i := range e.defs {
+			if d := &e.defs[i]; d.fn {
+				funcs.funcs[d.name] = &expandFunc{d, funcs}
+			}
+		}
+		lets := &expandScope{vars: make(map[Var]Expr), outer: funcs}
+		for _, d := range e.defs {
+			if !d.fn {
+				lets.vars[Var(d.name)] = expand(d.body, lets, active)
+			}
+		}
+		return expand(e.body, lets, active)
+	}
+	panic(fmt.Sprintf("cannot expand %T", e))
+}
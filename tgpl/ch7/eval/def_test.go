@@ -0,0 +1,110 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestBlock(t *testing.T) {
+	tests := []struct {
+		input string
+		env   Env
+		want  string // expected error from Parse/Check or result from Eval
+	}{
+		{"let a = x*x; f(t) = sin(t)/t; f(a) + r", Env{"x": 2, "r": 1}, "0.810799"},
+		{"let a = 1; let a = a + 1; a * 10", nil, "20"},
+		{"let x = x + 1; x", Env{"x": 5}, "6"},                     // let shadows env
+		{"let b = a; let a = 2; a + b", Env{"a": 10}, "12"},        // sequential scope
+		{"f(x) = x * 2; f(3) + x", Env{"x": 1}, "7"},               // parameter shadows env
+		{"g(t) = f(t) + 1; f(t) = t * t; g(3)", nil, "10"},         // functions in any order
+		{"sin(t) = 2 * t; sin(pi)", Env{"pi": math.Pi}, "6.28319"}, // shadows FuncTable
+		{"f(t) = t + y; let y = 100; f(1)", Env{"y": 1}, "function f uses y, which is defined by let"},
+		{"f(t) = (let u = t + 1; u * u); f(2)", nil, "9"},
+		{"f(t) = t; (f(t) = t + 1; f(1)) + f(1)", nil, "3"}, // inner block shadows outer
+		{"f() = 42; f()", nil, "42"},
+		{"let a = pow(2, 10); a - 1000", nil, "24"},
+		{"f(t) = f(t - 1); f(3)", nil, "recursive function f: f -> f"},
+		{"f(t) = g(t); g(t) = h(t); h(t) = f(t) + 1; 0", nil, "recursive function f: f -> g -> h -> f"},
+		{"f(t) = (g(s) = f(s); g(t)); f(1)", nil, "recursive function f: f -> f"},
+		{"f(t) = 1; f(t) = 2; f(0)", nil, "function f redefined"},
+		{"f(t, t) = t; f(1, 2)", nil, "duplicate parameter t of function f"},
+		{"f(s, t) = s * t; f(1)", nil, "call to f has 1 args, want 2"},
+		{"let a = frob(1); a", nil, `unknown function "frob"`},
+		{"let 1 = 2; 1", nil, "1:5: got number 1, want identifier"},
+		{"let a 2; a", nil, "1:7: got number 2, want '='"},
+		{"let a = 2 a", nil, "1:11: got identifier a, want ';'"},
+		{"f(1) = 2; f(1)", nil, "1:1: parameter of f must be an identifier"},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if err == nil {
+			err = expr.Check(map[Var]bool{})
+		}
+		if err != nil {
+			if err.Error() != test.want {
+				t.Errorf("%s: got %q, want %q", test.input, err, test.want)
+			}
+			continue
+		}
+		got := fmt.Sprintf("%.6g", expr.Eval(test.env))
+		if got != test.want {
+			t.Errorf("%s: %v => %s, want %s", test.input, test.env, got, test.want)
+		}
+	}
+}
+
+func TestBlockVars(t *testing.T) {
+	expr, err := Parse("let a = x*x; let b = a + y; f(t) = t * z; f(b) + a + r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := make(map[Var]bool)
+	if err := expr.Check(vars); err != nil {
+		t.Fatal(err)
+	}
+	want := map[Var]bool{"x": true, "y": true, "z": true, "r": true}
+	if fmt.Sprint(vars) != fmt.Sprint(want) {
+		t.Errorf("Check found free variables %v, want %v", vars, want)
+	}
+}
+
+func TestBlockCompileDerive(t *testing.T) {
+	for _, input := range []string{
+		"let a = x*x; f(t) = sin(t)/t; f(a) + y",
+		"let a = x + 1; let a = a * a; g(s, t) = s * h(t); h(t) = t - y; g(a, x) + a",
+		"f(t) = (let u = t * t; u * u); f(x) * f(y)",
+	} {
+		expr, err := Parse(input)
+		if err != nil {
+			t.Error(err) // parse error
+			continue
+		}
+		prog, err := Compile(expr, []Var{"x", "y"})
+		if err != nil {
+			t.Errorf("Compile(%s): %v", input, err)
+			continue
+		}
+		expanded, err := Expand(expr)
+		if err != nil {
+			t.Errorf("Expand(%s): %v", input, err)
+			continue
+		}
+		d := Derive(expr, "x")
+		for _, env := range []Env{{"x": 0.5, "y": 2}, {"x": -1.5, "y": 0.25}} {
+			want := expr.Eval(env)
+			if got := prog.Run([]float64{env["x"], env["y"]}); got != want {
+				t.Errorf("%s.Run() in %v = %g, want %g", input, env, got, want)
+			}
+			if got := expanded.Eval(env); got != want {
+				t.Errorf("Expand(%s) = %s; in %v = %g, want %g", input, expanded, env, got, want)
+			}
+			const h = 1e-6
+			want = (expr.Eval(Env{"x": env["x"] + h, "y": env["y"]}) -
+				expr.Eval(Env{"x": env["x"] - h, "y": env["y"]})) / (2 * h)
+			if got := d.Eval(env); math.Abs(got-want) > 1e-5*math.Max(1, math.Abs(want)) {
+				t.Errorf("d/dx %s = %s; in %v = %g, want %g", input, d, env, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+)
+
+// ---- symbolic differentiation ----
+
+type derivePanic string
+
+// Derive returns the derivative of e with respect to v, simplified.
+// Definitions in e are expanded first. Calls are differentiated with the chain rule using the Partial of
+// the function in DefaultFuncs. Comparisons and logical operators
+// are piecewise constant, so their derivative is taken to be 0.
+//
+// Derive panics if e calls a function with no known derivative;
+// DeriveFuncs reports that as an error instead.
+func Derive(e Expr, v Var) Expr {
+	d, err := DeriveFuncs(e, v, nil)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// DeriveFuncs is like Derive but takes functions and their
+// derivatives from funcs, and checks e against them first. If funcs is
+// nil, DefaultFuncs is used.
+func DeriveFuncs(e Expr, v Var, funcs FuncTable) (_ Expr, err error) {
+	defer func() {
+		switch x := recover().(type) {
+		case nil:
+			// no panic
+		case derivePanic:
+			err = fmt.Errorf("%s", x)
+		default:
+			// unexpected panic: resume state of panic.
+			panic(x)
+		}
+	}()
+	if funcs == nil {
+		funcs = DefaultFuncs
+	}
+	// lest a bad call reach a derivative formula, with its parameters
+	if err := e.CheckContext(&Context{Funcs: funcs}, make(map[Var]bool)); err != nil {
+		return nil, err
+	}
+	e, err = Expand(e) // differentiate through any definitions
+	if err != nil {
+		return nil, err
+	}
+	return simplify(derive(e, v, funcs), funcs), nil
+}
+
+func derive(e Expr, v Var, funcs FuncTable) Expr {
+	switch e := e.(type) {
+	case Var:
+		if e == v {
+			return literal(1)
+		}
+		return literal(0)
+	case literal:
+		return literal(0)
+	case unary:
+		switch e.op {
+		case '+', '-':
+			return unary{e.op, derive(e.x, v, funcs), e.at}
+		}
+		return literal(0) // '!'
+	case binary:
+		dx, dy := derive(e.x, v, funcs), derive(e.y, v, funcs)
+		switch e.op {
+		case '+', '-':
+			return binary{e.op, dx, dy, e.at}
+		case '*':
+			// (xy)' = x'y + xy'
+			return binary{'+', binary{'*', dx, e.y, e.at}, binary{'*', e.x, dy, e.at}, e.at}
+		case '/':
+			// (x/y)' = (x'y - xy') / y²
+			return binary{'/',
+				binary{'-', binary{'*', dx, e.y, e.at}, binary{'*', e.x, dy, e.at}, e.at},
+				binary{'*', e.y, e.y, e.at}, e.at}
+		}
+	case compare, logical:
+		return literal(0)
+	case conditional:
+		return conditional{e.cond, derive(e.t, v, funcs), derive(e.f, v, funcs), e.at}
+	case call:
+		f, ok := funcs[e.fn]
+		if !ok {
+			panic(derivePanic(fmt.Sprintf("unknown function %q", e.fn)))
+		}
+		if f.Partial == nil {
+			panic(derivePanic(fmt.Sprintf("no derivative for function %q", e.fn)))
+		}
+		// chain rule: f(g1, ..., gn)' = Σ ∂f/∂gi · gi'
+		var sum Expr = literal(0)
+		for i, arg := range e.args {
+			darg := simplify(derive(arg, v, funcs), funcs)
+			if l, ok := darg.(literal); ok && l == 0 {
+				continue // also avoids evaluating partials that are undefined here
+			}
+			sum = binary{'+', sum, binary{'*', f.Partial(e.args, i), darg, e.at}, e.at}
+		}
+		return sum
+	}
+	panic(fmt.Sprintf("cannot derive %T", e))
+}
+
+// Subst returns a copy of e in which each free variable in m is
+// replaced by the corresponding expression. Bound names are not
+// renamed, so the replacements should not use names that e defines.
+func Subst(e Expr, m map[Var]Expr) Expr {
+	switch e := e.(type) {
+	case Var:
+		if r, ok := m[e]; ok {
+			return r
+		}
+		return e
+	case literal:
+		return e
+	case unary:
+		return unary{e.op, Subst(e.x, m), e.at}
+	case binary:
+		return binary{e.op, Subst(e.x, m), Subst(e.y, m), e.at}
+	case compare:
+		return compare{e.op, Subst(e.x, m), Subst(e.y, m), e.at}
+	case logical:
+		return logical{e.op, Subst(e.x, m), Subst(e.y, m), e.at}
+	case conditional:
+		return conditional{Subst(e.cond, m), Subst(e.t, m), Subst(e.f, m), e.at}
+	case call:
+		args := make([]Expr, len(e.args))
+		for i, arg := range e.args {
+			args[i] = Subst(arg, m)
+		}
+		return call{e.fn, args, e.at}
+	case block:
+		defs := make([]def, len(e.defs))
+		lets := m // m less the lets bound so far
+		for i, d := range e.defs {
+			defs[i] = d
+			if d.fn {
+				defs[i].body = Subst(d.body, without(m, d.params...))
+			} else {
+				defs[i].body = Subst(d.body, lets)
+				lets = without(lets, Var(d.name))
+			}
+		}
+		return block{defs, Subst(e.body, lets), e.at}
+	}
+	panic(fmt.Sprintf("cannot substitute in %T", e))
+}
+
+// without returns m less the entries for vars, copying m only if needed.
+func without(m map[Var]Expr, vars ...Var) map[Var]Expr {
+	var r map[Var]Expr
+	for _, v := range vars {
+		if _, ok := m[v]; ok {
+			if r == nil {
+				r = make(map[Var]Expr, len(m))
+				for k, x := range m {
+					r[k] = x
+				}
+			}
+			delete(r, v)
+		}
+	}
+	if r == nil {
+		return m
+	}
+	return r
+}
+
+// ---- simplification ----
+
+// Simplify returns an expression equivalent to e with constant
+// subexpressions folded (calls via DefaultFuncs) and the identities
+// x+0 = 0+x = x-0 = x, 0-x = -x, x*1 = 1*x = x/1 = x, --x = x,
+// pow(x, 1) = x and pow(x, 0) = 1 applied. It also rewrites x*0,
+// 0*x and 0/x to 0, which is not exact if x is infinite or NaN.
+func Simplify(e Expr) Expr { return simplify(e, DefaultFuncs) }
+
+func simplify(e Expr, funcs FuncTable) Expr {
+	switch e := e.(type) {
+	case unary:
+		x := simplify(e.x, funcs)
+		if isLiteral(x) {
+			return constFold(unary{e.op, x, e.at}, funcs)
+		}
+		switch e.op {
+		case '+':
+			return x
+		case '-':
+			if u, ok := x.(unary); ok && u.op == '-' {
+				return u.x // --x = x
+			}
+		}
+		return unary{e.op, x, e.at}
+	case binary:
+		x, y := simplify(e.x, funcs), simplify(e.y, funcs)
+		lx, xok := x.(literal)
+		ly, yok := y.(literal)
+		if xok && yok {
+			return constFold(binary{e.op, x, y, e.at}, funcs)
+		}
+		switch e.op {
+		case '+':
+			if xok && lx == 0 {
+				return y
+			}
+			if yok && ly == 0 {
+				return x
+			}
+		case '-':
+			if yok && ly == 0 {
+				return x
+			}
+			if xok && lx == 0 {
+				return simplify(unary{'-', y, e.at}, funcs)
+			}
+		case '*':
+			if (xok && lx == 0) || (yok && ly == 0) {
+				return literal(0)
+			}
+			if xok && lx == 1 {
+				return y
+			}
+			if yok && ly == 1 {
+				return x
+			}
+			if xok && lx == -1 {
+				return simplify(unary{'-', y, e.at}, funcs)
+			}
+			if yok && ly == -1 {
+				return simplify(unary{'-', x, e.at}, funcs)
+			}
+		case '/':
+			if xok && lx == 0 {
+				return literal(0)
+			}
+			if yok && ly == 1 {
+				return x
+			}
+		}
+		return binary{e.op, x, y, e.at}
+	case compare:
+		x, y := simplify(e.x, funcs), simplify(e.y, funcs)
+		if isLiteral(x) && isLiteral(y) {
+			return constFold(compare{e.op, x, y, e.at}, funcs)
+		}
+		return compare{e.op, x, y, e.at}
+	case logical:
+		x, y := simplify(e.x, funcs), simplify(e.y, funcs)
+		if lx, ok := x.(literal); ok {
+			// a constant left operand decides the result or defers to y
+			if (e.op == opAnd) == (lx == 0) {
+				return literal(boolToFloat(lx != 0))
+			}
+			if isLiteral(y) {
+				return constFold(logical{e.op, x, y, e.at}, funcs)
+			}
+		}
+		return logical{e.op, x, y, e.at}
+	case conditional:
+		cond := simplify(e.cond, funcs)
+		if l, ok := cond.(literal); ok {
+			if l != 0 {
+				return simplify(e.t, funcs)
+			}
+			return simplify(e.f, funcs)
+		}
+		return conditional{cond, simplify(e.t, funcs), simplify(e.f, funcs), e.at}
+	case call:
+		args := make([]Expr, len(e.args))
+		constant := true
+		for i, arg := range e.args {
+			args[i] = simplify(arg, funcs)
+			constant = constant && isLiteral(args[i])
+		}
+		c := call{e.fn, args, e.at}
+		if f, ok := funcs[e.fn]; ok && f.checkArity(e.fn, len(args)) == nil {
+			if constant {
+				return constFold(c, funcs)
+			}
+			if e.fn == "pow" {
+				if l, ok := args[1].(literal); ok && l == 1 {
+					return args[0]
+				} else if ok && l == 0 {
+					return literal(1)
+				}
+			}
+		}
+		return c
+	case block:
+		// calls of the block's own functions must not be folded
+		inner, copied := funcs, false
+		for _, d := range e.defs {
+			if _, ok := inner[d.name]; ok && d.fn {
+				if !copied {
+					inner = make(FuncTable, len(funcs))
+					for name, f := range funcs {
+						inner[name] = f
+					}
+					copied = true
+				}
+				delete(inner, d.name)
+			}
+		}
+		defs := make([]def, len(e.defs))
+		for i, d := range e.defs {
+			defs[i] = d
+			defs[i].body = simplify(d.body, inner)
+		}
+		return block{defs, simplify(e.body, inner), e.at}
+	}
+	return e // Var, literal
+}
+
+// constFold returns the value of e, which has only literal operands,
+// as a literal, or e itself if the value is not finite.
+func constFold(e Expr, funcs FuncTable) Expr {
+	v := e.EvalContext(&Context{Funcs: funcs})
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return e
+	}
+	return literal(v)
+}
+
+func isLiteral(e Expr) bool {
+	_, ok := e.(literal)
+	return ok
+}
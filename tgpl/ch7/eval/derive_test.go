@@ -0,0 +1,167 @@
+package eval
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestDerive(t *testing.T) {
+	tests := []struct {
+		expr string
+		v    Var
+	}{
+		{"x*x*x", "x"},
+		{"x*y + 3*x - y", "x"},
+		{"x*y + 3*x - y", "y"},
+		{"1 / x", "x"},
+		{"(x + 1) / (x - 2)", "x"},
+		{"pow(x, 3)", "x"},
+		{"pow(2, x)", "x"},
+		{"pow(x, y)", "y"},
+		{"sin(x*x)", "x"},
+		{"sqrt(1 + x*x)", "x"},
+		{"sin(-x)*pow(1.5, -r)", "r"},
+		{"exp(-x)*cos(y)", "x"},
+		{"log(x) + atan2(y, x)", "x"},
+		{"hypot(x, y)", "y"},
+		{"x < 0 ? -x*x : x*x", "x"},
+		{"max(x, 1) + min(x*x, 4)", "x"},
+		{"x > 1 && y < 2", "x"},
+	}
+	points := []Env{
+		{"x": 0.7, "y": 1.3, "r": 2},
+		{"x": 1.9, "y": 0.4, "r": 0.5},
+		{"x": -0.6, "y": 2.5, "r": 3.5},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Error(err) // parse error
+			continue
+		}
+		d := Derive(expr, test.v)
+		for _, env := range points {
+			// compare with a central difference
+			const h = 1e-6
+			lo, hi := Env{}, Env{}
+			for k, v := range env {
+				lo[k], hi[k] = v, v
+			}
+			lo[test.v] -= h
+			hi[test.v] += h
+			want := (expr.Eval(hi) - expr.Eval(lo)) / (2 * h)
+			if math.IsNaN(want) {
+				continue // outside the domain
+			}
+			got := d.Eval(env)
+			if math.Abs(got-want) > 1e-5*math.Max(1, math.Abs(want)) {
+				t.Errorf("d/d%s %s = %#v; in %v = %g, want %g",
+					test.v, test.expr, d, env, got, want)
+			}
+		}
+	}
+}
+
+func TestDeriveFuncs(t *testing.T) {
+	funcs := FuncTable{"sq": Func1(func(x float64) float64 { return x * x })}
+	for _, test := range []struct {
+		expr  string
+		funcs FuncTable
+		want  string
+	}{
+		{"gamma(x)", nil, `no derivative for function "gamma"`},
+		{"sq(x)", funcs, `no derivative for function "sq"`},
+		{"sin(x)", funcs, `unknown function "sin"`},
+		{"pow(x)", nil, "call to pow has 1 args, want 2"},
+		{"1 + jn(2000, x)", nil, "call to jn: order 2000 is not an integer from -1000 to 1000"},
+	} {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Error(err) // parse error
+			continue
+		}
+		if _, err := DeriveFuncs(expr, "x", test.funcs); err == nil || err.Error() != test.want {
+			t.Errorf("DeriveFuncs(%s) = %v, want %q", test.expr, err, test.want)
+		}
+	}
+
+	// a Partial written with Parse and Subst
+	tmpl, err := Parse("2 * t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sq := funcs["sq"]
+	sq.Partial = func(args []Expr, i int) Expr {
+		return Subst(tmpl, map[Var]Expr{"t": args[0]})
+	}
+	funcs["sq"] = sq
+	expr, err := Parse("sq(3*x)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := DeriveFuncs(expr, "x", funcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.EvalContext(&Context{Env: Env{"x": 2}, Funcs: funcs}); got != 36 {
+		t.Errorf("d/dx sq(3*x) at 2 = %g, want 36", got)
+	}
+
+	// ties between the arguments of max and min count once
+	for _, test := range []struct {
+		expr string
+		x    float64
+		want float64
+	}{
+		{"max(x, x*x)", 1, 1},
+		{"max(x*x, x)", 1, 2},
+		{"min(x, x, x)", 3, 1},
+		{"min(2*x, x+1, 3)", 1, 2},
+	} {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Derive(expr, "x").Eval(Env{"x": test.x}); got != test.want {
+			t.Errorf("d/dx %s at %g = %g, want %g", test.expr, test.x, got, test.want)
+		}
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{"x*1 + 0", "x"},
+		{"0*x + 1*y - 0", "y"},
+		{"0 - x", "-x"},
+		{"x / 1 + 0 / y", "x"},
+		{"-(-x)", "x"},
+		{"2 * 3 + x", "6 + x"},
+		{"pow(x, 2 - 1) * pow(y, 0)", "x"},
+		{"sqrt(16) * x", "4 * x"},
+		{"1 < 2 ? x : y", "x"},
+		{"0 && x", "0"},
+		{"1 || x", "1"},
+		{"1 && 2 > 1", "1"},
+		{"x * -1", "-x"},
+		{"1 / 0 + x", "1 / 0 + x"}, // not finite: left alone
+		{"sin(x + 0)", "sin(x)"},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Error(err) // parse error
+			continue
+		}
+		want, err := Parse(test.want)
+		if err != nil {
+			t.Error(err) // parse error
+			continue
+		}
+		if got := Simplify(expr); !reflect.DeepEqual(unplaced(got), unplaced(want)) {
+			t.Errorf("Simplify(%s) = %#v, want %#v", test.expr, got, want)
+		}
+	}
+}
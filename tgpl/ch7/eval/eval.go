@@ -3,35 +3,132 @@ package eval
 
 import (
 	"fmt"
-	"math"
 	"strings"
 )
 
 // An Expr is an arithmetic expresson
 type Expr interface {
-	// Eval returns the value of this Expr in the environment env
+	// Eval returns the value of this Expr in the environment env,
+	// calling functions from DefaultFuncs
 	Eval(env Env) float64
 
-	// Check reports errors in this Expr and adds its Vars to the set
+	// EvalContext returns the value of this Expr in the context ctx
+	EvalContext(ctx *Context) float64
+
+	// Check reports errors in this Expr and adds its Vars to the set,
+	// validating calls against DefaultFuncs
 	Check(vars map[Var]bool) error
+
+	// CheckContext is like Check but validates calls against ctx.Funcs
+	CheckContext(ctx *Context, vars map[Var]bool) error
+
+	// EvalInterval returns bounds on the value of this Expr for
+	// variables ranging over the intervals of env, calling functions
+	// from DefaultFuncs
+	EvalInterval(env IntervalEnv) Interval
+
+	// CheckType reports errors in this Expr as Check does, and infers
+	// the type of its value from the types of its variables, reporting
+	// mismatched types
+	CheckType(types TypeEnv) (Type, error)
+
+	// CheckTypeContext is like CheckType but validates calls against
+	// ctx.Funcs
+	CheckTypeContext(ctx *Context, types TypeEnv) (Type, error)
+
+	// EvalValue returns the typed value of this Expr in the environment
+	// env, calling functions from DefaultFuncs; it panics if the types
+	// mismatch, which CheckType reports, or Int arithmetic overflows,
+	// which EvalTyped returns as an error
+	EvalValue(env ValueEnv) Value
+
+	// EvalValueContext is like EvalValue but calls functions from
+	// ctx.Funcs; the values of variables are still those of env
+	EvalValueContext(ctx *Context, env ValueEnv) Value
+
+	// String formats this Expr as source that parses back to it, but
+	// for an infinite or NaN literal, which Parse, Derive and Simplify
+	// never make, formatted as a division of the same value
+	String() string
 }
 
 // Env is an environment that maps variable name to values
 type Env map[Var]float64
 
-// 5 concrete types that represent particular kinds of expression: Var, literal, unary, binary, call
+// A Context is what an Expr is evaluated in: the values of its
+// variables and the functions it may call.
+type Context struct {
+	Env   Env
+	Funcs FuncTable // if nil, DefaultFuncs is used
+
+	// outer is the enclosing scope of a context created for the
+	// definitions of a block, or nil.
+	outer *Context
+}
+
+func (ctx *Context) funcs() FuncTable {
+	if ctx.Funcs == nil {
+		return DefaultFuncs
+	}
+	return ctx.Funcs
+}
+
+// lookup returns the value of v in the innermost scope that defines it.
+func (ctx *Context) lookup(v Var) float64 {
+	for ; ctx.outer != nil; ctx = ctx.outer {
+		if x, ok := ctx.Env[v]; ok {
+			return x
+		}
+	}
+	return ctx.Env[v]
+}
+
+// lookupFunc returns the function named name in the innermost scope
+// that defines it.
+func (ctx *Context) lookupFunc(name string) (Func, bool) {
+	for ; ctx.outer != nil; ctx = ctx.outer {
+		if f, ok := ctx.Funcs[name]; ok {
+			return f, true
+		}
+	}
+	f, ok := ctx.funcs()[name]
+	return f, ok
+}
+
+// A CheckError is an error reported by Check, with the subexpression
+// at fault; Position finds it in the source.
+type CheckError struct {
+	Expr Expr
+	Msg  string
+}
+
+func (e *CheckError) Error() string { return e.Msg }
+
+func checkErrorf(e Expr, format string, args ...interface{}) *CheckError {
+	return &CheckError{e, fmt.Sprintf(format, args...)}
+}
+
+// concrete types that represent particular kinds of expression: Var, literal, unary, binary, call,
+// plus compare, logical and conditional for piecewise expressions, and block for definitions (see def.go)
 
 // We'll also need each kind of expresion to define an Eval method that returns the expression's value in a given environment.
 // (Since every expression must provide Eval, we add it to the Expr interface)
+// Eval and Check are shorthands for EvalContext and CheckContext with the default function table.
+
+// A pos is where Parse found a node: its byte offset in the input plus
+// 1, so that the zero pos of a node built otherwise means none. Nodes
+// rewritten from parsed ones keep their pos, so that errors found in
+// them point into the source.
+type pos int
 
 // A Var represents a reference to a variable
 type Var string
 
 // Eval is an environment that maps variable name to values
-func (v Var) Eval(env Env) float64 {
-	return env[v]
-}
-func (v Var) Check(vars map[Var]bool) error {
+func (v Var) Eval(env Env) float64             { return v.EvalContext(&Context{Env: env}) }
+func (v Var) EvalContext(ctx *Context) float64 { return ctx.lookup(v) }
+func (v Var) Check(vars map[Var]bool) error    { return v.CheckContext(&Context{}, vars) }
+func (v Var) CheckContext(_ *Context, vars map[Var]bool) error {
 	vars[v] = true
 	return nil
 }
@@ -39,95 +136,208 @@ func (v Var) Check(vars map[Var]bool) error {
 // A literal is a floating-point constant
 type literal float64
 
-func (l literal) Eval(_ Env) float64 {
-	return float64(l)
-}
-func (literal) Check(vars map[Var]bool) error {
+func (l literal) Eval(_ Env) float64             { return float64(l) }
+func (l literal) EvalContext(_ *Context) float64 { return float64(l) }
+func (literal) Check(vars map[Var]bool) error    { return nil }
+func (literal) CheckContext(_ *Context, vars map[Var]bool) error {
 	return nil
 }
 
 // A unary represents a unary operator expression, e.g., -x
 type unary struct {
-	op rune // one of '+', '-'
+	op rune // one of '+', '-', '!'
 	x  Expr
+	at pos
 }
 
-func (u unary) Eval(env Env) float64 {
+func (u unary) Eval(env Env) float64 { return u.EvalContext(&Context{Env: env}) }
+func (u unary) EvalContext(ctx *Context) float64 {
 	switch u.op {
 	case '+':
-		return +u.x.Eval(env)
+		return +u.x.EvalContext(ctx)
 	case '-':
-		return -u.x.Eval(env)
+		return -u.x.EvalContext(ctx)
+	case '!':
+		return boolToFloat(u.x.EvalContext(ctx) == 0)
 	}
 	panic(fmt.Sprintf("unsupported unary operator: %q", u.op))
 }
-func (u unary) Check(vars map[Var]bool) error {
-	if !strings.ContainsRune("+-", u.op) {
-		return fmt.Errorf("unexpected unary op %q", u.op)
+func (u unary) Check(vars map[Var]bool) error { return u.CheckContext(&Context{}, vars) }
+func (u unary) CheckContext(ctx *Context, vars map[Var]bool) error {
+	if !strings.ContainsRune("+-!", u.op) {
+		return checkErrorf(u, "unexpected unary op %q", u.op)
 	}
-	return u.x.Check(vars)
+	return u.x.CheckContext(ctx, vars)
 }
 
 // A binary represents a binary operator expression, e.g., x+y
 type binary struct {
 	op   rune // one of '+', '-', '*', '/'
 	x, y Expr
+	at   pos
 }
 
-func (b binary) Eval(env Env) float64 {
+func (b binary) Eval(env Env) float64 { return b.EvalContext(&Context{Env: env}) }
+func (b binary) EvalContext(ctx *Context) float64 {
 	switch b.op {
 	case '+':
-		return b.x.Eval(env) + b.y.Eval(env)
+		return b.x.EvalContext(ctx) + b.y.EvalContext(ctx)
 	case '-':
-		return b.x.Eval(env) - b.y.Eval(env)
+		return b.x.EvalContext(ctx) - b.y.EvalContext(ctx)
 	case '*':
-		return b.x.Eval(env) * b.y.Eval(env)
+		return b.x.EvalContext(ctx) * b.y.EvalContext(ctx)
 	case '/':
-		return b.x.Eval(env) / b.y.Eval(env)
+		return b.x.EvalContext(ctx) / b.y.EvalContext(ctx)
 	}
 	panic(fmt.Sprintf("unsupported unary operator: %q", b.op))
 }
-func (b binary) Check(vars map[Var]bool) error {
+func (b binary) Check(vars map[Var]bool) error { return b.CheckContext(&Context{}, vars) }
+func (b binary) CheckContext(ctx *Context, vars map[Var]bool) error {
 	if !strings.ContainsRune("+-*/", b.op) {
-		return fmt.Errorf("unexpected binary op %q", b.op)
+		return checkErrorf(b, "unexpected binary op %q", b.op)
+	}
+	if err := b.x.CheckContext(ctx, vars); err != nil {
+		return err
+	}
+	return b.y.CheckContext(ctx, vars)
+}
+
+// A compare represents a comparison, e.g., x<y. It yields 1 if the
+// comparison holds and 0 otherwise.
+type compare struct {
+	op   rune // one of '<', opLE, '>', opGE, opEQ, opNE
+	x, y Expr
+	at   pos
+}
+
+func (c compare) Eval(env Env) float64 { return c.EvalContext(&Context{Env: env}) }
+func (c compare) EvalContext(ctx *Context) float64 {
+	x, y := c.x.EvalContext(ctx), c.y.EvalContext(ctx)
+	switch c.op {
+	case '<':
+		return boolToFloat(x < y)
+	case opLE:
+		return boolToFloat(x <= y)
+	case '>':
+		return boolToFloat(x > y)
+	case opGE:
+		return boolToFloat(x >= y)
+	case opEQ:
+		return boolToFloat(x == y)
+	case opNE:
+		return boolToFloat(x != y)
 	}
-	if err := b.x.Check(vars); err != nil {
+	panic(fmt.Sprintf("unsupported comparison operator: %q", opString(c.op)))
+}
+func (c compare) Check(vars map[Var]bool) error { return c.CheckContext(&Context{}, vars) }
+func (c compare) CheckContext(ctx *Context, vars map[Var]bool) error {
+	switch c.op {
+	case '<', opLE, '>', opGE, opEQ, opNE:
+	default:
+		return checkErrorf(c, "unexpected comparison op %q", opString(c.op))
+	}
+	if err := c.x.CheckContext(ctx, vars); err != nil {
 		return err
 	}
-	return b.y.Check(vars)
+	return c.y.CheckContext(ctx, vars)
+}
+
+// A logical represents a short-circuit logical expression, e.g., x&&y.
+// Any nonzero operand counts as true; the result is 1 or 0.
+type logical struct {
+	op   rune // one of opAnd, opOr
+	x, y Expr
+	at   pos
+}
+
+func (l logical) Eval(env Env) float64 { return l.EvalContext(&Context{Env: env}) }
+func (l logical) EvalContext(ctx *Context) float64 {
+	switch l.op {
+	case opAnd:
+		return boolToFloat(l.x.EvalContext(ctx) != 0 && l.y.EvalContext(ctx) != 0)
+	case opOr:
+		return boolToFloat(l.x.EvalContext(ctx) != 0 || l.y.EvalContext(ctx) != 0)
+	}
+	panic(fmt.Sprintf("unsupported logical operator: %q", opString(l.op)))
+}
+func (l logical) Check(vars map[Var]bool) error { return l.CheckContext(&Context{}, vars) }
+func (l logical) CheckContext(ctx *Context, vars map[Var]bool) error {
+	if l.op != opAnd && l.op != opOr {
+		return checkErrorf(l, "unexpected logical op %q", opString(l.op))
+	}
+	if err := l.x.CheckContext(ctx, vars); err != nil {
+		return err
+	}
+	return l.y.CheckContext(ctx, vars)
+}
+
+// A conditional represents a conditional expression, e.g., x<0 ? -x : x
+// or, equivalently, if(x<0, -x, x). Only the chosen branch is evaluated.
+type conditional struct {
+	cond, t, f Expr
+	at         pos
+}
+
+func (c conditional) Eval(env Env) float64 { return c.EvalContext(&Context{Env: env}) }
+func (c conditional) EvalContext(ctx *Context) float64 {
+	if c.cond.EvalContext(ctx) != 0 {
+		return c.t.EvalContext(ctx)
+	}
+	return c.f.EvalContext(ctx)
+}
+func (c conditional) Check(vars map[Var]bool) error { return c.CheckContext(&Context{}, vars) }
+func (c conditional) CheckContext(ctx *Context, vars map[Var]bool) error {
+	for _, e := range []Expr{c.cond, c.t, c.f} {
+		if err := e.CheckContext(ctx, vars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
 }
 
 // A call represents a function call expression, e.g., sin(x)
 type call struct {
-	fn   string // one of "pow", "sin", "sqrt"
+	fn   string // name of a function in the FuncTable
 	args []Expr
+	at   pos
 }
 
-func (c call) Eval(env Env) float64 {
-	switch c.fn {
-	case "pow":
-		return math.Pow(c.args[0].Eval(env), c.args[1].Eval(env))
-	case "sin":
-		return math.Sin(c.args[0].Eval(env))
-	case "sqrt":
-		return math.Sqrt(c.args[0].Eval(env))
+func (c call) Eval(env Env) float64 { return c.EvalContext(&Context{Env: env}) }
+func (c call) EvalContext(ctx *Context) float64 {
+	f, ok := ctx.lookupFunc(c.fn)
+	if !ok {
+		panic(fmt.Sprintf("unsupported function: %q", c.fn))
+	}
+	args := make([]float64, len(c.args))
+	for i, arg := range c.args {
+		args[i] = arg.EvalContext(ctx)
 	}
-	panic(fmt.Sprintf("unsupported unary operator: %q", c.fn))
+	return f.Impl(args)
 }
-
-var numParams = map[string]int{"pow": 2, "sin": 1, "sqrt": 1}
-
-func (c call) Check(vars map[Var]bool) error {
-	arity, ok := numParams[c.fn]
+func (c call) Check(vars map[Var]bool) error { return c.CheckContext(&Context{}, vars) }
+func (c call) CheckContext(ctx *Context, vars map[Var]bool) error {
+	f, ok := ctx.lookupFunc(c.fn)
 	if !ok {
-		return fmt.Errorf("unknown function %q", c.fn)
+		return checkErrorf(c, "unknown function %q", c.fn)
 	}
-	if len(c.args) != arity {
-		return fmt.Errorf("call to %s has %d args, want %d", c.fn, len(c.args), arity)
+	if err := f.checkArity(c.fn, len(c.args)); err != nil {
+		return &CheckError{c, err.Error()}
 	}
 	for _, arg := range c.args {
-		if err := arg.Check(vars); err != nil {
-			return nil
+		if err := arg.CheckContext(ctx, vars); err != nil {
+			return err
+		}
+	}
+	if f.CheckArgs != nil {
+		if err := f.CheckArgs(c.args); err != nil {
+			return &CheckError{c, fmt.Sprintf("call to %s: %v", c.fn, err)}
 		}
 	}
 	return nil
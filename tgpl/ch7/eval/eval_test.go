@@ -18,6 +18,18 @@ func TestEval(t *testing.T) {
 		{"5 / 9 * (F - 32)", Env{"F": -40}, "-40"},
 		{"5 / 9 * (F - 32)", Env{"F": 32}, "0"},
 		{"5 / 9 * (F - 32)", Env{"F": 212}, "100"},
+		{"x < 0 ? -x : x", Env{"x": -3}, "3"},
+		{"x < 0 ? -x : x", Env{"x": 3}, "3"},
+		{"if(r == 0, 1, sin(r) / r)", Env{"r": 0}, "1"},
+		{"if(r == 0, 1, sin(r) / r)", Env{"r": math.Pi / 2}, "0.63662"},
+		{"x < -1 ? -1 : x > 1 ? 1 : x", Env{"x": -5}, "-1"},
+		{"x < -1 ? -1 : x > 1 ? 1 : x", Env{"x": 0.5}, "0.5"},
+		{"x < -1 ? -1 : x > 1 ? 1 : x", Env{"x": 5}, "1"},
+		{"x >= 1 && x <= 2 || x != x", Env{"x": 1}, "1"},
+		{"x >= 1 && x <= 2 || x != x", Env{"x": 3}, "0"},
+		{"1 + 2 == 3 && !(y > 1)", Env{"y": 0}, "1"},
+		{"!x + 1", Env{"x": 2}, "1"},
+		{"x != 0 && 1 / x < 1", Env{"x": 0}, "0"},
 	}
 	var prevExpr string
 	for _, test := range tests {
@@ -42,6 +54,80 @@ func TestEval(t *testing.T) {
 	}
 }
 
+func TestFuncTable(t *testing.T) {
+	funcs := FuncTable{
+		"sq": Func1(func(x float64) float64 { return x * x }),
+		"sum": {Arity: 0, Variadic: true, Impl: func(args []float64) float64 {
+			var s float64
+			for _, a := range args {
+				s += a
+			}
+			return s
+		}},
+	}
+	tests := []struct {
+		expr string
+		env  Env
+		want string // expected error from Check or result from Eval
+	}{
+		{"sq(x) + sum()", Env{"x": 3}, "9"},
+		{"sum(x, sq(2), 1)", Env{"x": 3}, "8"},
+		{"sq(x, 2)", nil, "call to sq has 2 args, want 1"},
+		{"sin(x)", nil, `unknown function "sin"`},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Error(err) // parse error
+			continue
+		}
+		ctx := &Context{Env: test.env, Funcs: funcs}
+		if err := expr.CheckContext(ctx, map[Var]bool{}); err != nil {
+			if err.Error() != test.want {
+				t.Errorf("%s: got %q, want %q", test.expr, err, test.want)
+			}
+			continue
+		}
+		got := fmt.Sprintf("%.6g", expr.EvalContext(ctx))
+		if got != test.want {
+			t.Errorf("%s.EvalContext() in %v = %q, want %q\n", test.expr, test.env, got, test.want)
+		}
+	}
+}
+
+func TestBesselOrder(t *testing.T) {
+	tests := []struct {
+		expr string
+		env  Env
+		want string // expected error from Check or result from Eval
+	}{
+		{"jn(2, x)", Env{"x": 1}, "0.114903"},
+		{"yn(-1000, x)", Env{"x": 1}, "-Inf"},
+		{"jn(1e15, x)", nil, "call to jn: order 1e+15 is not an integer from -1000 to 1000"},
+		{"yn(-(500 * 3), x)", nil, "call to yn: order -1500 is not an integer from -1000 to 1000"},
+		{"jn(1.5, x)", nil, "call to jn: order 1.5 is not an integer from -1000 to 1000"},
+		{"jn(n, x)", Env{"n": 1e9, "x": 1}, "NaN"}, // at once, rather than in seconds
+		{"jn(n, x)", Env{"n": 2.5, "x": 1}, "NaN"},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Error(err) // parse error
+			continue
+		}
+		if err := expr.Check(map[Var]bool{}); err != nil {
+			if err.Error() != test.want {
+				t.Errorf("%s: got %q, want %q", test.expr, err, test.want)
+			}
+			continue
+		}
+		got := fmt.Sprintf("%.6g", expr.Eval(test.env))
+		if got != test.want {
+			t.Errorf("%s.Eval() in %v = %q, want %q\n", test.expr, test.env, got, test.want)
+		}
+	}
+}
+
 // go test -v digest_gopl/ch7/eval
 
 /*
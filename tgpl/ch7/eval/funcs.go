@@ -0,0 +1,255 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// A Func describes a function that an expression may call.
+type Func struct {
+	Arity    int  // number of parameters; the minimum number if Variadic
+	Variadic bool // whether calls may pass more than Arity arguments
+	Impl     func(args []float64) float64
+
+	// Partial, if non-nil, returns the partial derivative of the
+	// function with respect to args[i], for use by Derive.
+	Partial func(args []Expr, i int) Expr
+
+	// Interval, if non-nil, returns bounds on the function's value for
+	// arguments in args, for use by EvalInterval. A function without
+	// one may have any value.
+	Interval func(args []Interval) Interval
+
+	// Complex, if non-nil, implements the function for complex
+	// arguments, for use by EvalValue when any argument is Complex.
+	// If RealResult is set, the result is the Float real part of its
+	// value, as for abs.
+	// If ComplexResult is set, the result is Complex even for real
+	// arguments, as for complex.
+	Complex       func(args []complex128) complex128
+	RealResult    bool
+	ComplexResult bool
+
+	// CheckArgs, if non-nil, reports errors in the arguments of a call
+	// that Check finds, such as constants out of range.
+	CheckArgs func(args []Expr) error
+}
+
+func (f Func) checkArity(name string, n int) error {
+	if f.Variadic {
+		if n < f.Arity {
+			return fmt.Errorf("call to %s has %d args, want at least %d", name, n, f.Arity)
+		}
+		return nil
+	}
+	if n != f.Arity {
+		return fmt.Errorf("call to %s has %d args, want %d", name, n, f.Arity)
+	}
+	return nil
+}
+
+// A FuncTable maps function names to the functions they call.
+type FuncTable map[string]Func
+
+// Func1 returns a Func of one parameter.
+func Func1(f func(float64) float64) Func {
+	return Func{Arity: 1, Impl: func(args []float64) float64 { return f(args[0]) }}
+}
+
+// Func2 returns a Func of two parameters.
+func Func2(f func(float64, float64) float64) Func {
+	return Func{Arity: 2, Impl: func(args []float64) float64 { return f(args[0], args[1]) }}
+}
+
+// fold returns a variadic Func named name that combines its arguments
+// from left to right with f, which must pick one of its operands.
+// The partial derivative with respect to an argument is 1 where
+// that argument is the first of those equal to the one picked and 0
+// elsewhere, so that ties count once.
+func fold(name string, f func(float64, float64) float64) Func {
+	return Func{
+		Arity:    1,
+		Variadic: true,
+		Impl: func(args []float64) float64 {
+			v := args[0]
+			for _, a := range args[1:] {
+				v = f(v, a)
+			}
+			return v
+		},
+		Partial: func(args []Expr, i int) Expr {
+			picked := call{name, args, 0}
+			var d Expr = compare{opEQ, args[i], picked, 0}
+			for j := i - 1; j >= 0; j-- {
+				d = logical{opAnd, compare{opNE, args[j], picked, 0}, d, 0}
+			}
+			return d
+		},
+	}
+}
+
+// complex1 returns f with a Complex implemented by g.
+func complex1(f Func, g func(complex128) complex128) Func {
+	f.Complex = func(args []complex128) complex128 { return g(args[0]) }
+	return f
+}
+
+// complex2 returns f with a Complex implemented by g.
+func complex2(f Func, g func(complex128, complex128) complex128) Func {
+	f.Complex = func(args []complex128) complex128 { return g(args[0], args[1]) }
+	return f
+}
+
+// real1 returns f with a Complex implemented by g, which has a real result.
+func real1(f Func, g func(complex128) float64) Func {
+	f.Complex = func(args []complex128) complex128 { return complex(g(args[0]), 0) }
+	f.RealResult = true
+	return f
+}
+
+// complexFunc returns complex(re, im), whose value for real arguments
+// is Complex in typed evaluation and its real part elsewhere.
+func complexFunc() Func {
+	f := complex2(deriv(Func2(func(re, _ float64) float64 { return re }), "1", "0"),
+		func(re, im complex128) complex128 { return re + im*1i })
+	f.ComplexResult = true
+	return f
+}
+
+// real, imag, conj and phase of real and complex numbers
+func identity(x float64) float64    { return x }
+func zero(float64) float64          { return 0 }
+func phase(x float64) float64       { return math.Atan2(0, x) }
+func realPart(z complex128) float64 { return real(z) }
+func imagPart(z complex128) float64 { return imag(z) }
+
+// maxOrder bounds the order of Bessel functions, whose time grows
+// with it.
+const maxOrder = 1000
+
+// order returns n as the order of a Bessel function, reporting whether
+// it is an integer within maxOrder.
+func order(n float64) (int, bool) {
+	if n != math.Trunc(n) || math.Abs(n) > maxOrder {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// bessel returns a Func of the order n and x calling f, whose value is
+// NaN for orders other than integers within maxOrder, and which Check
+// rejects for such a constant order.
+func bessel(f func(int, float64) float64) Func {
+	return Func{
+		Arity: 2,
+		Impl: func(args []float64) float64 {
+			n, ok := order(args[0])
+			if !ok {
+				return math.NaN()
+			}
+			return f(n, args[1])
+		},
+		CheckArgs: func(args []Expr) error {
+			vars := make(map[Var]bool)
+			if args[0].Check(vars) != nil || len(vars) > 0 {
+				return nil // known only in evaluation
+			}
+			if n := args[0].Eval(nil); n == n { // NaN is checked in evaluation
+				if _, ok := order(n); !ok {
+					return fmt.Errorf("order %g is not an integer from %d to %d", n, -maxOrder, maxOrder)
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// deriv returns f with a Partial given by formulas, one per parameter,
+// written in terms of the parameters x and y.
+func deriv(f Func, formulas ...string) Func {
+	partials := make([]Expr, len(formulas))
+	for i, s := range formulas {
+		e, err := Parse(s)
+		if err != nil {
+			panic(fmt.Sprintf("eval: bad derivative %q: %v", s, err))
+		}
+		partials[i] = e
+	}
+	f.Partial = func(args []Expr, i int) Expr {
+		params := map[Var]Expr{"x": args[0]}
+		if len(args) > 1 {
+			params["y"] = args[1]
+		}
+		return Subst(partials[i], params)
+	}
+	return f
+}
+
+// DefaultFuncs holds the float64 functions of the math package, under
+// their lower-case names. Functions taking an int use its truncation,
+// but jn and yn take integer orders up to 1000 in magnitude only.
+// All but gamma know their derivatives; the constants in the
+// derivative formulas are 2/√π, √π/2, ln 2 and ln 10. The elementary
+// functions, min, max and hypot also bound their values over intervals,
+// and the elementary functions and pow accept complex arguments in
+// typed evaluation. real, imag, conj and phase are the functions of Go
+// and the math/cmplx package, for real arguments as well, and complex
+// makes a complex number of real ones.
+var DefaultFuncs = FuncTable{
+	"abs":         real1(even(deriv(Func1(math.Abs), "copysign(1, x)")), cmplx.Abs),
+	"acos":        complex1(decreasing(deriv(Func1(math.Acos), "-1 / sqrt(1 - x*x)"), -1, 1), cmplx.Acos),
+	"acosh":       complex1(increasing(deriv(Func1(math.Acosh), "1 / sqrt(x*x - 1)"), 1, math.Inf(+1)), cmplx.Acosh),
+	"asin":        complex1(increasing(deriv(Func1(math.Asin), "1 / sqrt(1 - x*x)"), -1, 1), cmplx.Asin),
+	"asinh":       complex1(increasing(deriv(Func1(math.Asinh), "1 / sqrt(x*x + 1)"), math.Inf(-1), math.Inf(+1)), cmplx.Asinh),
+	"atan":        complex1(increasing(deriv(Func1(math.Atan), "1 / (1 + x*x)"), math.Inf(-1), math.Inf(+1)), cmplx.Atan),
+	"atan2":       withInterval(deriv(Func2(math.Atan2), "y / (x*x + y*y)", "-x / (x*x + y*y)"), func([]Interval) Interval { return Interval{-math.Pi, math.Pi, false}.outward() }),
+	"atanh":       complex1(increasing(deriv(Func1(math.Atanh), "1 / (1 - x*x)"), -1, 1), cmplx.Atanh),
+	"cbrt":        increasing(deriv(Func1(math.Cbrt), "1 / (3 * cbrt(x) * cbrt(x))"), math.Inf(-1), math.Inf(+1)),
+	"ceil":        increasing(deriv(Func1(math.Ceil), "0"), math.Inf(-1), math.Inf(+1)),
+	"complex":     complexFunc(),
+	"conj":        increasing(complex1(deriv(Func1(identity), "1"), cmplx.Conj), math.Inf(-1), math.Inf(+1)),
+	"copysign":    deriv(Func2(math.Copysign), "copysign(1, x) * copysign(1, y)", "0"),
+	"cos":         complex1(withInterval(deriv(Func1(math.Cos), "-sin(x)"), func(args []Interval) Interval { return sinInterval(args[0], math.Pi/2) }), cmplx.Cos),
+	"cosh":        complex1(even(deriv(Func1(math.Cosh), "sinh(x)")), cmplx.Cosh),
+	"dim":         deriv(Func2(math.Dim), "x > y", "-(x > y)"),
+	"erf":         increasing(deriv(Func1(math.Erf), "1.1283791670955126 * exp(-x*x)"), math.Inf(-1), math.Inf(+1)),
+	"erfc":        decreasing(deriv(Func1(math.Erfc), "-1.1283791670955126 * exp(-x*x)"), math.Inf(-1), math.Inf(+1)),
+	"erfcinv":     decreasing(deriv(Func1(math.Erfcinv), "-0.886226925452758 * exp(erfcinv(x) * erfcinv(x))"), 0, 2),
+	"erfinv":      increasing(deriv(Func1(math.Erfinv), "0.886226925452758 * exp(erfinv(x) * erfinv(x))"), -1, 1),
+	"exp":         complex1(increasing(deriv(Func1(math.Exp), "exp(x)"), math.Inf(-1), math.Inf(+1)), cmplx.Exp),
+	"exp2":        increasing(deriv(Func1(math.Exp2), "0.6931471805599453 * exp2(x)"), math.Inf(-1), math.Inf(+1)),
+	"expm1":       increasing(deriv(Func1(math.Expm1), "exp(x)"), math.Inf(-1), math.Inf(+1)),
+	"floor":       increasing(deriv(Func1(math.Floor), "0"), math.Inf(-1), math.Inf(+1)),
+	"gamma":       Func1(math.Gamma),
+	"hypot":       withInterval(deriv(Func2(math.Hypot), "x / hypot(x, y)", "y / hypot(x, y)"), hypotInterval),
+	"imag":        increasing(real1(deriv(Func1(zero), "0"), imagPart), math.Inf(-1), math.Inf(+1)),
+	"j0":          deriv(Func1(math.J0), "-j1(x)"),
+	"j1":          deriv(Func1(math.J1), "j0(x) - j1(x) / x"),
+	"jn":          deriv(bessel(math.Jn), "0", "(jn(x - 1, y) - jn(x + 1, y)) / 2"),
+	"ldexp":       deriv(Func2(func(frac, exp float64) float64 { return math.Ldexp(frac, int(exp)) }), "ldexp(1, y)", "0"),
+	"log":         complex1(increasing(deriv(Func1(math.Log), "1 / x"), 0, math.Inf(+1)), cmplx.Log),
+	"log10":       complex1(increasing(deriv(Func1(math.Log10), "1 / (x * 2.302585092994046)"), 0, math.Inf(+1)), cmplx.Log10),
+	"log1p":       increasing(deriv(Func1(math.Log1p), "1 / (1 + x)"), -1, math.Inf(+1)),
+	"log2":        increasing(deriv(Func1(math.Log2), "1 / (x * 0.6931471805599453)"), 0, math.Inf(+1)),
+	"logb":        deriv(Func1(math.Logb), "0"),
+	"max":         withInterval(fold("max", math.Max), foldInterval(math.Max)),
+	"min":         withInterval(fold("min", math.Min), foldInterval(math.Min)),
+	"mod":         deriv(Func2(math.Mod), "1", "-trunc(x / y)"),
+	"nextafter":   deriv(Func2(math.Nextafter), "1", "0"),
+	"phase":       decreasing(real1(deriv(Func1(phase), "0"), cmplx.Phase), math.Inf(-1), math.Inf(+1)),
+	"pow":         withInterval(complex2(deriv(Func2(math.Pow), "y * pow(x, y - 1)", "pow(x, y) * log(x)"), cmplx.Pow), powInterval),
+	"real":        increasing(real1(deriv(Func1(identity), "1"), realPart), math.Inf(-1), math.Inf(+1)),
+	"remainder":   deriv(Func2(math.Remainder), "1", "-roundtoeven(x / y)"),
+	"round":       increasing(deriv(Func1(math.Round), "0"), math.Inf(-1), math.Inf(+1)),
+	"roundtoeven": increasing(deriv(Func1(math.RoundToEven), "0"), math.Inf(-1), math.Inf(+1)),
+	"sin":         complex1(withInterval(deriv(Func1(math.Sin), "cos(x)"), func(args []Interval) Interval { return sinInterval(args[0], 0) }), cmplx.Sin),
+	"sinh":        complex1(increasing(deriv(Func1(math.Sinh), "cosh(x)"), math.Inf(-1), math.Inf(+1)), cmplx.Sinh),
+	"sqrt":        complex1(increasing(deriv(Func1(math.Sqrt), "0.5 / sqrt(x)"), 0, math.Inf(+1)), cmplx.Sqrt),
+	"tan":         complex1(withInterval(deriv(Func1(math.Tan), "1 + tan(x) * tan(x)"), tanInterval), cmplx.Tan),
+	"tanh":        complex1(increasing(deriv(Func1(math.Tanh), "1 - tanh(x) * tanh(x)"), math.Inf(-1), math.Inf(+1)), cmplx.Tanh),
+	"trunc":       increasing(deriv(Func1(math.Trunc), "0"), math.Inf(-1), math.Inf(+1)),
+	"y0":          deriv(Func1(math.Y0), "-y1(x)"),
+	"y1":          deriv(Func1(math.Y1), "y0(x) - y1(x) / x"),
+	"yn":          deriv(bessel(math.Yn), "0", "(yn(x - 1, y) - yn(x + 1, y)) / 2"),
+}
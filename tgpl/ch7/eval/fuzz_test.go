@@ -0,0 +1,374 @@
+package eval
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// randomExpr returns a random expression of the shape Parse produces,
+// nested at most depth deep. It need not pass Check: a call may name
+// an unknown function or pass the wrong number of arguments, and the
+// functions of a block may be recursive or use its lets.
+func randomExpr(rng *rand.Rand, depth int) Expr {
+	pick := func(names ...string) string { return names[rng.Intn(len(names))] }
+	if depth <= 0 || rng.Intn(4) == 0 {
+		switch rng.Intn(3) {
+		case 0:
+			return Var(pick("x", "y", "r", "pi", "a", "t"))
+		case 1:
+			return literal([]float64{0, 1, 2.5, 1e-7, 1e21, 123456789}[rng.Intn(6)])
+		default:
+			return literal(math.Abs(rng.NormFloat64()) * math.Pow(10, float64(rng.Intn(20)-10)))
+		}
+	}
+	sub := func() Expr { return randomExpr(rng, depth-1) }
+	op := func(ops ...rune) rune { return ops[rng.Intn(len(ops))] }
+	switch rng.Intn(7) {
+	case 6:
+		var defs []def
+		for n := rng.Intn(3); n >= 0; n-- {
+			if rng.Intn(2) == 0 {
+				defs = append(defs, def{name: "a", body: sub()})
+				continue
+			}
+			d := def{name: "g", fn: true, body: sub()}
+			for n := rng.Intn(3); n > 0; n-- {
+				d.params = append(d.params, Var(pick("s", "t")))
+			}
+			defs = append(defs, d)
+		}
+		return block{defs, sub(), 0}
+	case 0:
+		return unary{op('+', '-', '!'), sub(), 0}
+	case 1:
+		return binary{op('+', '-', '*', '/'), sub(), sub(), 0}
+	case 2:
+		return compare{op('<', opLE, '>', opGE, opEQ, opNE), sub(), sub(), 0}
+	case 3:
+		return logical{op(opAnd, opOr), sub(), sub(), 0}
+	case 4:
+		return conditional{sub(), sub(), sub(), 0}
+	}
+	fn := pick("sin", "sqrt", "pow", "hypot", "max", "f", "g")
+	n := rng.Intn(4)
+	if f, ok := DefaultFuncs[fn]; ok && rng.Intn(4) != 0 {
+		n = f.Arity // mostly right, so that more expressions pass Check
+	}
+	var args []Expr // nil if there are none, as from Parse
+	for ; n > 0; n-- {
+		args = append(args, sub())
+	}
+	return call{fn, args, 0}
+}
+
+// ---- reference evaluator ----
+
+// refEval evaluates e in env independently of the Eval methods, for
+// differential testing: it resolves names through its own scopes and
+// calls the math package directly.
+func refEval(e Expr, env Env) float64 {
+	return (&refScope{vars: env}).eval(e)
+}
+
+type refScope struct {
+	vars  map[Var]float64
+	funcs map[string]refFunc
+	outer *refScope
+}
+
+type refFunc struct {
+	def   def
+	scope *refScope // where the function was defined
+}
+
+var refFuncs = map[string]func(args []float64) float64{
+	"sin":   func(args []float64) float64 { return math.Sin(args[0]) },
+	"cos":   func(args []float64) float64 { return math.Cos(args[0]) },
+	"sqrt":  func(args []float64) float64 { return math.Sqrt(args[0]) },
+	"exp":   func(args []float64) float64 { return math.Exp(args[0]) },
+	"log":   func(args []float64) float64 { return math.Log(args[0]) },
+	"abs":   func(args []float64) float64 { return math.Abs(args[0]) },
+	"pow":   func(args []float64) float64 { return math.Pow(args[0], args[1]) },
+	"hypot": func(args []float64) float64 { return math.Hypot(args[0], args[1]) },
+	"max": func(args []float64) float64 {
+		m := args[0]
+		for _, a := range args[1:] {
+			m = math.Max(m, a)
+		}
+		return m
+	},
+	"min": func(args []float64) float64 {
+		m := args[0]
+		for _, a := range args[1:] {
+			m = math.Min(m, a)
+		}
+		return m
+	},
+}
+
+func (s *refScope) eval(e Expr) float64 {
+	truth := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	switch e := e.(type) {
+	case Var:
+		for sc := s; sc != nil; sc = sc.outer {
+			if x, ok := sc.vars[e]; ok {
+				return x
+			}
+		}
+		return 0
+	case literal:
+		return float64(e)
+	case unary:
+		switch x := s.eval(e.x); e.op {
+		case '+':
+			return x
+		case '-':
+			return -x
+		case '!':
+			return truth(x == 0)
+		}
+	case binary:
+		switch x, y := s.eval(e.x), s.eval(e.y); e.op {
+		case '+':
+			return x + y
+		case '-':
+			return x - y
+		case '*':
+			return x * y
+		case '/':
+			return x / y
+		}
+	case compare:
+		switch x, y := s.eval(e.x), s.eval(e.y); e.op {
+		case '<':
+			return truth(x < y)
+		case opLE:
+			return truth(x <= y)
+		case '>':
+			return truth(x > y)
+		case opGE:
+			return truth(x >= y)
+		case opEQ:
+			return truth(x == y)
+		case opNE:
+			return truth(x != y)
+		}
+	case logical:
+		x := s.eval(e.x) != 0
+		if e.op == opAnd {
+			return truth(x && s.eval(e.y) != 0)
+		}
+		return truth(x || s.eval(e.y) != 0)
+	case conditional:
+		if s.eval(e.cond) != 0 {
+			return s.eval(e.t)
+		}
+		return s.eval(e.f)
+	case call:
+		args := make([]float64, len(e.args))
+		for i, arg := range e.args {
+			args[i] = s.eval(arg)
+		}
+		for sc := s; sc != nil; sc = sc.outer {
+			if f, ok := sc.funcs[e.fn]; ok {
+				params := make(map[Var]float64)
+				for i, p := range f.def.params {
+					params[p] = args[i]
+				}
+				return (&refScope{vars: params, outer: f.scope}).eval(f.def.body)
+			}
+		}
+		if f, ok := refFuncs[e.fn]; ok {
+			return f(args)
+		}
+		return DefaultFuncs[e.fn].Impl(args)
+	case block:
+		funcs := &refScope{funcs: make(map[string]refFunc), outer: s}
+		for _, d := range e.defs {
+			if d.fn {
+				funcs.funcs[d.name] = refFunc{d, funcs}
+			}
+		}
+		lets := &refScope{vars: make(map[Var]float64), outer: funcs}
+		for _, d := range e.defs {
+			if !d.fn {
+				lets.vars[Var(d.name)] = lets.eval(d.body)
+			}
+		}
+		return lets.eval(e.body)
+	}
+	panic("refEval: unexpected expression")
+}
+
+// ulps returns the number of float64 values from a to b, counting
+// NaN as equal to NaN and unequal to anything else.
+func ulps(a, b float64) uint64 {
+	switch {
+	case math.IsNaN(a) || math.IsNaN(b):
+		if math.IsNaN(a) && math.IsNaN(b) {
+			return 0
+		}
+		return math.MaxUint64
+	case a == b:
+		return 0 // including +0 and -0
+	}
+	// map the floats to integers in the same order
+	ordered := func(f float64) int64 {
+		i := int64(math.Float64bits(f))
+		if i < 0 {
+			i = math.MinInt64 - i
+		}
+		return i
+	}
+	d := ordered(a) - ordered(b)
+	if d < 0 {
+		d = -d
+	}
+	return uint64(d)
+}
+
+// maxULPs is the largest difference allowed between the ways of
+// evaluating an expression. They do the same float64 operations, but
+// the compiler may fuse a multiplication and an addition on some
+// architectures.
+const maxULPs = 4
+
+// checkExpr checks that e, as from Parse, either fails Check or
+// evaluates without panicking to the same value, within maxULPs, by
+// refEval, Eval, Program.Run, and Eval of its String parsed again.
+// Variables missing from env take arbitrary values.
+func checkExpr(t *testing.T, e Expr, env Env) (checked bool) {
+	t.Helper()
+	vars := make(map[Var]bool)
+	if err := e.Check(vars); err != nil {
+		return false
+	}
+	var names []Var
+	full := make(Env)
+	for v := range vars {
+		names = append(names, v)
+		x, ok := env[v]
+		if !ok {
+			x = float64(len(v)) + 0.25
+		}
+		full[v] = x
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	vals := make([]float64, len(names))
+	for i, v := range names {
+		vals[i] = full[v]
+	}
+
+	defer func() {
+		if x := recover(); x != nil {
+			t.Fatalf("%s: Check passed but evaluation panicked: %v", e, x)
+		}
+	}()
+	want := refEval(e, full)
+	got := map[string]float64{"Eval": e.Eval(full)}
+	prog, err := Compile(e, names)
+	if err != nil {
+		t.Fatalf("%s: Check passed but Compile failed: %v", e, err)
+	}
+	got["Run"] = prog.Run(vals)
+	reparsed, err := Parse(e.String())
+	if err != nil {
+		t.Fatalf("Parse(%q), of the String of a parsed expression: %v", e, err)
+	}
+	if !reflect.DeepEqual(unplaced(reparsed), unplaced(e)) {
+		t.Fatalf("Parse(%q) = %#v, want %#v", e, unplaced(reparsed), unplaced(e))
+	}
+	got["Eval of String"] = reparsed.Eval(full)
+	for how, x := range got {
+		if ulps(x, want) > maxULPs {
+			t.Errorf("%s with %v: %s = %g, want %g", e, full, how, x, want)
+		}
+	}
+	return true
+}
+
+// TestCheckBeforeEval checks that Check rejects expressions that Eval
+// would panic on, such as bad calls nested in the arguments of a good
+// one.
+func TestCheckBeforeEval(t *testing.T) {
+	for _, input := range []string{
+		"sin(pow(x))",
+		"pow(x, sin())",
+		"max(1, hypot(x, y, r))",
+		"abs(frob(x))",
+		"sqrt(x) + sin(x, y)",
+		"f(t) = t; sin(f(1, 2))",
+		"f(t) = sin(t, t); f(x)",
+		"let a = pow(x); a",
+		"x < 0 ? pow(x) : x",
+		"f(t) = g(t); g(t) = f(t); f(1)",
+	} {
+		expr, err := Parse(input)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", input, err)
+			continue
+		}
+		if err := expr.Check(map[Var]bool{}); err == nil {
+			t.Errorf("Check(%q) = nil, want error", input)
+		}
+	}
+}
+
+func TestDifferential(t *testing.T) {
+	seed := int64(1)
+	t.Logf("Random seed: %d", seed)
+	rng := rand.New(rand.NewSource(seed))
+	checked := 0
+	for i := 0; i < 5000; i++ {
+		env := make(Env)
+		for _, v := range []Var{"x", "y", "r", "pi", "a", "s", "t"} {
+			env[v] = rng.NormFloat64() * math.Pow(10, float64(rng.Intn(7)-3))
+		}
+		if checkExpr(t, randomExpr(rng, 5), env) {
+			checked++
+		}
+	}
+	if checked < 1000 {
+		t.Errorf("only %d of 5000 random expressions passed Check", checked)
+	}
+}
+
+// FuzzParse checks that Parse reports any malformed input with a
+// *SyntaxError instead of panicking, and that what it accepts obeys
+// checkExpr. Run it with go test -fuzz=FuzzParse.
+func FuzzParse(f *testing.F) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		f.Add(randomExpr(rng, 4).String())
+	}
+	for _, s := range []string{
+		"", "x +", "(((", "if(1, 2)", "1e999", "2 * 1e", "x ? y", "a <= = b",
+		"let a = 1; a", "f(t) = t; f(2)", "f(1) = 2; 3", "let = 1; 2", "f(t) = t",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		// a panic other than a lexPanic escapes Parse and fails the target
+		e, err := Parse(input)
+		if err != nil {
+			se, ok := err.(*SyntaxError)
+			if !ok {
+				t.Fatalf("Parse(%q) error = %v (%T), want *SyntaxError", input, err, err)
+			}
+			if se.Line < 1 || se.Column < 1 || se.Offset < 0 || se.Offset > len(input) {
+				t.Fatalf("Parse(%q) error at %d:%d offset %d, outside the input",
+					input, se.Line, se.Column, se.Offset)
+			}
+			return
+		}
+		checkExpr(t, e, nil)
+	})
+}
@@ -0,0 +1,366 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+)
+
+// ---- interval arithmetic ----
+
+// An Interval is a closed range of real numbers [Lo, Hi] that is
+// guaranteed to contain the value of an expression. Singular reports
+// that the expression may not be finite for some values of its
+// variables in their ranges, e.g., because it divides by an interval
+// containing zero or takes the square root of one reaching below zero;
+// Lo and Hi then bound only the finite values.
+type Interval struct {
+	Lo, Hi   float64
+	Singular bool
+}
+
+// IntervalEnv maps variable names to the ranges of their values.
+type IntervalEnv map[Var]Interval
+
+// Point returns the interval containing only x.
+func Point(x float64) Interval { return Interval{Lo: x, Hi: x} }
+
+// Entire is the interval of all real numbers.
+var Entire = Interval{Lo: math.Inf(-1), Hi: math.Inf(+1)}
+
+func (i Interval) String() string {
+	s := fmt.Sprintf("[%g, %g]", i.Lo, i.Hi)
+	if i.Singular {
+		s += " (singular)"
+	}
+	return s
+}
+
+// Contains reports whether x lies in i.
+func (i Interval) Contains(x float64) bool { return i.Lo <= x && x <= i.Hi }
+
+// Bounded reports whether both ends of i are finite.
+func (i Interval) Bounded() bool {
+	return !math.IsInf(i.Lo, 0) && !math.IsInf(i.Hi, 0)
+}
+
+// point reports whether i holds a single number.
+func (i Interval) point() bool { return i.Lo == i.Hi }
+
+// join returns the smallest interval containing both i and j.
+func (i Interval) join(j Interval) Interval {
+	return Interval{math.Min(i.Lo, j.Lo), math.Max(i.Hi, j.Hi), i.Singular || j.Singular}
+}
+
+// singular returns i marked as possibly not finite if s is true.
+func (i Interval) singular(s bool) Interval {
+	i.Singular = i.Singular || s
+	return i
+}
+
+// outward widens i by an ulp at each end to allow for rounding error
+// in computing its ends, and makes it Entire if an end is NaN.
+func (i Interval) outward() Interval {
+	if math.IsNaN(i.Lo) || math.IsNaN(i.Hi) {
+		return Entire.singular(true)
+	}
+	i.Lo = math.Nextafter(i.Lo, math.Inf(-1))
+	i.Hi = math.Nextafter(i.Hi, math.Inf(+1))
+	return i
+}
+
+// overflow returns r marked singular if it is unbounded although
+// the operands in args are bounded, as when log reaches 0 or exp
+// overflows.
+func overflow(r Interval, args ...Interval) Interval {
+	for _, a := range args {
+		if !a.Bounded() {
+			return r
+		}
+	}
+	return r.singular(!r.Bounded())
+}
+
+// mul returns the product of a and b, taking 0 × ∞ as 0 since the
+// ends of an interval are limits, not values.
+func mul(a, b float64) float64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return a * b
+}
+
+func addInterval(x, y Interval) Interval {
+	return overflow(Interval{x.Lo + y.Lo, x.Hi + y.Hi, x.Singular || y.Singular}.outward(), x, y)
+}
+
+func subInterval(x, y Interval) Interval {
+	return overflow(Interval{x.Lo - y.Hi, x.Hi - y.Lo, x.Singular || y.Singular}.outward(), x, y)
+}
+
+func mulInterval(x, y Interval) Interval {
+	p := [4]float64{mul(x.Lo, y.Lo), mul(x.Lo, y.Hi), mul(x.Hi, y.Lo), mul(x.Hi, y.Hi)}
+	r := Interval{p[0], p[0], x.Singular || y.Singular}
+	for _, v := range p[1:] {
+		r.Lo, r.Hi = math.Min(r.Lo, v), math.Max(r.Hi, v)
+	}
+	return overflow(r.outward(), x, y)
+}
+
+func divInterval(x, y Interval) Interval {
+	if y.Contains(0) {
+		return Entire.singular(true)
+	}
+	return mulInterval(x, Interval{1 / y.Hi, 1 / y.Lo, y.Singular})
+}
+
+// truth classifies the interval of a condition: it is certainly true
+// if it excludes 0, certainly false if it is exactly 0, else unknown.
+func (i Interval) truth() (isTrue, isFalse bool) {
+	return !i.Contains(0), i.Lo == 0 && i.Hi == 0
+}
+
+// boolInterval returns the interval of a condition known to be true,
+// known to be false, or neither.
+func boolInterval(isTrue, isFalse bool, singular bool) Interval {
+	switch {
+	case isTrue:
+		return Interval{1, 1, singular}
+	case isFalse:
+		return Interval{0, 0, singular}
+	}
+	return Interval{0, 1, singular}
+}
+
+func (v Var) EvalInterval(env IntervalEnv) Interval { return env[v] }
+
+func (l literal) EvalInterval(_ IntervalEnv) Interval { return Point(float64(l)) }
+
+func (u unary) EvalInterval(env IntervalEnv) Interval {
+	x := u.x.EvalInterval(env)
+	switch u.op {
+	case '+':
+		return x
+	case '-':
+		return Interval{-x.Hi, -x.Lo, x.Singular}
+	case '!':
+		isTrue, isFalse := x.truth()
+		return boolInterval(isFalse, isTrue, x.Singular)
+	}
+	panic(fmt.Sprintf("unsupported unary operator: %q", u.op))
+}
+
+func (b binary) EvalInterval(env IntervalEnv) Interval {
+	x, y := b.x.EvalInterval(env), b.y.EvalInterval(env)
+	switch b.op {
+	case '+':
+		return addInterval(x, y)
+	case '-':
+		return subInterval(x, y)
+	case '*':
+		return mulInterval(x, y)
+	case '/':
+		return divInterval(x, y)
+	}
+	panic(fmt.Sprintf("unsupported binary operator: %q", b.op))
+}
+
+func (c compare) EvalInterval(env IntervalEnv) Interval {
+	x, y := c.x.EvalInterval(env), c.y.EvalInterval(env)
+	s := x.Singular || y.Singular
+	switch c.op {
+	case '<':
+		return boolInterval(x.Hi < y.Lo, x.Lo >= y.Hi, s)
+	case opLE:
+		return boolInterval(x.Hi <= y.Lo, x.Lo > y.Hi, s)
+	case '>':
+		return boolInterval(x.Lo > y.Hi, x.Hi <= y.Lo, s)
+	case opGE:
+		return boolInterval(x.Lo >= y.Hi, x.Hi < y.Lo, s)
+	case opEQ:
+		return boolInterval(x.point() && y.point() && x.Lo == y.Lo, x.Hi < y.Lo || y.Hi < x.Lo, s)
+	case opNE:
+		return boolInterval(x.Hi < y.Lo || y.Hi < x.Lo, x.point() && y.point() && x.Lo == y.Lo, s)
+	}
+	panic(fmt.Sprintf("unsupported comparison operator: %q", opString(c.op)))
+}
+
+func (l logical) EvalInterval(env IntervalEnv) Interval {
+	x, y := l.x.EvalInterval(env), l.y.EvalInterval(env)
+	xTrue, xFalse := x.truth()
+	yTrue, yFalse := y.truth()
+	switch l.op {
+	case opAnd:
+		if xFalse {
+			return Interval{0, 0, x.Singular}
+		}
+		return boolInterval(xTrue && yTrue, yFalse, x.Singular || y.Singular)
+	case opOr:
+		if xTrue {
+			return Interval{1, 1, x.Singular}
+		}
+		return boolInterval(yTrue, xFalse && yFalse, x.Singular || y.Singular)
+	}
+	panic(fmt.Sprintf("unsupported logical operator: %q", opString(l.op)))
+}
+
+func (c conditional) EvalInterval(env IntervalEnv) Interval {
+	cond := c.cond.EvalInterval(env)
+	switch isTrue, isFalse := cond.truth(); {
+	case isTrue:
+		return c.t.EvalInterval(env).singular(cond.Singular)
+	case isFalse:
+		return c.f.EvalInterval(env).singular(cond.Singular)
+	}
+	return c.t.EvalInterval(env).join(c.f.EvalInterval(env)).singular(cond.Singular)
+}
+
+// EvalInterval bounds the call with the Interval of the function in
+// DefaultFuncs; a function without one may have any value.
+func (c call) EvalInterval(env IntervalEnv) Interval {
+	args := make([]Interval, len(c.args))
+	singular := false
+	for i, arg := range c.args {
+		args[i] = arg.EvalInterval(env)
+		singular = singular || args[i].Singular
+	}
+	f, ok := DefaultFuncs[c.fn]
+	if !ok || f.Interval == nil {
+		return Entire.singular(true)
+	}
+	return f.Interval(args).singular(singular)
+}
+
+// EvalInterval bounds a block by expanding its definitions.
+func (b block) EvalInterval(env IntervalEnv) Interval {
+	e, err := Expand(b)
+	if err != nil {
+		return Entire.singular(true) // recursive; Check reports it
+	}
+	return e.EvalInterval(env)
+}
+
+// ---- intervals of functions ----
+
+// increasing returns f with an Interval for a function of one
+// parameter that is nondecreasing on its domain [lo, hi].
+func increasing(f Func, lo, hi float64) Func {
+	f.Interval = func(args []Interval) Interval {
+		x, s := clamp(args[0], lo, hi)
+		return overflow(Interval{f.Impl([]float64{x.Lo}), f.Impl([]float64{x.Hi}), s}.outward(), x)
+	}
+	return f
+}
+
+// decreasing is like increasing for a nonincreasing function.
+func decreasing(f Func, lo, hi float64) Func {
+	f.Interval = func(args []Interval) Interval {
+		x, s := clamp(args[0], lo, hi)
+		return overflow(Interval{f.Impl([]float64{x.Hi}), f.Impl([]float64{x.Lo}), s}.outward(), x)
+	}
+	return f
+}
+
+// even returns f with an Interval for a function of one parameter that
+// is symmetric about 0 and nondecreasing for positive arguments.
+func even(f Func) Func {
+	f.Interval = func(args []Interval) Interval {
+		lo, hi := absInterval(args[0])
+		return overflow(Interval{f.Impl([]float64{lo}), f.Impl([]float64{hi}), false}.outward(), args[0])
+	}
+	return f
+}
+
+// withInterval returns f with the given Interval.
+func withInterval(f Func, interval func(args []Interval) Interval) Func {
+	f.Interval = interval
+	return f
+}
+
+// clamp restricts x to the domain [lo, hi] of a function, reporting
+// whether x reached outside it.
+func clamp(x Interval, lo, hi float64) (Interval, bool) {
+	if x.Hi < lo || x.Lo > hi {
+		return Interval{math.NaN(), math.NaN(), true}, true // outward makes it Entire
+	}
+	s := x.Lo < lo || x.Hi > hi
+	return Interval{math.Max(x.Lo, lo), math.Min(x.Hi, hi), s}, s
+}
+
+// absInterval returns the range of |x| for x in i.
+func absInterval(i Interval) (lo, hi float64) {
+	hi = math.Max(math.Abs(i.Lo), math.Abs(i.Hi))
+	if i.Contains(0) {
+		return 0, hi
+	}
+	return math.Min(math.Abs(i.Lo), math.Abs(i.Hi)), hi
+}
+
+// sinInterval bounds sin(x + phase) for x in i.
+func sinInterval(i Interval, phase float64) Interval {
+	lo, hi := i.Lo+phase, i.Hi+phase
+	if hi-lo >= 2*math.Pi || math.IsInf(lo, 0) || math.IsInf(hi, 0) {
+		return Interval{-1, 1, false}
+	}
+	a, b := math.Sin(lo), math.Sin(hi)
+	r := Interval{math.Min(a, b), math.Max(a, b), false}.outward()
+	// a maximum at π/2 + 2kπ or a minimum at -π/2 + 2kπ within [lo, hi]?
+	if math.Ceil((lo-math.Pi/2)/(2*math.Pi)) <= math.Floor((hi-math.Pi/2)/(2*math.Pi)) {
+		r.Hi = 1
+	}
+	if math.Ceil((lo+math.Pi/2)/(2*math.Pi)) <= math.Floor((hi+math.Pi/2)/(2*math.Pi)) {
+		r.Lo = -1
+	}
+	r.Lo, r.Hi = math.Max(r.Lo, -1), math.Min(r.Hi, 1)
+	return r
+}
+
+func tanInterval(args []Interval) Interval {
+	x := args[0]
+	// a pole at π/2 + kπ within x?
+	if x.Hi-x.Lo >= math.Pi || math.Ceil((x.Lo-math.Pi/2)/math.Pi) <= math.Floor((x.Hi-math.Pi/2)/math.Pi) {
+		return Entire.singular(true)
+	}
+	return Interval{math.Tan(x.Lo), math.Tan(x.Hi), false}.outward()
+}
+
+func powInterval(args []Interval) Interval {
+	x, y := args[0], args[1]
+	if y.point() && y.Lo == math.Trunc(y.Lo) && math.Abs(y.Lo) < 1<<53 {
+		n := y.Lo
+		if n < 0 {
+			return divInterval(Point(1), powInterval([]Interval{x, Point(-n)}))
+		}
+		if math.Mod(n, 2) == 0 {
+			lo, hi := absInterval(x)
+			return overflow(Interval{math.Pow(lo, n), math.Pow(hi, n), false}.outward(), x)
+		}
+		return overflow(Interval{math.Pow(x.Lo, n), math.Pow(x.Hi, n), false}.outward(), x)
+	}
+	if x.Lo < 0 || (x.Lo == 0 && y.Lo <= 0) {
+		// not real for negative x, and 0⁰ and 0^-y need care too
+		return Entire.singular(true)
+	}
+	// x^y = exp(y log x), which is monotonic in each
+	p := [4]float64{math.Pow(x.Lo, y.Lo), math.Pow(x.Lo, y.Hi), math.Pow(x.Hi, y.Lo), math.Pow(x.Hi, y.Hi)}
+	r := Interval{p[0], p[0], false}
+	for _, v := range p[1:] {
+		r.Lo, r.Hi = math.Min(r.Lo, v), math.Max(r.Hi, v)
+	}
+	return overflow(r.outward(), x, y)
+}
+
+func foldInterval(f func(float64, float64) float64) func(args []Interval) Interval {
+	return func(args []Interval) Interval {
+		r := args[0]
+		for _, a := range args[1:] {
+			r = Interval{f(r.Lo, a.Lo), f(r.Hi, a.Hi), false}
+		}
+		return r
+	}
+}
+
+func hypotInterval(args []Interval) Interval {
+	xlo, xhi := absInterval(args[0])
+	ylo, yhi := absInterval(args[1])
+	return overflow(Interval{math.Hypot(xlo, ylo), math.Hypot(xhi, yhi), false}.outward(), args...)
+}
@@ -0,0 +1,125 @@
+package eval
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestEvalInterval(t *testing.T) {
+	box := IntervalEnv{"x": {Lo: -15, Hi: 15}, "y": {Lo: -15, Hi: 15}, "r": {Lo: 0, Hi: 21.3}}
+	pos := IntervalEnv{"x": {Lo: 0.5, Hi: 2}, "y": {Lo: 1, Hi: 3}}
+	tests := []struct {
+		expr     string
+		env      IntervalEnv
+		lo, hi   float64 // the tightest bounds, which the result must contain
+		singular bool
+	}{
+		{"x + y", box, -30, 30, false},
+		{"x - y", pos, -2.5, 1, false},
+		{"x * y", box, -225, 225, false},
+		{"-x * x", pos, -4, -0.25, false},
+		{"y / x", pos, 0.5, 6, false},
+		{"1 / x", box, 0, 0, true},
+		{"sin(r) / r", box, 0, 0, true},
+		{"sin(x)", pos, math.Sin(0.5), 1, false},
+		{"cos(x)", pos, math.Cos(2), math.Cos(0.5), false},
+		{"sin(-x) * pow(1.5, -r)", box, -1, 1, false},
+		{"pow(2, sin(y)) * pow(2, sin(x)) / 12", box, 1.0 / 48, 1.0 / 3, false},
+		{"pow(x, 2)", box, 0, 225, false},
+		{"pow(x, 3)", pos, 0.125, 8, false},
+		{"pow(x, -1)", pos, 0.5, 2, false},
+		{"pow(x, 0.5)", box, 0, 0, true},
+		{"sqrt(x)", box, 0, math.Sqrt(15), true},
+		{"log(x)", pos, math.Log(0.5), math.Log(2), false},
+		{"log(r)", box, 0, 0, true},
+		{"exp(x * 100)", box, 0, 0, true},
+		{"abs(x - 1)", pos, 0, 1, false},
+		{"min(x, y, 1)", pos, 0.5, 1, false},
+		{"max(x, y)", pos, 1, 3, false},
+		{"hypot(x, y)", box, 0, math.Hypot(15, 15), false},
+		{"tan(x)", pos, 0, 0, true},
+		{"x < y", pos, 0, 1, false},
+		{"x < y + 2", pos, 1, 1, false},
+		{"x > y + 2", pos, 0, 0, false},
+		{"!(x > 5)", pos, 1, 1, false},
+		{"x > 1 && y > 0", pos, 0, 1, false},
+		{"x > 5 || y > 0", pos, 1, 1, false},
+		{"x > 5 ? 1/0 : y", pos, 1, 3, false},
+		{"x > 1 ? x : -y", pos, -3, 2, false},
+		{"gamma(x)", pos, 0, 0, true},
+		{"let a = x*x; f(t) = t + 1; f(a)", pos, 1.25, 5, false},
+		{"z", pos, 0, 0, false},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.expr, err)
+			continue
+		}
+		got := expr.EvalInterval(test.env)
+		if got.Singular != test.singular {
+			t.Errorf("%s.EvalInterval(...) = %v, want singular %t", test.expr, got, test.singular)
+		}
+		if !test.singular && (got.Lo > test.lo || got.Hi < test.hi) {
+			t.Errorf("%s.EvalInterval(...) = %v, want superset of [%g, %g]",
+				test.expr, got, test.lo, test.hi)
+		}
+	}
+}
+
+// TestEvalIntervalContains checks that the value of each expression at
+// random points of the ranges of its variables lies within its bounds.
+func TestEvalIntervalContains(t *testing.T) {
+	exprs := []string{
+		"x * y - x / y",
+		"sin(x) * cos(y) + tan(x / 10)",
+		"pow(x, y) + pow(y, 2) - pow(x, -3)",
+		"sqrt(abs(x)) * exp(-y) + log1p(y)",
+		"atan2(y, x) + hypot(x, y) + cbrt(x - y)",
+		"min(x, y, x * y) - max(x, -y)",
+		"x < y ? sinh(x) : cosh(y)",
+		"floor(x) + ceil(y) + round(x * y) + trunc(x - y)",
+		"erf(x) + erfc(y) + tanh(x * y) + asinh(x)",
+		"let a = x + y; g(t) = t * t; g(a) - g(x) - g(y)",
+	}
+	ranges := []IntervalEnv{
+		{"x": {Lo: -3, Hi: 3}, "y": {Lo: 0.1, Hi: 2}},
+		{"x": {Lo: 0.5, Hi: 0.75}, "y": {Lo: -1, Hi: -0.5}},
+		{"x": {Lo: 1e-3, Hi: 10}, "y": {Lo: 2, Hi: 2}},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, s := range exprs {
+		expr, err := Parse(s)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", s, err)
+			continue
+		}
+		for _, env := range ranges {
+			bounds := expr.EvalInterval(env)
+			for i := 0; i < 1000; i++ {
+				point := make(Env)
+				for v, r := range env {
+					point[v] = r.Lo + rng.Float64()*(r.Hi-r.Lo)
+				}
+				if i == 0 { // include a corner
+					for v, r := range env {
+						point[v] = r.Lo
+					}
+				}
+				got := expr.Eval(point)
+				if math.IsNaN(got) || math.IsInf(got, 0) {
+					if !bounds.Singular {
+						t.Errorf("%s at %v = %g, but its bounds %v are not singular",
+							s, point, got, bounds)
+					}
+					continue
+				}
+				if !bounds.Contains(got) {
+					t.Errorf("%s at %v = %g, outside its bounds %v", s, point, got, bounds)
+					break
+				}
+			}
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"strings"
 	"text/scanner"
+	"unicode/utf8"
 )
 
 // ---- lexer ----
@@ -12,13 +13,112 @@ import (
 // This lexer is similar to the one described in Chapter 13.
 type lexer struct {
 	scan  scanner.Scanner
-	token rune // current lookahead token
+	token rune             // current lookahead token
+	tok   string           // text of the current token
+	pos   scanner.Position // position of the current token
+
+	// for Position: the Var or literal sought and where it was found
+	want  Expr
+	found *scanner.Position
+}
+
+// leaf records that the parser built the Var or literal e at p and
+// returns e.
+func (lex *lexer) leaf(e Expr, p scanner.Position) Expr {
+	if lex.want != nil && lex.found == nil && e == lex.want {
+		lex.found = &p
+	}
+	return e
+}
+
+// at returns the pos of a node at p.
+func at(p scanner.Position) pos { return pos(p.Offset + 1) }
+
+func (lex *lexer) text() string { return lex.tok }
+
+// Tokens for the two-character operators. The scanner only returns
+// single runes, so next combines them; the values are negative so
+// they can never collide with a rune or a scanner token kind.
+const (
+	opLE  rune = -(iota + 100) // <=
+	opGE                       // >=
+	opEQ                       // ==
+	opNE                       // !=
+	opAnd                      // &&
+	opOr                       // ||
+)
+
+var twoCharOps = map[[2]rune]rune{
+	{'<', '='}: opLE,
+	{'>', '='}: opGE,
+	{'=', '='}: opEQ,
+	{'!', '='}: opNE,
+	{'&', '&'}: opAnd,
+	{'|', '|'}: opOr,
+}
+
+func (lex *lexer) next() {
+	lex.token = lex.scan.Scan()
+	lex.tok = lex.scan.TokenText()
+	lex.pos = lex.scan.Position
+	if !lex.pos.IsValid() {
+		lex.pos = lex.scan.Pos() // at end of input with no token before it
+	}
+	if op, ok := twoCharOps[[2]rune{lex.token, lex.scan.Peek()}]; ok {
+		lex.scan.Next() // consume second rune
+		lex.token = op
+		lex.tok = opString(op)
+	}
+}
+
+// opString returns the source form of an operator token.
+func opString(op rune) string {
+	switch op {
+	case opLE:
+		return "<="
+	case opGE:
+		return ">="
+	case opEQ:
+		return "=="
+	case opNE:
+		return "!="
+	case opAnd:
+		return "&&"
+	case opOr:
+		return "||"
+	}
+	return string(op)
 }
 
-func (lex *lexer) next()        { lex.token = lex.scan.Scan() }
-func (lex *lexer) text() string { return lex.scan.TokenText() }
+// A SyntaxError describes a malformed expression and where it is.
+type SyntaxError struct {
+	Line, Column int    // position of the offending token, starting at 1
+	Offset       int    // byte offset of the offending token, starting at 0
+	Token        string // the offending token; empty at end of input
+	Msg          string // what is wrong, e.g., "unexpected ')'"
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
 
-type lexPanic string
+// A lexPanic carries a syntax error from deep in the parser up to Parse.
+type lexPanic SyntaxError
+
+// errorf returns a panic value for a syntax error at the current token.
+func (lex *lexer) errorf(format string, args ...interface{}) lexPanic {
+	return errorAt(lex.pos, lex.tok, fmt.Sprintf(format, args...))
+}
+
+func errorAt(pos scanner.Position, tok, msg string) lexPanic {
+	return lexPanic{
+		Line:   pos.Line,
+		Column: pos.Column,
+		Offset: pos.Offset,
+		Token:  tok,
+		Msg:    msg,
+	}
+}
 
 // describe returns a string describing the current token, for use in errors.
 func (lex *lexer) describe() string {
@@ -29,6 +129,8 @@ func (lex *lexer) describe() string {
 		return fmt.Sprintf("identifier %s", lex.text())
 	case scanner.Int, scanner.Float:
 		return fmt.Sprintf("number %s", lex.text())
+	case opLE, opGE, opEQ, opNE, opAnd, opOr:
+		return fmt.Sprintf("'%s'", opString(lex.token))
 	}
 	return fmt.Sprintf("%q", rune(lex.token)) // any other rune
 }
@@ -36,8 +138,14 @@ func (lex *lexer) describe() string {
 func precedence(op rune) int {
 	switch op {
 	case '*', '/':
-		return 2
+		return 5
 	case '+', '-':
+		return 4
+	case '<', opLE, '>', opGE, opEQ, opNE:
+		return 3
+	case opAnd:
+		return 2
+	case opOr:
 		return 1
 	}
 	return 0
@@ -47,36 +155,180 @@ func precedence(op rune) int {
 
 // Parse parses the input string as an arithmetic expression.
 //
-//   expr = num                         a literal number, e.g., 3.14159
+//   expr = num                         a literal number, e.g., 3.14159 or -2
 //        | id                          a variable name, e.g., x
 //        | id '(' expr ',' ... ')'     a function call
-//        | '-' expr                    a unary operator (+-)
+//        | '-' expr                    a unary operator (+-!)
 //        | expr '+' expr               a binary operator (+-*/)
+//        | expr '<' expr               a comparison (< <= > >= == !=)
+//        | expr '&&' expr              a logical operator (&& ||)
+//        | expr '?' expr ':' expr      a conditional
+//        | 'if' '(' expr ',' expr ',' expr ')'
+//                                      a conditional in call form
+//
+// Comparisons and logical operators yield 1 for true and 0 for false;
+// any nonzero operand counts as true.
+//
+// The input may start with definitions, each ended by ';', for use by
+// the expression that follows (see block for the scoping rules):
+//
+//   program = {definition ';'} expr
+//
+//   definition = 'let' id '=' expr             a variable, e.g., let a = x*x
+//              | id '(' id ',' ... ')' '=' expr
+//                                              a function, e.g., f(t) = sin(t)/t
 //
-func Parse(input string) (_ Expr, err error) {
+// A parenthesized expression may also be a program.
+//
+// If the input is malformed, the error is a *SyntaxError.
+//
+func Parse(input string) (Expr, error) { return parse(input, new(lexer)) }
+
+// Position returns the position in input of e, a subexpression of an
+// expression parsed from input such as the Expr of a *CheckError, and
+// whether it is known: the operator of a unary, binary, comparison or
+// logical expression, the '?' of a conditional, the start of a block,
+// and the name of a call. Parse records these in the nodes, and
+// Derive, Subst, Simplify and Expand keep them in the nodes they
+// rewrite. A Var or a literal has no position of its own, so for one
+// Position returns that of its first occurrence. Line and Column start
+// at 1 and Offset at 0.
+func Position(input string, e Expr) (line, column, offset int, ok bool) {
+	var at pos
+	switch e := e.(type) {
+	case Var, literal:
+		lex := &lexer{want: e}
+		if _, err := parse(input, lex); err != nil || lex.found == nil {
+			return 0, 0, 0, false
+		}
+		return lex.found.Line, lex.found.Column, lex.found.Offset, true
+	case unary:
+		at = e.at
+	case binary:
+		at = e.at
+	case compare:
+		at = e.at
+	case logical:
+		at = e.at
+	case conditional:
+		at = e.at
+	case call:
+		at = e.at
+	case block:
+		at = e.at
+	}
+	offset = int(at) - 1
+	if offset < 0 || offset > len(input) {
+		return 0, 0, 0, false
+	}
+	before := input[:offset]
+	start := strings.LastIndexByte(before, '\n') + 1 // of the line
+	line = strings.Count(before, "\n") + 1
+	column = utf8.RuneCountInString(before[start:]) + 1
+	return line, column, offset, true
+}
+
+func parse(input string, lex *lexer) (_ Expr, err error) {
 	defer func() {
 		switch x := recover().(type) {
 		case nil:
 			// no panic
 		case lexPanic:
-			err = fmt.Errorf("%s", x)
+			se := SyntaxError(x)
+			err = &se
 		default:
 			// unexpected panic: resume state of panic.
 			panic(x)
 		}
 	}()
-	lex := new(lexer)
 	lex.scan.Init(strings.NewReader(input))
 	lex.scan.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanFloats
+	lex.scan.Error = func(s *scanner.Scanner, msg string) {
+		// e.g., a malformed number; s.Position is the start of the token,
+		// if the error is in one
+		pos := s.Position
+		if !pos.IsValid() {
+			pos = s.Pos()
+		}
+		panic(errorAt(pos, s.TokenText(), msg))
+	}
 	lex.next() // initial lookahead
-	e := parseExpr(lex)
+	e := parseProgram(lex)
 	if lex.token != scanner.EOF {
-		return nil, fmt.Errorf("unexpected %s", lex.describe())
+		panic(lex.errorf("unexpected %s", lex.describe()))
 	}
 	return e, nil
 }
 
-func parseExpr(lex *lexer) Expr { return parseBinary(lex, 1) }
+// program = {definition ';'} expr
+func parseProgram(lex *lexer) Expr {
+	var defs []def
+	start := lex.pos
+	for {
+		if lex.token == scanner.Ident && lex.text() == "let" {
+			lex.next() // consume 'let'
+			if lex.token != scanner.Ident {
+				panic(lex.errorf("got %s, want identifier", lex.describe()))
+			}
+			name := lex.text()
+			lex.next() // consume Ident
+			if lex.token != '=' {
+				panic(lex.errorf("got %s, want '='", lex.describe()))
+			}
+			lex.next() // consume '='
+			defs = append(defs, def{name: name, body: parseExpr(lex)})
+		} else {
+			pos, tok := lex.pos, lex.tok
+			e := parseExpr(lex)
+			if lex.token != '=' {
+				if defs == nil {
+					return e
+				}
+				return block{defs, e, at(start)}
+			}
+			// e is the left side of a function definition
+			d := def{fn: true}
+			c, ok := e.(call)
+			if !ok {
+				panic(errorAt(pos, tok, "left side of '=' must be f(params)"))
+			}
+			d.name = c.fn
+			for _, arg := range c.args {
+				param, ok := arg.(Var)
+				if !ok {
+					panic(errorAt(pos, tok, fmt.Sprintf("parameter of %s must be an identifier", c.fn)))
+				}
+				d.params = append(d.params, param)
+			}
+			lex.next() // consume '='
+			d.body = parseExpr(lex)
+			defs = append(defs, d)
+		}
+		if lex.token != ';' {
+			panic(lex.errorf("got %s, want ';'", lex.describe()))
+		}
+		lex.next() // consume ';'
+	}
+}
+
+func parseExpr(lex *lexer) Expr { return parseConditional(lex) }
+
+// conditional = binary ['?' conditional ':' conditional]
+func parseConditional(lex *lexer) Expr {
+	c := parseBinary(lex, 1)
+	if lex.token != '?' {
+		return c
+	}
+	pos := lex.pos
+	lex.next() // consume '?'
+	t := parseConditional(lex)
+	if lex.token != ':' {
+		panic(lex.errorf("got %s, want ':'", lex.describe()))
+	}
+	lex.next() // consume ':'
+	f := parseConditional(lex)
+	return conditional{c, t, f, at(pos)}
+}
 
 // binary = unary ('+' binary)*
 // parseBinary stops when it encounters an
@@ -85,21 +337,40 @@ func parseBinary(lex *lexer, prec1 int) Expr {
 	lhs := parseUnary(lex)
 	for prec := precedence(lex.token); prec >= prec1; prec-- {
 		for precedence(lex.token) == prec {
-			op := lex.token
+			op, pos := lex.token, lex.pos
 			lex.next() // consume operator
 			rhs := parseBinary(lex, prec+1)
-			lhs = binary{op, lhs, rhs}
+			lhs = makeBinary(op, lhs, rhs, at(pos))
 		}
 	}
 	return lhs
 }
 
+// makeBinary returns the node for the binary operator op.
+func makeBinary(op rune, x, y Expr, at pos) Expr {
+	switch op {
+	case opAnd, opOr:
+		return logical{op, x, y, at}
+	case '<', opLE, '>', opGE, opEQ, opNE:
+		return compare{op, x, y, at}
+	}
+	return binary{op, x, y, at}
+}
+
 // unary = '+' expr | primary
+//
+// A '-' before a number makes a negative literal, so that String of a
+// negative literal parses back to one.
 func parseUnary(lex *lexer) Expr {
-	if lex.token == '+' || lex.token == '-' {
-		op := lex.token
-		lex.next() // consume '+' or '-'
-		return unary{op, parseUnary(lex)}
+	if lex.token == '+' || lex.token == '-' || lex.token == '!' {
+		op, pos := lex.token, lex.pos
+		lex.next() // consume '+', '-' or '!'
+		if op == '-' && (lex.token == scanner.Int || lex.token == scanner.Float) {
+			e := lex.leaf(literal(-parseNumber(lex)), pos)
+			lex.next() // consume number
+			return e
+		}
+		return unary{op, parseUnary(lex), at(pos)}
 	}
 	return parsePrimary(lex)
 }
@@ -111,10 +382,10 @@ func parseUnary(lex *lexer) Expr {
 func parsePrimary(lex *lexer) Expr {
 	switch lex.token {
 	case scanner.Ident:
-		id := lex.text()
+		id, pos := lex.text(), lex.pos
 		lex.next() // consume Ident
 		if lex.token != '(' {
-			return Var(id)
+			return lex.leaf(Var(id), pos)
 		}
 		lex.next() // consume '('
 		var args []Expr
@@ -127,31 +398,40 @@ func parsePrimary(lex *lexer) Expr {
 				lex.next() // consume ','
 			}
 			if lex.token != ')' {
-				msg := fmt.Sprintf("got %q, want ')'", lex.token)
-				panic(lexPanic(msg))
+				panic(lex.errorf("got %s, want ')'", lex.describe()))
 			}
 		}
 		lex.next() // consume ')'
-		return call{id, args}
+		if id == "if" {
+			if len(args) != 3 {
+				panic(errorAt(pos, id, fmt.Sprintf("call to if has %d args, want 3", len(args))))
+			}
+			return conditional{args[0], args[1], args[2], at(pos)}
+		}
+		return call{id, args, at(pos)}
 
 	case scanner.Int, scanner.Float:
-		f, err := strconv.ParseFloat(lex.text(), 64)
-		if err != nil {
-			panic(lexPanic(err.Error()))
-		}
+		e := lex.leaf(literal(parseNumber(lex)), lex.pos)
 		lex.next() // consume number
-		return literal(f)
+		return e
 
 	case '(':
 		lex.next() // consume '('
-		e := parseExpr(lex)
+		e := parseProgram(lex)
 		if lex.token != ')' {
-			msg := fmt.Sprintf("got %s, want ')'", lex.describe())
-			panic(lexPanic(msg))
+			panic(lex.errorf("got %s, want ')'", lex.describe()))
 		}
 		lex.next() // consume ')'
 		return e
 	}
-	msg := fmt.Sprintf("unexpected %s", lex.describe())
-	panic(lexPanic(msg))
+	panic(lex.errorf("unexpected %s", lex.describe()))
+}
+
+// parseNumber returns the value of the current token, a number.
+func parseNumber(lex *lexer) float64 {
+	f, err := strconv.ParseFloat(lex.text(), 64)
+	if err != nil {
+		panic(lex.errorf("%s", err.(*strconv.NumError).Err))
+	}
+	return f
 }
@@ -0,0 +1,158 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ---- formatting ----
+
+// Precedence levels of the kinds of expression beyond the binary
+// operators (1 to 5, see precedence), for deciding where String needs
+// parentheses. A subexpression is parenthesized if its level is lower
+// than its position in the grammar demands.
+const (
+	precBlock       = -1
+	precConditional = 0
+	precUnary       = 6
+	precPrimary     = 7
+)
+
+func level(e Expr) int {
+	switch e := e.(type) {
+	case block:
+		return precBlock
+	case conditional:
+		return precConditional
+	case binary:
+		return precedence(e.op)
+	case compare:
+		return precedence(e.op)
+	case logical:
+		return precedence(e.op)
+	case unary:
+		return precUnary
+	case literal:
+		switch f := float64(e); {
+		case math.IsInf(f, 0) || math.IsNaN(f):
+			return precedence('/') // formatted as a division
+		case math.Signbit(f):
+			return precUnary // formatted with a leading '-'
+		}
+	}
+	return precPrimary
+}
+
+// operand formats e, parenthesized if its level is below prec.
+func operand(e Expr, prec int) string {
+	if level(e) < prec {
+		return "(" + e.String() + ")"
+	}
+	return e.String()
+}
+
+// infix formats x op y for a left-associative operator op.
+func infix(op rune, x, y Expr) string {
+	prec := precedence(op)
+	return operand(x, prec) + " " + opString(op) + " " + operand(y, prec+1)
+}
+
+func (v Var) String() string { return string(v) }
+
+func (l literal) String() string {
+	switch f := float64(l); {
+	case math.IsInf(f, +1):
+		return "1 / 0"
+	case math.IsInf(f, -1):
+		return "-1 / 0"
+	case math.IsNaN(f):
+		return "0 / 0"
+	}
+	return strconv.FormatFloat(float64(l), 'g', -1, 64)
+}
+
+func (u unary) String() string {
+	if l, ok := u.x.(literal); ok && u.op == '-' && level(l) == precPrimary {
+		return "-(" + l.String() + ")" // not a negative literal
+	}
+	return opString(u.op) + operand(u.x, precUnary)
+}
+
+func (b binary) String() string  { return infix(b.op, b.x, b.y) }
+func (c compare) String() string { return infix(c.op, c.x, c.y) }
+func (l logical) String() string { return infix(l.op, l.x, l.y) }
+func (c conditional) String() string {
+	// the condition is a binary expression; either branch may be another conditional
+	return operand(c.cond, 1) + " ? " + operand(c.t, precConditional) + " : " + operand(c.f, precConditional)
+}
+
+func (c call) String() string {
+	args := make([]string, len(c.args))
+	for i, arg := range c.args {
+		args[i] = operand(arg, precConditional)
+	}
+	return c.fn + "(" + strings.Join(args, ", ") + ")"
+}
+
+// Tree formats e as an indented tree of its nodes, one per line, for
+// debugging.
+func Tree(e Expr) string {
+	var buf strings.Builder
+	tree(&buf, e, 0)
+	return buf.String()
+}
+
+func tree(buf *strings.Builder, e Expr, depth int) {
+	buf.WriteString(strings.Repeat("  ", depth))
+	switch e := e.(type) {
+	case Var:
+		fmt.Fprintf(buf, "var %s\n", e)
+	case literal:
+		fmt.Fprintf(buf, "literal %s\n", e)
+	case unary:
+		fmt.Fprintf(buf, "unary %s\n", opString(e.op))
+		tree(buf, e.x, depth+1)
+	case binary:
+		fmt.Fprintf(buf, "binary %s\n", opString(e.op))
+		tree(buf, e.x, depth+1)
+		tree(buf, e.y, depth+1)
+	case compare:
+		fmt.Fprintf(buf, "compare %s\n", opString(e.op))
+		tree(buf, e.x, depth+1)
+		tree(buf, e.y, depth+1)
+	case logical:
+		fmt.Fprintf(buf, "logical %s\n", opString(e.op))
+		tree(buf, e.x, depth+1)
+		tree(buf, e.y, depth+1)
+	case conditional:
+		buf.WriteString("conditional\n")
+		tree(buf, e.cond, depth+1)
+		tree(buf, e.t, depth+1)
+		tree(buf, e.f, depth+1)
+	case call:
+		fmt.Fprintf(buf, "call %s\n", e.fn)
+		for _, arg := range e.args {
+			tree(buf, arg, depth+1)
+		}
+	case block:
+		buf.WriteString("block\n")
+		for _, d := range e.defs {
+			buf.WriteString(strings.Repeat("  ", depth+1))
+			if d.fn {
+				params := make([]string, len(d.params))
+				for i, p := range d.params {
+					params[i] = string(p)
+				}
+				fmt.Fprintf(buf, "func %s(%s)\n", d.name, strings.Join(params, ", "))
+			} else {
+				fmt.Fprintf(buf, "let %s\n", d.name)
+			}
+			tree(buf, d.body, depth+2)
+		}
+		tree(buf, e.body, depth+1)
+	default:
+		fmt.Fprintf(buf, "%T\n", e)
+	}
+}
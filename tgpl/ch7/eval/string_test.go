@@ -0,0 +1,204 @@
+package eval
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{"sqrt(A / pi)", "sqrt(A / pi)"},
+		{"((x) + (y)) * z", "(x + y) * z"},
+		{"x + (y * z)", "x + y * z"},
+		{"(x - y) - z", "x - y - z"},
+		{"x - (y - z)", "x - (y - z)"},
+		{"x / (y * z)", "x / (y * z)"},
+		{"-(-x)", "--x"},
+		{"-(x + 1)", "-(x + 1)"},
+		{"!(x < 1) || y >= 2 && z != 0", "!(x < 1) || y >= 2 && z != 0"},
+		{"(x || y) && z", "(x || y) && z"},
+		{"(x < y) == (y < z)", "x < y == (y < z)"},
+		{"if(x < 0, -x, x)", "x < 0 ? -x : x"},
+		{"(a ? b : c) ? d : (e ? f : g)", "(a ? b : c) ? d : e ? f : g"},
+		{"(a ? b : c) + 1", "(a ? b : c) + 1"},
+		{"pow(x, 3) + max(x, y, 1.5e-7)", "pow(x, 3) + max(x, y, 1.5e-07)"},
+		{"f()", "f()"},
+		{"let a = x*x; f(t) = sin(t)/t; f(a) + r", "let a = x * x; f(t) = sin(t) / t; f(a) + r"},
+		{"f(s, t) = s ? t : -t; let b = (let c = 2; c); f(b, 1 + (g() = 1; g()))",
+			"f(s, t) = s ? t : -t; let b = (let c = 2; c); f(b, 1 + (g() = 1; g()))"},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Error(err) // parse error
+			continue
+		}
+		if got := expr.String(); got != test.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	tests := []struct {
+		input        string
+		line, column int
+		token        string
+		msg          string
+	}{
+		{"sin(x))", 1, 7, ")", "unexpected ')'"},
+		{"x +\n  * y", 2, 3, "*", "unexpected '*'"},
+		{"pow(x, 2", 1, 9, "", "got end of file, want ')'"},
+		{"x <= 1 || || y", 1, 11, "||", "unexpected '||'"},
+		{"(x + 1", 1, 7, "", "got end of file, want ')'"},
+		{"1e999", 1, 1, "1e999", "value out of range"},
+		{"2 * 1e", 1, 5, "1e", "exponent has no digits"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.input)
+		se, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("Parse(%q) error = %v, want *SyntaxError", test.input, err)
+			continue
+		}
+		if se.Line != test.line || se.Column != test.column || se.Token != test.token || se.Msg != test.msg {
+			t.Errorf("Parse(%q) error = %d:%d %q %q, want %d:%d %q %q", test.input,
+				se.Line, se.Column, se.Token, se.Msg,
+				test.line, test.column, test.token, test.msg)
+		}
+	}
+}
+
+func TestCheckErrorPosition(t *testing.T) {
+	tests := []struct {
+		input        string
+		line, column int
+		msg          string
+	}{
+		{"sin(x) + sin(x, y)", 1, 10, "call to sin has 2 args, want 1"},
+		{"1 +\n  frob(2)", 2, 3, `unknown function "frob"`},
+		{"x * (pow(x) - 1)", 1, 6, "call to pow has 1 args, want 2"},
+		{"let a = 1; f(t) = a * t; f(2)", 1, 1, "function f uses a, which is defined by let"},
+		{"(f(t) = t; f(x)) + f(x)", 1, 20, `unknown function "f"`}, // not the first f(x)
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.input, err)
+			continue
+		}
+		err = expr.Check(map[Var]bool{})
+		ce, ok := err.(*CheckError)
+		if !ok {
+			t.Errorf("%q: Check error = %v, want *CheckError", test.input, err)
+			continue
+		}
+		line, column, _, ok := Position(test.input, ce.Expr)
+		if !ok || line != test.line || column != test.column || ce.Msg != test.msg {
+			t.Errorf("%q: Check error at %d:%d (found %t) %q, want %d:%d %q", test.input,
+				line, column, ok, ce.Msg, test.line, test.column, test.msg)
+		}
+	}
+}
+
+func TestTree(t *testing.T) {
+	expr, err := Parse("f(t) = -t; x < 1 ? f(x) : 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `block
+  func f(t)
+    unary -
+      var t
+  conditional
+    compare <
+      var x
+      literal 1
+    call f
+      var x
+    literal 2
+`
+	if got := Tree(expr); got != want {
+		t.Errorf("Tree(%s) =\n%s\nwant\n%s", expr, got, want)
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	roundTrip := func(e Expr, what string) {
+		t.Helper()
+		s := e.String()
+		got, err := Parse(s)
+		if err != nil {
+			t.Errorf("Parse(%q) of %s: %v", s, what, err)
+			return
+		}
+		// String too, as DeepEqual takes -0 for 0
+		if !reflect.DeepEqual(unplaced(got), unplaced(e)) || got.String() != s {
+			t.Errorf("Parse(%q) of %s = %#v, want %#v", s, what, got, e)
+		}
+	}
+	for _, e := range []Expr{
+		literal(-3),
+		literal(math.Copysign(0, -1)),
+		unary{'-', literal(3), 0},
+		unary{'-', literal(-3), 0},
+		binary{'-', Var("x"), literal(-3), 0},
+	} {
+		roundTrip(e, "a literal")
+	}
+	for _, input := range []string{"-(0-3)", "-(0-3) * x", "-3 * x", "x - -0", "-(x * -2)"} {
+		e, err := Parse(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roundTrip(Simplify(e), "Simplify("+input+")")
+		roundTrip(Derive(e, "x"), "Derive("+input+")")
+	}
+
+	seed := int64(1)
+	t.Logf("Random seed: %d", seed)
+	rng := rand.New(rand.NewSource(seed))
+	for i := 0; i < 2000; i++ {
+		e := randomExpr(rng, 6)
+		roundTrip(e, "a random expression")
+		roundTrip(Simplify(e), "Simplify of a random expression")
+		if d, err := DeriveFuncs(e, "x", nil); err == nil {
+			roundTrip(d, "Derive of a random expression")
+		}
+	}
+}
+
+// unplaced returns e without the positions Parse records, to compare
+// it with expressions built otherwise or parsed from other input.
+func unplaced(e Expr) Expr {
+	switch e := e.(type) {
+	case unary:
+		return unary{e.op, unplaced(e.x), 0}
+	case binary:
+		return binary{e.op, unplaced(e.x), unplaced(e.y), 0}
+	case compare:
+		return compare{e.op, unplaced(e.x), unplaced(e.y), 0}
+	case logical:
+		return logical{e.op, unplaced(e.x), unplaced(e.y), 0}
+	case conditional:
+		return conditional{unplaced(e.cond), unplaced(e.t), unplaced(e.f), 0}
+	case call:
+		var args []Expr // nil if e.args is
+		for _, arg := range e.args {
+			args = append(args, unplaced(arg))
+		}
+		return call{e.fn, args, 0}
+	case block:
+		defs := make([]def, len(e.defs))
+		for i, d := range e.defs {
+			defs[i] = d
+			defs[i].body = unplaced(d.body)
+		}
+		return block{defs, unplaced(e.body), 0}
+	}
+	return e // Var, literal
+}
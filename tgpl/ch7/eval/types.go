@@ -0,0 +1,592 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ---- typed values ----
+
+// A Type is the type of a value in typed evaluation.
+//
+// Arithmetic and comparison convert numeric operands of different
+// types to the wider one, Int to Float to Complex, as do the branches
+// of a conditional. Division of Ints gives a Float, and other Int
+// arithmetic that overflows is an error. Complex values may be
+// compared only with == and !=, as may Bools, and the operands of !,
+// && and || and the condition of ?: must be Bools. A literal with an
+// integral value is an Int, and other literals Floats.
+type Type uint8
+
+const (
+	Float   Type = iota // float64, the type of Eval's results
+	Int                 // int64
+	Complex             // complex128
+	Bool
+)
+
+var typeNames = [...]string{Float: "float", Int: "int", Complex: "complex", Bool: "bool"}
+
+func (t Type) String() string { return typeNames[t] }
+
+// wider returns the type to which numeric values of types t and u are
+// converted to combine them.
+func wider(t, u Type) Type {
+	switch {
+	case t == Complex || u == Complex:
+		return Complex
+	case t == Float || u == Float:
+		return Float
+	}
+	return Int
+}
+
+// A Value is the result of typed evaluation. The zero Value is the
+// Float 0.
+type Value struct {
+	typ Type
+	n   int64      // Int, and Bool as 0 or 1
+	x   float64    // Float
+	z   complex128 // Complex
+}
+
+func IntValue(n int64) Value          { return Value{typ: Int, n: n} }
+func FloatValue(x float64) Value      { return Value{typ: Float, x: x} }
+func ComplexValue(z complex128) Value { return Value{typ: Complex, z: z} }
+func BoolValue(b bool) Value {
+	if b {
+		return Value{typ: Bool, n: 1}
+	}
+	return Value{typ: Bool}
+}
+
+// Type returns the type of v.
+func (v Value) Type() Type { return v.typ }
+
+// Int returns v as an int64, truncating a Float and the real part of a
+// Complex as Go's conversions do. A Bool is 0 or 1.
+func (v Value) Int() int64 {
+	switch v.typ {
+	case Float:
+		return int64(v.x)
+	case Complex:
+		return int64(real(v.z))
+	}
+	return v.n
+}
+
+// Float returns v as a float64; for a Complex, its real part.
+func (v Value) Float() float64 {
+	switch v.typ {
+	case Float:
+		return v.x
+	case Complex:
+		return real(v.z)
+	}
+	return float64(v.n)
+}
+
+// Complex returns v as a complex128.
+func (v Value) Complex() complex128 {
+	if v.typ == Complex {
+		return v.z
+	}
+	return complex(v.Float(), 0)
+}
+
+// Bool reports whether v is true, or for a number, nonzero.
+func (v Value) Bool() bool { return v.Complex() != 0 }
+
+func (v Value) String() string {
+	switch v.typ {
+	case Float:
+		return strconv.FormatFloat(v.x, 'g', -1, 64)
+	case Int:
+		return strconv.FormatInt(v.n, 10)
+	case Complex:
+		return fmt.Sprint(v.z)
+	}
+	return strconv.FormatBool(v.n != 0)
+}
+
+// convert returns the numeric value v as type t, which is at least as wide.
+func (v Value) convert(t Type) Value {
+	switch {
+	case v.typ == t:
+		return v
+	case t == Float:
+		return FloatValue(v.Float())
+	case t == Complex:
+		return ComplexValue(v.Complex())
+	}
+	panic(fmt.Sprintf("eval: cannot convert %s to %s", v.typ, t))
+}
+
+// TypeEnv maps variable names to their types. Variables not in it
+// are Floats.
+type TypeEnv map[Var]Type
+
+// ValueEnv maps variable names to their values.
+type ValueEnv map[Var]Value
+
+// Types returns the types of the variables of env.
+func (env ValueEnv) Types() TypeEnv {
+	types := make(TypeEnv, len(env))
+	for v, x := range env {
+		types[v] = x.typ
+	}
+	return types
+}
+
+// A valuePanic is an error found in typed evaluation, which EvalTyped
+// returns.
+type valuePanic string
+
+// EvalTyped returns the value of e.EvalValueContext(ctx, env), or the
+// error it would panic with: mismatched types, which CheckTypeContext
+// reports beforehand, or the overflow of Int arithmetic.
+func EvalTyped(e Expr, ctx *Context, env ValueEnv) (_ Value, err error) {
+	defer func() {
+		switch x := recover().(type) {
+		case nil:
+			// no panic
+		case valuePanic:
+			err = fmt.Errorf("%s", x)
+		default:
+			// unexpected panic: resume state of panic.
+			panic(x)
+		}
+	}()
+	return e.EvalValueContext(ctx, env), nil
+}
+
+func (v Var) CheckType(types TypeEnv) (Type, error) { return v.CheckTypeContext(&Context{}, types) }
+func (v Var) CheckTypeContext(_ *Context, types TypeEnv) (Type, error) {
+	return types[v], nil
+}
+func (v Var) EvalValue(env ValueEnv) Value                    { return v.EvalValueContext(&Context{}, env) }
+func (v Var) EvalValueContext(_ *Context, env ValueEnv) Value { return env[v] }
+
+func (l literal) CheckType(types TypeEnv) (Type, error) { return l.CheckTypeContext(&Context{}, types) }
+func (l literal) CheckTypeContext(_ *Context, _ TypeEnv) (Type, error) {
+	if x := float64(l); x == math.Trunc(x) && math.Abs(x) <= 1<<53 {
+		return Int, nil
+	}
+	return Float, nil
+}
+func (l literal) EvalValue(env ValueEnv) Value { return l.EvalValueContext(&Context{}, env) }
+func (l literal) EvalValueContext(_ *Context, _ ValueEnv) Value {
+	if t, _ := l.CheckType(nil); t == Int {
+		return IntValue(int64(l))
+	}
+	return FloatValue(float64(l))
+}
+
+func (u unary) CheckType(types TypeEnv) (Type, error) { return u.CheckTypeContext(&Context{}, types) }
+func (u unary) CheckTypeContext(ctx *Context, types TypeEnv) (Type, error) {
+	t, err := u.x.CheckTypeContext(ctx, types)
+	if err != nil {
+		return 0, err
+	}
+	switch u.op {
+	case '+', '-':
+		if t == Bool {
+			return 0, checkErrorf(u, "operator %c not defined on %s", u.op, t)
+		}
+		return t, nil
+	case '!':
+		if t != Bool {
+			return 0, checkErrorf(u, "operator ! not defined on %s", t)
+		}
+		return Bool, nil
+	}
+	return 0, checkErrorf(u, "unexpected unary op %q", u.op)
+}
+func (u unary) EvalValue(env ValueEnv) Value { return u.EvalValueContext(&Context{}, env) }
+func (u unary) EvalValueContext(ctx *Context, env ValueEnv) Value {
+	x := u.x.EvalValueContext(ctx, env)
+	switch u.op {
+	case '+':
+		return x
+	case '-':
+		switch x.typ {
+		case Int:
+			if x.n == math.MinInt64 {
+				panic(valuePanic(fmt.Sprintf("integer overflow in %s", u)))
+			}
+			return IntValue(-x.n)
+		case Float:
+			return FloatValue(-x.x)
+		case Complex:
+			return ComplexValue(-x.z)
+		}
+	case '!':
+		return BoolValue(!x.Bool())
+	}
+	panic(fmt.Sprintf("unsupported unary operator: %q on %s", u.op, x.typ))
+}
+
+// binaryType returns the type of the result of op on operands of
+// types x and y.
+func binaryType(op rune, x, y Type) (Type, error) {
+	if x == Bool || y == Bool {
+		return 0, fmt.Errorf("operator %c not defined on bool", op)
+	}
+	t := wider(x, y)
+	if op == '/' && t == Int {
+		t = Float
+	}
+	return t, nil
+}
+
+// intArith returns the result of op on x and y, reporting whether it
+// fits in an int64.
+func intArith(op rune, x, y int64) (int64, bool) {
+	switch op {
+	case '+':
+		r := x + y
+		return r, (r > x) == (y > 0)
+	case '-':
+		r := x - y
+		return r, (r < x) == (y > 0)
+	case '*':
+		if x == 0 || y == 0 {
+			return 0, true
+		}
+		r := x * y
+		return r, r/y == x && !(x == -1 && y == math.MinInt64) && !(y == -1 && x == math.MinInt64)
+	}
+	panic(fmt.Sprintf("unsupported binary operator: %q", op))
+}
+
+func (b binary) CheckType(types TypeEnv) (Type, error) { return b.CheckTypeContext(&Context{}, types) }
+func (b binary) CheckTypeContext(ctx *Context, types TypeEnv) (Type, error) {
+	if !strings.ContainsRune("+-*/", b.op) {
+		return 0, checkErrorf(b, "unexpected binary op %q", b.op)
+	}
+	x, err := b.x.CheckTypeContext(ctx, types)
+	if err != nil {
+		return 0, err
+	}
+	y, err := b.y.CheckTypeContext(ctx, types)
+	if err != nil {
+		return 0, err
+	}
+	t, err := binaryType(b.op, x, y)
+	if err != nil {
+		return 0, &CheckError{b, err.Error()}
+	}
+	return t, nil
+}
+func (b binary) EvalValue(env ValueEnv) Value { return b.EvalValueContext(&Context{}, env) }
+func (b binary) EvalValueContext(ctx *Context, env ValueEnv) Value {
+	x, y := b.x.EvalValueContext(ctx, env), b.y.EvalValueContext(ctx, env)
+	t, err := binaryType(b.op, x.typ, y.typ)
+	if err != nil {
+		panic(valuePanic(err.Error()))
+	}
+	x, y = x.convert(t), y.convert(t)
+	switch t {
+	case Int:
+		n, ok := intArith(b.op, x.n, y.n)
+		if !ok {
+			panic(valuePanic(fmt.Sprintf("integer overflow in %s", b)))
+		}
+		return IntValue(n)
+	case Float:
+		switch b.op {
+		case '+':
+			return FloatValue(x.x + y.x)
+		case '-':
+			return FloatValue(x.x - y.x)
+		case '*':
+			return FloatValue(x.x * y.x)
+		case '/':
+			return FloatValue(x.x / y.x)
+		}
+	case Complex:
+		switch b.op {
+		case '+':
+			return ComplexValue(x.z + y.z)
+		case '-':
+			return ComplexValue(x.z - y.z)
+		case '*':
+			return ComplexValue(x.z * y.z)
+		case '/':
+			return ComplexValue(x.z / y.z)
+		}
+	}
+	panic(fmt.Sprintf("unsupported binary operator: %q", b.op))
+}
+
+// compareType returns the type to which the operands of a comparison
+// of values of types x and y are converted.
+func compareType(op rune, x, y Type) (Type, error) {
+	if (x == Bool) != (y == Bool) {
+		return 0, fmt.Errorf("mismatched types %s and %s for %s", x, y, opString(op))
+	}
+	t := x
+	if t != Bool {
+		t = wider(x, y)
+	}
+	if (t == Bool || t == Complex) && op != opEQ && op != opNE {
+		return 0, fmt.Errorf("operator %s not defined on %s", opString(op), t)
+	}
+	return t, nil
+}
+
+func (c compare) CheckType(types TypeEnv) (Type, error) { return c.CheckTypeContext(&Context{}, types) }
+func (c compare) CheckTypeContext(ctx *Context, types TypeEnv) (Type, error) {
+	switch c.op {
+	case '<', opLE, '>', opGE, opEQ, opNE:
+	default:
+		return 0, checkErrorf(c, "unexpected comparison op %q", opString(c.op))
+	}
+	x, err := c.x.CheckTypeContext(ctx, types)
+	if err != nil {
+		return 0, err
+	}
+	y, err := c.y.CheckTypeContext(ctx, types)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := compareType(c.op, x, y); err != nil {
+		return 0, &CheckError{c, err.Error()}
+	}
+	return Bool, nil
+}
+func (c compare) EvalValue(env ValueEnv) Value { return c.EvalValueContext(&Context{}, env) }
+func (c compare) EvalValueContext(ctx *Context, env ValueEnv) Value {
+	x, y := c.x.EvalValueContext(ctx, env), c.y.EvalValueContext(ctx, env)
+	t, err := compareType(c.op, x.typ, y.typ)
+	if err != nil {
+		panic(valuePanic(err.Error()))
+	}
+	switch t {
+	case Bool, Complex:
+		eq := x.Complex() == y.Complex()
+		return BoolValue(eq == (c.op == opEQ))
+	case Int:
+		return BoolValue(ordered(c.op, x.n < y.n, x.n == y.n, x.n > y.n))
+	}
+	x, y = x.convert(t), y.convert(t)
+	return BoolValue(ordered(c.op, x.x < y.x, x.x == y.x, x.x > y.x))
+}
+
+// ordered returns the result of the comparison op of operands that
+// are less, equal or greater; all are false if one is NaN.
+func ordered(op rune, lt, eq, gt bool) bool {
+	switch op {
+	case '<':
+		return lt
+	case opLE:
+		return lt || eq
+	case '>':
+		return gt
+	case opGE:
+		return gt || eq
+	case opEQ:
+		return eq
+	case opNE:
+		return !eq
+	}
+	panic(fmt.Sprintf("unsupported comparison operator: %q", opString(op)))
+}
+
+func (l logical) CheckType(types TypeEnv) (Type, error) { return l.CheckTypeContext(&Context{}, types) }
+func (l logical) CheckTypeContext(ctx *Context, types TypeEnv) (Type, error) {
+	if l.op != opAnd && l.op != opOr {
+		return 0, checkErrorf(l, "unexpected logical op %q", opString(l.op))
+	}
+	for _, e := range []Expr{l.x, l.y} {
+		t, err := e.CheckTypeContext(ctx, types)
+		if err != nil {
+			return 0, err
+		}
+		if t != Bool {
+			return 0, checkErrorf(l, "operator %s not defined on %s", opString(l.op), t)
+		}
+	}
+	return Bool, nil
+}
+func (l logical) EvalValue(env ValueEnv) Value { return l.EvalValueContext(&Context{}, env) }
+func (l logical) EvalValueContext(ctx *Context, env ValueEnv) Value {
+	x := l.x.EvalValueContext(ctx, env).Bool()
+	if x == (l.op == opOr) {
+		return BoolValue(x) // short circuit
+	}
+	return BoolValue(l.y.EvalValueContext(ctx, env).Bool())
+}
+
+func (c conditional) CheckType(types TypeEnv) (Type, error) {
+	return c.CheckTypeContext(&Context{}, types)
+}
+func (c conditional) CheckTypeContext(ctx *Context, types TypeEnv) (Type, error) {
+	cond, err := c.cond.CheckTypeContext(ctx, types)
+	if err != nil {
+		return 0, err
+	}
+	if cond != Bool {
+		return 0, checkErrorf(c, "condition of ?: has type %s, want bool", cond)
+	}
+	t, err := c.t.CheckTypeContext(ctx, types)
+	if err != nil {
+		return 0, err
+	}
+	f, err := c.f.CheckTypeContext(ctx, types)
+	if err != nil {
+		return 0, err
+	}
+	return branchType(c, t, f)
+}
+
+// branchType returns the type of the conditional c with branches of
+// types t and f.
+func branchType(c conditional, t, f Type) (Type, error) {
+	if (t == Bool) != (f == Bool) {
+		return 0, checkErrorf(c, "branches of ?: have types %s and %s", t, f)
+	}
+	if t == Bool {
+		return Bool, nil
+	}
+	return wider(t, f), nil
+}
+
+func (c conditional) EvalValue(env ValueEnv) Value { return c.EvalValueContext(&Context{}, env) }
+
+// EvalValueContext evaluates only the chosen branch but converts its
+// value to the type of the conditional, so it infers the type of the
+// other. Since the value of every Expr has the type inferred for it,
+// each subexpression is either evaluated or type-checked, and nested
+// conditionals take time in proportion to their size.
+func (c conditional) EvalValueContext(ctx *Context, env ValueEnv) Value {
+	chosen, other := c.t, c.f
+	if !c.cond.EvalValueContext(ctx, env).Bool() {
+		chosen, other = c.f, c.t
+	}
+	v := chosen.EvalValueContext(ctx, env)
+	u, err := other.CheckTypeContext(ctx, env.Types())
+	if err == nil {
+		u, err = branchType(c, v.typ, u)
+	}
+	if err != nil {
+		panic(valuePanic(err.Error()))
+	}
+	return v.convert(u)
+}
+
+// callType returns the type of the result of a call of f, named name,
+// with arguments of types args.
+func callType(name string, f Func, args []Type) (Type, error) {
+	for _, t := range args {
+		if t == Bool {
+			return 0, fmt.Errorf("cannot use bool as argument of %s", name)
+		}
+	}
+	switch {
+	case f.ComplexResult:
+		return Complex, nil
+	case !hasComplex(args) || f.Complex != nil && f.RealResult:
+		return Float, nil
+	case f.Complex != nil:
+		return Complex, nil
+	}
+	return 0, fmt.Errorf("function %s not defined on complex", name)
+}
+
+func (c call) CheckType(types TypeEnv) (Type, error) { return c.CheckTypeContext(&Context{}, types) }
+
+// CheckTypeContext validates the call against ctx.Funcs.
+func (c call) CheckTypeContext(ctx *Context, types TypeEnv) (Type, error) {
+	f, ok := ctx.lookupFunc(c.fn)
+	if !ok {
+		return 0, checkErrorf(c, "unknown function %q", c.fn)
+	}
+	if err := f.checkArity(c.fn, len(c.args)); err != nil {
+		return 0, &CheckError{c, err.Error()}
+	}
+	args := make([]Type, len(c.args))
+	for i, arg := range c.args {
+		t, err := arg.CheckTypeContext(ctx, types)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = t
+	}
+	if f.CheckArgs != nil {
+		if err := f.CheckArgs(c.args); err != nil {
+			return 0, &CheckError{c, fmt.Sprintf("call to %s: %v", c.fn, err)}
+		}
+	}
+	t, err := callType(c.fn, f, args)
+	if err != nil {
+		return 0, &CheckError{c, err.Error()}
+	}
+	return t, nil
+}
+func (c call) EvalValue(env ValueEnv) Value { return c.EvalValueContext(&Context{}, env) }
+func (c call) EvalValueContext(ctx *Context, env ValueEnv) Value {
+	f, ok := ctx.lookupFunc(c.fn)
+	if !ok {
+		panic(valuePanic(fmt.Sprintf("unknown function %q", c.fn)))
+	}
+	args := make([]Value, len(c.args))
+	types := make([]Type, len(c.args))
+	for i, arg := range c.args {
+		args[i] = arg.EvalValueContext(ctx, env)
+		types[i] = args[i].typ
+	}
+	if _, err := callType(c.fn, f, types); err != nil {
+		panic(valuePanic(err.Error()))
+	}
+	if !hasComplex(types) && !f.ComplexResult {
+		xs := make([]float64, len(args))
+		for i, arg := range args {
+			xs[i] = arg.Float()
+		}
+		return FloatValue(f.Impl(xs))
+	}
+	zs := make([]complex128, len(args))
+	for i, arg := range args {
+		zs[i] = arg.Complex()
+	}
+	z := f.Complex(zs)
+	if f.RealResult {
+		return FloatValue(real(z))
+	}
+	return ComplexValue(z)
+}
+
+func hasComplex(types []Type) bool {
+	for _, t := range types {
+		if t == Complex {
+			return true
+		}
+	}
+	return false
+}
+
+func (b block) CheckType(types TypeEnv) (Type, error) { return b.CheckTypeContext(&Context{}, types) }
+
+// CheckTypeContext checks a block and infers its type by expanding its
+// definitions.
+func (b block) CheckTypeContext(ctx *Context, types TypeEnv) (Type, error) {
+	if err := b.CheckContext(ctx, make(map[Var]bool)); err != nil {
+		return 0, err
+	}
+	e, err := Expand(b)
+	if err != nil {
+		return 0, err
+	}
+	return e.CheckTypeContext(ctx, types)
+}
+func (b block) EvalValue(env ValueEnv) Value { return b.EvalValueContext(&Context{}, env) }
+func (b block) EvalValueContext(ctx *Context, env ValueEnv) Value {
+	e, err := Expand(b)
+	if err != nil {
+		panic(valuePanic(err.Error()))
+	}
+	return e.EvalValueContext(ctx, env)
+}
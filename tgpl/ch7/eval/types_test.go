@@ -0,0 +1,199 @@
+package eval
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCheckType(t *testing.T) {
+	types := TypeEnv{"n": Int, "x": Float, "z": Complex, "b": Bool}
+	tests := []struct {
+		expr string
+		want string // a type or an error
+	}{
+		{"1", "int"},
+		{"1.5", "float"},
+		{"1e21", "float"},
+		{"n + 1", "int"},
+		{"n / 2", "float"},
+		{"n * x", "float"},
+		{"y", "float"}, // not in types
+		{"z * z + n", "complex"},
+		{"-z", "complex"},
+		{"abs(z) > 2", "bool"},
+		{"real(z) + imag(z)", "float"},
+		{"pow(z, 2)", "complex"},
+		{"sqrt(n)", "float"},
+		{"z == 1 || b", "bool"},
+		{"b ? n : x", "float"},
+		{"b ? 1 : z", "complex"},
+		{"n > 0 ? b : !b", "bool"},
+		{"let w = z * z; f(t) = t + 1; f(w)", "complex"},
+		{"b + 1", "operator + not defined on bool"},
+		{"-b", "operator - not defined on bool"},
+		{"!n", "operator ! not defined on int"},
+		{"x && b", "operator && not defined on float"},
+		{"z < 1", "operator < not defined on complex"},
+		{"b <= b", "operator <= not defined on bool"},
+		{"b == 1", "mismatched types bool and int for =="},
+		{"x ? 1 : 2", "condition of ?: has type float, want bool"},
+		{"b ? b : 1", "branches of ?: have types bool and int"},
+		{"sin(b)", "cannot use bool as argument of sin"},
+		{"floor(z)", "function floor not defined on complex"},
+		{"frob(z)", `unknown function "frob"`},
+		{"pow(z)", "call to pow has 1 args, want 2"},
+		{"complex(x, 1)", "complex"},
+		{"real(complex(n, n))", "float"},
+		{"complex(b, 1)", "cannot use bool as argument of complex"},
+		{"f(t) = f(t); f(z)", "recursive function f: f -> f"},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.expr, err)
+			continue
+		}
+		var got string
+		if typ, err := expr.CheckType(types); err != nil {
+			got = err.Error()
+		} else {
+			got = typ.String()
+		}
+		if got != test.want {
+			t.Errorf("%s.CheckType(...) = %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestEvalValue(t *testing.T) {
+	env := ValueEnv{
+		"n": IntValue(7),
+		"x": FloatValue(2.5),
+		"z": ComplexValue(1 + 2i),
+		"b": BoolValue(true),
+	}
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"n * 3 - 1", "20"},
+		{"n / 2", "3.5"},
+		{"n * x", "17.5"},
+		{"-n", "-7"},
+		{"z * z", "(-3+4i)"},
+		{"z / 2", "(0.5+1i)"},
+		{"abs(3 + 4 * sqrt(-1 + 0 * z))", "5"},
+		{"real(z) + imag(z)", "3"},
+		{"conj(z)", "(1-2i)"},
+		{"exp(z * 0)", "(1+0i)"},
+		{"pow(z, 0.5) == sqrt(z)", "true"},
+		{"sqrt(x * 0 - 4)", "NaN"},
+		{"b ? n : x", "7"},
+		{"!b ? n : x", "2.5"},
+		{"b ? 1 : z", "(1+0i)"},
+		{"n > 6 && z != 0", "true"},
+		{"n == 7.5 || !b", "false"},
+		{"x / 0 > 1", "true"},
+		{"let w = z * z; f(t) = t + n; f(w)", "(4+4i)"},
+		{"b ? n : !b ? x : z", "(7+0i)"},
+		{"complex(1, 2) * z", "(-3+4i)"},
+		{"complex(x, n) - z", "(1.5+5i)"},
+		{"n * 1000000000 * 1000000000", "7000000000000000000"},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.expr, err)
+			continue
+		}
+		typ, err := expr.CheckType(env.Types())
+		if err != nil {
+			t.Errorf("%s.CheckType(...): %v", test.expr, err)
+			continue
+		}
+		got := expr.EvalValue(env)
+		if got.String() != test.want {
+			t.Errorf("%s.EvalValue(...) = %s, want %s", test.expr, got, test.want)
+		}
+		if got.Type() != typ {
+			t.Errorf("%s.EvalValue(...) has type %s, but CheckType infers %s",
+				test.expr, got.Type(), typ)
+		}
+	}
+}
+
+func TestEvalTyped(t *testing.T) {
+	funcs := FuncTable{"sq": Func1(func(x float64) float64 { return x * x })}
+	env := ValueEnv{"m": IntValue(1 << 62), "x": FloatValue(2.5)}
+	tests := []struct {
+		expr  string
+		funcs FuncTable
+		want  string // a value or an error
+	}{
+		{"m * -2", nil, "-9223372036854775808"},
+		{"m + m", nil, "integer overflow in m + m"},
+		{"m * 2 + 1", nil, "integer overflow in m * 2"},
+		{"m * -2 - 1", nil, "integer overflow in m * -2 - 1"},
+		{"-(m * -2)", nil, "integer overflow in -(m * -2)"},
+		{"m + m > 0 ? 1 : 2", nil, "integer overflow in m + m"},
+		{"m + x + m", nil, "9.223372036854776e+18"}, // in floats
+		{"sq(x) + 1", funcs, "7.25"},
+		{"f(t) = sq(t) * 2; f(x)", funcs, "12.5"},
+		{"sin(x)", funcs, `unknown function "sin"`},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.expr, err)
+			continue
+		}
+		ctx := &Context{Funcs: test.funcs}
+		var got string
+		if _, err := expr.CheckTypeContext(ctx, env.Types()); err != nil {
+			got = err.Error()
+		} else if v, err := EvalTyped(expr, ctx, env); err != nil {
+			got = err.Error()
+		} else {
+			got = v.String()
+		}
+		if got != test.want {
+			t.Errorf("EvalTyped(%s) = %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
+
+// TestEvalValueMandelbrot drives the escape-time iteration of the
+// Mandelbrot set with an expression, as a fractal renderer would.
+func TestEvalValueMandelbrot(t *testing.T) {
+	step, err := Parse("z * z + c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	escaped, err := Parse("abs(z) > 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	iterations := func(c complex128) int {
+		env := ValueEnv{"z": ComplexValue(0), "c": ComplexValue(c)}
+		for n := 0; n < 200; n++ {
+			if escaped.EvalValue(env).Bool() {
+				return n
+			}
+			env["z"] = step.EvalValue(env)
+		}
+		return -1
+	}
+	for _, c := range []complex128{0, -1, 0.25, -2, 1i, 1, 2 + 2i, 0.5i - 0.75} {
+		want, z := -1, complex128(0)
+		for n := 0; n < 200; n++ {
+			if math.Hypot(real(z), imag(z)) > 2 {
+				want = n
+				break
+			}
+			z = z*z + c
+		}
+		if got := iterations(c); got != want {
+			t.Errorf("iterations(%v) = %d, want %d", c, got, want)
+		}
+	}
+}
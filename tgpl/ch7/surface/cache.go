@@ -0,0 +1,114 @@
+package main
+
+import "container/list"
+
+// A cache memoizes a Func as the Memo of ch9/memo5 does: a monitor
+// goroutine owns the entries, and the first request for a key starts a
+// goroutine that calls the Func while later requests for the key wait
+// for its result. Unlike that Memo, a cache keeps results only while
+// their sizes add up to at most its limit, evicting the least recently
+// used first, and forgets errors, so that a key that failed is tried
+// again, and results too big to keep.
+type cache struct {
+	requests chan request
+	done     chan *entry // whose results are ready
+}
+
+// A request is a message requesting that the Func be applied to key.
+type request struct {
+	key      string
+	response chan<- result // the client wants a single result
+}
+
+// Func is the type of the function to memoize.
+type Func func(key string) (*rendered, error)
+
+// result of calling a Func
+type result struct {
+	value *rendered
+	err   error
+}
+
+type entry struct {
+	key   string
+	res   result
+	ready chan struct{} // closed when res is ready
+	elem  *list.Element // in the LRU list once res is ready and kept
+}
+
+// newCache returns a cache of f that keeps results of up to maxBytes in
+// all. Clients must subsequently call Close.
+func newCache(f Func, maxBytes int) *cache {
+	c := &cache{requests: make(chan request), done: make(chan *entry)}
+	go c.server(f, maxBytes)
+	return c
+}
+
+func (c *cache) server(f Func, maxBytes int) {
+	entries := make(map[string]*entry)
+	lru := list.New() // of the entries kept, the most recently used first
+	size := 0         // of the results in lru
+	pending := 0      // calls of f not yet done
+	requests := c.requests
+	for requests != nil || pending > 0 { // until Close, then until the calls are done
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				requests = nil
+				continue
+			}
+			e := entries[req.key]
+			if e == nil {
+				// This is the first request for this key.
+				e = &entry{key: req.key, ready: make(chan struct{})}
+				entries[req.key] = e
+				pending++
+				go e.call(f, c.done)
+			} else if e.elem != nil {
+				lru.MoveToFront(e.elem)
+			}
+			go e.deliver(req.response)
+		case e := <-c.done:
+			pending--
+			if e.res.err != nil || e.res.value.size() > maxBytes {
+				delete(entries, e.key)
+				continue
+			}
+			e.elem = lru.PushFront(e)
+			size += e.res.value.size()
+			for size > maxBytes {
+				old := lru.Remove(lru.Back()).(*entry)
+				delete(entries, old.key)
+				size -= old.res.value.size()
+			}
+		}
+	}
+}
+
+func (e *entry) call(f Func, done chan<- *entry) {
+	// evaluate the function
+	e.res.value, e.res.err = f(e.key)
+	// tell the server, to keep or forget the result before any client
+	// has it, so that a later Get by the client finds the cache updated
+	done <- e
+	// broadcast the ready condition
+	close(e.ready)
+}
+
+func (e *entry) deliver(response chan<- result) {
+	// wait for the ready condition
+	<-e.ready
+	// send the result to the client
+	response <- e.res
+}
+
+// Get returns the result of the Func for key, calling it only if no
+// result for key is kept or on its way.
+func (c *cache) Get(key string) (*rendered, error) {
+	response := make(chan result)
+	c.requests <- request{key, response}
+	res := <-response
+	return res.value, res.err
+}
+
+func (c *cache) Close() { close(c.requests) }
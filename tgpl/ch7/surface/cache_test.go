@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// counter is a Func that renders key as itself, counting the calls for
+// each key.
+type counter struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (c *counter) render(key string) (*rendered, error) {
+	c.mu.Lock()
+	c.calls[key]++
+	c.mu.Unlock()
+	if key == "bad" {
+		return nil, fmt.Errorf("bad key")
+	}
+	return &rendered{body: []byte(key)}, nil
+}
+
+func (c *counter) count(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[key]
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	f := &counter{calls: make(map[string]int)}
+	c := newCache(f.render, 1<<10)
+	defer c.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if p, err := c.Get("key"); err != nil || string(p.body) != "key" {
+				t.Errorf(`Get("key") = %v, %v`, p, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := f.count("key"); n != 1 {
+		t.Errorf("%d calls for 20 concurrent Gets of a key, want 1", n)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	f := &counter{calls: make(map[string]int)}
+	c := newCache(f.render, 10) // room for two of the keys below
+	defer c.Close()
+	for _, key := range []string{
+		"aaaa", "bbbb", "aaaa", // a is used more recently than b
+		"cccc",         // so c evicts b
+		"aaaa", "cccc", // but not a
+		"bbbb",       // which b evicts in turn
+		"bad", "bad", // errors are not kept
+		"toolongtokeep", "toolongtokeep",
+	} {
+		c.Get(key)
+	}
+	for key, want := range map[string]int{"aaaa": 1, "bbbb": 2, "cccc": 1, "bad": 2, "toolongtokeep": 2} {
+		if got := f.count(key); got != want {
+			t.Errorf("%d calls for %s, want %d", got, key, want)
+		}
+	}
+	// b and c are kept, and a was evicted
+	for _, test := range []struct {
+		key   string
+		calls int
+	}{{"bbbb", 2}, {"cccc", 1}, {"aaaa", 2}} {
+		c.Get(test.key)
+		if got := f.count(test.key); got != test.calls {
+			t.Errorf("then %d calls for %s, want %d", got, test.key, test.calls)
+		}
+	}
+}
+
+func TestPlotETag(t *testing.T) {
+	plots = newCache(render, 1<<20)
+	defer plots.Close()
+	get := func(query, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/plot?"+query, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		plot(w, req)
+		return w
+	}
+
+	first := get("expr=sin(r)/r&cells=10", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || !strings.HasPrefix(first.Body.String(), "<svg") {
+		t.Fatalf("first request: status %d, ETag %q, body %.20q", first.Code, etag, first.Body)
+	}
+	for _, test := range []struct {
+		query, etag string
+		code        int
+	}{
+		{"expr=sin(r)/r&cells=10", etag, http.StatusNotModified},
+		{"expr=sin(r)%20/%20(r)&cells=10", etag, http.StatusNotModified}, // the same expression
+		{"cells=10&expr=sin(r)/r", `"other", W/` + etag, http.StatusNotModified},
+		{"expr=sin(r)/r&cells=10", "*", http.StatusNotModified},
+		{"expr=sin(r)/r&cells=10", `"other"`, http.StatusOK},
+		{"expr=sin(r)/r&cells=11", etag, http.StatusOK},
+	} {
+		w := get(test.query, test.etag)
+		if w.Code != test.code {
+			t.Errorf("%s with If-None-Match %s: status %d, want %d", test.query, test.etag, w.Code, test.code)
+		}
+		if w.Code == http.StatusNotModified && w.Body.Len() > 0 {
+			t.Errorf("%s with If-None-Match %s: body in a 304 response", test.query, test.etag)
+		}
+		if w.Code == http.StatusOK && w.Body.String() == first.Body.String() && test.query != "expr=sin(r)/r&cells=10" {
+			t.Errorf("%s: same plot as for the first request", test.query)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// A config holds the options of a plot, from the query parameters of
+// the same names or from a JSON body.
+type config struct {
+	Expr      string  `json:"expr"`
+	Width     int     `json:"width"` // canvas size in pixels
+	Height    int     `json:"height"`
+	Cells     int     `json:"cells"` // number of grid cells along each axis
+	XMin      float64 `json:"xmin"`  // x, y ranges of the grid
+	XMax      float64 `json:"xmax"`
+	YMin      float64 `json:"ymin"`
+	YMax      float64 `json:"ymax"`
+	Azimuth   float64 `json:"azimuth"`   // degrees anticlockwise from the x axis to the viewer
+	Elevation float64 `json:"elevation"` // degrees above the x-y plane to the viewer
+	Color     string  `json:"color"`     // "none" or "height"
+	Format    string  `json:"format"`    // a key of formats
+	Nonfinite string  `json:"nonfinite"` // "skip", "clamp" or "interpolate"
+}
+
+const (
+	maxSize  = 4096 // largest width or height
+	maxCells = 1000
+	maxBody  = 1 << 20 // largest JSON body in bytes
+)
+
+// defaultConfig is the isometric view of the book's surface: the grid
+// seen from above its x = y diagonal, at the elevation where the x and
+// y axes are drawn 30° from the horizontal.
+var defaultConfig = config{
+	Width:     600,
+	Height:    320,
+	Cells:     100,
+	XMin:      -15,
+	XMax:      15,
+	YMin:      -15,
+	YMax:      15,
+	Azimuth:   45,
+	Elevation: math.Asin(math.Tan(math.Pi/6)) * 180 / math.Pi,
+	Color:     "none",
+	Format:    "svg",
+	Nonfinite: "skip",
+}
+
+// parseConfig returns the config of the request r: the defaults,
+// overridden by a JSON body if r is a POST of one, then by the query
+// parameters.
+func parseConfig(w http.ResponseWriter, r *http.Request) (config, error) {
+	c := defaultConfig
+	if r.Method == http.MethodPost {
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "application/json" {
+			dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&c); err != nil {
+				return c, fmt.Errorf("bad JSON body: %v", err)
+			}
+		}
+	}
+	if err := r.ParseForm(); err != nil {
+		return c, err
+	}
+	var names []string
+	for name := range r.Form {
+		names = append(names, name)
+	}
+	sort.Strings(names) // report the same error for the same request
+	for _, name := range names {
+		if len(r.Form[name]) > 1 {
+			return c, fmt.Errorf("parameter %s given more than once", name)
+		}
+		if err := c.set(name, r.Form.Get(name)); err != nil {
+			return c, err
+		}
+	}
+	return c, c.validate()
+}
+
+// set sets the option name to value.
+func (c *config) set(name, value string) error {
+	var err error
+	intVar := func(p *int) { *p, err = strconv.Atoi(value) }
+	floatVar := func(p *float64) { *p, err = strconv.ParseFloat(value, 64) }
+	switch name {
+	case "expr":
+		c.Expr = value
+	case "width":
+		intVar(&c.Width)
+	case "height":
+		intVar(&c.Height)
+	case "cells":
+		intVar(&c.Cells)
+	case "xmin":
+		floatVar(&c.XMin)
+	case "xmax":
+		floatVar(&c.XMax)
+	case "ymin":
+		floatVar(&c.YMin)
+	case "ymax":
+		floatVar(&c.YMax)
+	case "azimuth":
+		floatVar(&c.Azimuth)
+	case "elevation":
+		floatVar(&c.Elevation)
+	case "color":
+		c.Color = value
+	case "format":
+		c.Format = value
+	case "nonfinite":
+		c.Nonfinite = value
+	default:
+		return fmt.Errorf("unknown parameter %s", name)
+	}
+	if err != nil {
+		return fmt.Errorf("bad %s %q", name, value)
+	}
+	return nil
+}
+
+// validate reports the first option of c that is out of range.
+func (c *config) validate() error {
+	switch {
+	case c.Width < 1 || c.Width > maxSize:
+		return fmt.Errorf("width %d out of range [1, %d]", c.Width, maxSize)
+	case c.Height < 1 || c.Height > maxSize:
+		return fmt.Errorf("height %d out of range [1, %d]", c.Height, maxSize)
+	case c.Cells < 1 || c.Cells > maxCells:
+		return fmt.Errorf("cells %d out of range [1, %d]", c.Cells, maxCells)
+	case !finite(c.XMin, c.XMax, c.YMin, c.YMax, c.XMax-c.XMin, c.YMax-c.YMin):
+		return fmt.Errorf("x and y ranges must be finite")
+	case c.XMin >= c.XMax:
+		return fmt.Errorf("empty x range [%g, %g]", c.XMin, c.XMax)
+	case c.YMin >= c.YMax:
+		return fmt.Errorf("empty y range [%g, %g]", c.YMin, c.YMax)
+	case !finite(c.Azimuth):
+		return fmt.Errorf("azimuth must be finite")
+	case !(c.Elevation >= 0 && c.Elevation <= 90):
+		return fmt.Errorf("elevation %g out of range [0, 90]", c.Elevation)
+	case c.Color != "none" && c.Color != "height":
+		return fmt.Errorf("color %q is not none or height", c.Color)
+	case c.Nonfinite != "skip" && c.Nonfinite != "clamp" && c.Nonfinite != "interpolate":
+		return fmt.Errorf("nonfinite %q is not skip, clamp or interpolate", c.Nonfinite)
+	}
+	if _, ok := formats[c.Format]; !ok {
+		return fmt.Errorf("unknown format %q", c.Format)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	for _, test := range []struct {
+		query, body string // a POST of body as JSON if it is not empty
+		change      func(c *config)
+		err         string
+	}{
+		{"", "", func(c *config) {}, ""},
+		{"expr=x&width=800&height=600&cells=20", "", func(c *config) {
+			c.Expr, c.Width, c.Height, c.Cells = "x", 800, 600, 20
+		}, ""},
+		{"xmin=-1&xmax=1&ymin=0&ymax=2&azimuth=-30&elevation=90", "", func(c *config) {
+			c.XMin, c.XMax, c.YMin, c.YMax, c.Azimuth, c.Elevation = -1, 1, 0, 2, -30, 90
+		}, ""},
+		{"color=height&format=png&nonfinite=clamp", "", func(c *config) {
+			c.Color, c.Format, c.Nonfinite = "height", "png", "clamp"
+		}, ""},
+		{"", `{"expr": "y", "cells": 5, "format": "stl"}`, func(c *config) {
+			c.Expr, c.Cells, c.Format = "y", 5, "stl"
+		}, ""},
+		{"cells=7", `{"expr": "y", "cells": 5}`, func(c *config) { // the query wins
+			c.Expr, c.Cells = "y", 7
+		}, ""},
+
+		{"size=3", "", nil, "unknown parameter size"},
+		{"cells=3&cells=4", "", nil, "parameter cells given more than once"},
+		{"width=wide", "", nil, `bad width "wide"`},
+		{"xmin=1e999", "", nil, `bad xmin "1e999"`},
+		{"", `{"size": 3}`, nil, `bad JSON body: json: unknown field "size"`},
+		{"", `{"cells": "many"}`, nil, "bad JSON body"},
+		{"", `{"cells": 5`, nil, "bad JSON body: unexpected EOF"},
+		{"", `"` + strings.Repeat("x", maxBody) + `"`, nil, "bad JSON body"},
+	} {
+		req := httptest.NewRequest("GET", "/plot?"+test.query, nil)
+		if test.body != "" {
+			req = httptest.NewRequest("POST", "/plot?"+test.query, strings.NewReader(test.body))
+			req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		}
+		c, err := parseConfig(httptest.NewRecorder(), req)
+		if test.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("%q %.40q: error %v, want %s", test.query, test.body, err, test.err)
+			}
+			continue
+		}
+		want := defaultConfig
+		test.change(&want)
+		if err != nil || c != want {
+			t.Errorf("%q %.40q = %+v, %v, want %+v", test.query, test.body, c, err, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, test := range []struct {
+		change func(c *config)
+		err    string // "" if c is valid
+	}{
+		{func(c *config) {}, ""},
+		{func(c *config) { c.Width, c.Height, c.Cells = 1, maxSize, maxCells }, ""},
+		{func(c *config) { c.Elevation = 0 }, ""},
+		{func(c *config) { c.Width = 0 }, "width 0 out of range [1, 4096]"},
+		{func(c *config) { c.Width = maxSize + 1 }, "width 4097 out of range [1, 4096]"},
+		{func(c *config) { c.Height = -1 }, "height -1 out of range [1, 4096]"},
+		{func(c *config) { c.Cells = 0 }, "cells 0 out of range [1, 1000]"},
+		{func(c *config) { c.Cells = maxCells + 1 }, "cells 1001 out of range [1, 1000]"},
+		{func(c *config) { c.XMin, c.XMax = -1e308, 1e308 }, "x and y ranges must be finite"},
+		{func(c *config) { c.XMin = c.XMax }, "empty x range [15, 15]"},
+		{func(c *config) { c.YMin, c.YMax = 1, 0 }, "empty y range [1, 0]"},
+		{func(c *config) { c.Elevation = -1 }, "elevation -1 out of range [0, 90]"},
+		{func(c *config) { c.Color = "red" }, `color "red" is not none or height`},
+		{func(c *config) { c.Nonfinite = "drop" }, `nonfinite "drop" is not skip, clamp or interpolate`},
+		{func(c *config) { c.Format = "gif" }, `unknown format "gif"`},
+	} {
+		c := defaultConfig
+		test.change(&c)
+		err := c.validate()
+		if test.err == "" && err != nil || test.err != "" && (err == nil || err.Error() != test.err) {
+			t.Errorf("validate of %+v = %v, want %q", c, err, test.err)
+		}
+	}
+}
+
+func TestPlotBadRequest(t *testing.T) {
+	plots = newCache(render, 1<<20)
+	defer plots.Close()
+	for _, test := range []struct {
+		query, body string
+	}{
+		{"expr=x&cells=0", "cells 0 out of range [1, 1000]"},
+		{"expr=x&format=gif", `unknown format "gif"`},
+		{"expr=x&shading=flat", "unknown parameter shading"},
+		{"expr=x&expr=y", "parameter expr given more than once"},
+		{"", "bad expr: empty expression"},
+		{"expr=x%2B", "bad expr: "},
+		{"expr=sin(x,y)", "bad expr: call to sin has 2 args, want 1"},
+		{"expr=x*z", "bad expr: undefined variable: z"},
+	} {
+		w := httptest.NewRecorder()
+		plot(w, httptest.NewRequest("GET", "/plot?"+test.query, nil))
+		if w.Code != http.StatusBadRequest || !strings.HasPrefix(w.Body.String(), test.body) {
+			t.Errorf("%s: status %d, body %q, want %d %q", test.query, w.Code, w.Body, http.StatusBadRequest, test.body)
+		}
+	}
+}
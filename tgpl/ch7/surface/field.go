@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+)
+
+// A heightField holds the heights of a surface at the corners of the
+// cells of a grid, so that each is computed once for the cells around
+// it.
+type heightField struct {
+	n int       // cells along each side
+	z []float64 // height at corner (i, j) in z[i*(n+1)+j]
+}
+
+// newHeightField returns the heights at the corners of the grid of v of
+// the functions that newF returns. It divides the rows of corners into
+// a band for each of GOMAXPROCS goroutines, each calling newF for one
+// of its own, so that one need not be safe to call from several at
+// once.
+func newHeightField(newF func() func(x, y float64) float64, v *view) *heightField {
+	h := &heightField{n: v.Cells, z: make([]float64, (v.Cells+1)*(v.Cells+1))}
+	rows := h.n + 1
+	bands := runtime.GOMAXPROCS(0)
+	if bands > rows {
+		bands = rows
+	}
+	var wg sync.WaitGroup
+	for b := 0; b < bands; b++ {
+		wg.Add(1)
+		go func(lo, hi int) {
+			defer wg.Done()
+			f := newF()
+			for i := lo; i < hi; i++ {
+				for j := 0; j <= h.n; j++ {
+					x, y := v.xy(i, j)
+					h.z[i*(h.n+1)+j] = f(x, y)
+				}
+			}
+		}(rows*b/bands, rows*(b+1)/bands)
+	}
+	wg.Wait()
+	return h
+}
+
+func (h *heightField) at(i, j int) float64 { return h.z[i*(h.n+1)+j] }
+
+// zrange returns the least and greatest finite heights of h, or +Inf
+// and -Inf if there are none.
+func (h *heightField) zrange() (zmin, zmax float64) {
+	zmin, zmax = math.Inf(1), math.Inf(-1)
+	for _, z := range h.z {
+		if finite(z) {
+			zmin, zmax = math.Min(zmin, z), math.Max(zmax, z)
+		}
+	}
+	return zmin, zmax
+}
+
+// Repairs count the cells of a surface with a corner where it is not
+// finite.
+type repairs struct {
+	mode     string // the nonfinite option
+	repaired int    // cells drawn after clamping or interpolating
+	skipped  int    // cells left out
+}
+
+// String returns the counts of r, as in "interpolated=3 skipped=1".
+func (r repairs) String() string {
+	switch r.mode {
+	case "clamp":
+		return fmt.Sprintf("clamped=%d skipped=%d", r.repaired, r.skipped)
+	case "interpolate":
+		return fmt.Sprintf("interpolated=%d skipped=%d", r.repaired, r.skipped)
+	}
+	return fmt.Sprintf("skipped=%d", r.skipped)
+}
+
+// repair replaces the heights of h that are not finite as mode says:
+// "skip" leaves them, so that the cells around them are skipped;
+// "clamp" replaces infinities by the greatest or least finite height,
+// leaving NaNs; and "interpolate" replaces each by the mean of its
+// finite neighbors, spreading inward through regions with none.
+func (h *heightField) repair(mode string) repairs {
+	bad := make([]bool, len(h.z))
+	for k, z := range h.z {
+		bad[k] = !finite(z)
+	}
+	switch mode {
+	case "clamp":
+		zmin, zmax := h.zrange()
+		for k, z := range h.z {
+			if math.IsInf(z, 1) {
+				h.z[k] = zmax
+			} else if math.IsInf(z, -1) {
+				h.z[k] = zmin
+			}
+		}
+	case "interpolate":
+		h.interpolate()
+	}
+
+	r := repairs{mode: mode}
+	for i := 0; i < h.n; i++ {
+		for j := 0; j < h.n; j++ {
+			corners := []int{i*(h.n+1) + j, i*(h.n+1) + j + 1, (i+1)*(h.n+1) + j, (i+1)*(h.n+1) + j + 1}
+			affected, ok := false, true
+			for _, k := range corners {
+				affected = affected || bad[k]
+				ok = ok && finite(h.z[k])
+			}
+			switch {
+			case !ok:
+				r.skipped++
+			case affected:
+				r.repaired++
+			}
+		}
+	}
+	return r
+}
+
+// interpolate replaces each height of h that is not finite by the mean
+// of its finite neighbors, in rings outward from the finite heights:
+// those next to them first, then those next to these, and so on. It
+// visits each corner once, breadth first, computing the heights of a
+// ring before setting any of them.
+func (h *heightField) interpolate() {
+	queued := make([]bool, len(h.z))
+	var queue []int // of the corners, ring after ring
+	for k, z := range h.z {
+		if finite(z) {
+			queued[k] = true
+			queue = append(queue, k)
+		}
+	}
+	var ring []float64 // heights of the ring being computed
+	for start := 0; start < len(queue); {
+		end := len(queue)
+		for _, k := range queue[start:end] {
+			h.neighbors(k, func(n int) {
+				if !queued[n] {
+					queued[n] = true
+					queue = append(queue, n)
+				}
+			})
+		}
+		ring = ring[:0]
+		for _, k := range queue[end:] {
+			sum, n := 0.0, 0
+			h.neighbors(k, func(m int) {
+				if finite(h.z[m]) {
+					sum += h.z[m]
+					n++
+				}
+			})
+			ring = append(ring, sum/float64(n))
+		}
+		for i, k := range queue[end:] {
+			h.z[k] = ring[i]
+		}
+		start = end
+	}
+}
+
+// neighbors calls f with each of the corners of h above, below, left
+// and right of corner k.
+func (h *heightField) neighbors(k int, f func(n int)) {
+	i, j := k/(h.n+1), k%(h.n+1)
+	if i > 0 {
+		f(k - (h.n + 1))
+	}
+	if i < h.n {
+		f(k + h.n + 1)
+	}
+	if j > 0 {
+		f(k - 1)
+	}
+	if j < h.n {
+		f(k + 1)
+	}
+}
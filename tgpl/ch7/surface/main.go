@@ -1,51 +1,155 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
 	"exercises-the_go_programming_language/ch7/eval"
+	"flag"
 	"fmt"
-	"io"
+	"image/color"
+	"log"
 	"math"
 	"net/http"
+	"sort"
+	"strings"
 )
 
-const (
-	width, height = 600, 320            // canvas size in pixels
-	cells         = 100                 // number of grid cells
-	xyrange       = 30.0                // x, y axis range (-xyrange..+xyrange)
-	xyscale       = width / 2 / xyrange // pixels per x or y unit
-	zscale        = height * 0.4        // pixels per z unit
-)
+// A view projects the points (x, y, z) of a surface onto the canvas of
+// its config, looking from the azimuth and elevation there.
+type view struct {
+	config
+	x0, y0       float64 // center of the grid, drawn at the center of the canvas
+	xyscale      float64 // pixels per x or y unit
+	zscale       float64 // pixels per z unit
+	sinAz, cosAz float64
+	sinEl, cosEl float64
+}
+
+func newView(c config, zscale float64) *view {
+	v := &view{config: c, x0: (c.XMin + c.XMax) / 2, y0: (c.YMin + c.YMax) / 2, zscale: zscale}
+	v.sinAz, v.cosAz = math.Sincos(c.Azimuth * math.Pi / 180)
+	v.sinEl, v.cosEl = math.Sincos(c.Elevation * math.Pi / 180)
+
+	// fit the grid, drawn at z = 0, in 90% of the canvas
+	var across, along float64 // half extents on the canvas in x, y units
+	for _, x := range []float64{c.XMin, c.XMax} {
+		for _, y := range []float64{c.YMin, c.YMax} {
+			u, d := v.rotate(x, y)
+			across = math.Max(across, math.Abs(u))
+			along = math.Max(along, math.Abs(d)*v.sinEl)
+		}
+	}
+	v.xyscale = 0.9 * float64(c.Width) / 2 / across
+	if along > 0 {
+		v.xyscale = math.Min(v.xyscale, 0.9*float64(c.Height)/2/along)
+	}
+	return v
+}
+
+// rotate returns the coordinates of (x, y) from the center of the grid
+// across the line of sight, to the right, and along it, toward the
+// viewer.
+func (v *view) rotate(x, y float64) (across, toward float64) {
+	x, y = x-v.x0, y-v.y0
+	return x*v.sinAz - y*v.cosAz, x*v.cosAz + y*v.sinAz
+}
+
+// xy returns the point (x, y) at corner (i, j) of the grid.
+func (v *view) xy(i, j int) (x, y float64) {
+	x = v.XMin + (v.XMax-v.XMin)*float64(i)/float64(v.Cells)
+	y = v.YMin + (v.YMax-v.YMin)*float64(j)/float64(v.Cells)
+	return x, y
+}
+
+// corner returns corner (i, j) of the grid, on the surface of heights
+// h and on the canvas.
+func (v *view) corner(h *heightField, i, j int) point {
+	x, y := v.xy(i, j)
+	z := h.at(i, j)
+
+	// project (x,y,z) orthographically onto 2-D canvas (sx,sy)
+	u, d := v.rotate(x, y)
+	return point{
+		x: x, y: y, z: z,
+		sx:    float64(v.Width)/2 + u*v.xyscale,
+		sy:    float64(v.Height)/2 + d*v.sinEl*v.xyscale - z*v.cosEl*v.zscale,
+		depth: d*v.cosEl*v.xyscale + z*v.sinEl*v.zscale,
+	}
+}
+
+// surface draws the surface of heights h in the view v with r, leaving
+// out the cells with a corner where it is not finite, which rep counts.
+// It draws the cells from the farthest to the nearest, so that in
+// formats without a z-buffer the nearer hide the farther.
+func surface(r renderer, h *heightField, v *view, rep repairs) error {
+	zmin, zmax := h.zrange()
+	for _, k := range v.order() {
+		i, j := k/v.Cells, k%v.Cells
+		c := cell{fill: color.RGBA{0xff, 0xff, 0xff, 0xff}}
+		c.p[0] = v.corner(h, i+1, j)
+		c.p[1] = v.corner(h, i, j)
+		c.p[2] = v.corner(h, i, j+1)
+		c.p[3] = v.corner(h, i+1, j+1)
+		if !finite(c.p[0].z, c.p[1].z, c.p[2].z, c.p[3].z) {
+			continue
+		}
+		if v.Color == "height" {
+			c.fill = heightColor((c.p[0].z+c.p[1].z+c.p[2].z+c.p[3].z)/4, zmin, zmax)
+		}
+		r.cell(c)
+	}
+	return r.end(rep)
+}
 
-var sin30, cos30 = 0.5, math.Sqrt(3.0 / 4.0) // sin(30°), cos(30°)
-
-func corner(f func(x, y float64) float64, i, j int) (float64, float64) {
-	// find point (x,y) at corner of cell (i,j)
-	x := xyrange * (float64(i)/cells - 0.5)
-	y := xyrange * (float64(j)/cells - 0.5)
-
-	z := f(x, y) // compute surface height z
-
-	// project (x,y,z) isometrically onto 2-D SVG canvas (sx,sy)
-	sx := width/2 + (x-y)*cos30*xyscale
-	sy := height/2 + (x+y)*sin30*xyscale - z*zscale
-	return sx, sy
-}
-
-func surface(w io.Writer, f func(x, y float64) float64) {
-	fmt.Fprintf(w, "<svg xmlns='http://www.w3.org/2000/svg' "+
-		"style='stroke: grey; fill: white; stroke-width: 0.7' "+
-		"width='%d' height='%d'>", width, height)
-	for i := 0; i < cells; i++ {
-		for j := 0; j < cells; j++ {
-			ax, ay := corner(f, i+1, j)
-			bx, by := corner(f, i, j)
-			cx, cy := corner(f, i, j+1)
-			dx, dy := corner(f, i+1, j+1)
-			fmt.Fprintf(w, "<polygon points='%g,%g %g,%g %g,%g %g,%g'/>\n",
-				ax, ay, bx, by, cx, cy, dx, dy)
+// order returns the numbers i*Cells + j of the cells (i, j) of the grid
+// of v in the painter's order: by the distance toward the viewer of
+// their centers on the plane z = 0, farthest first. Seen from above,
+// that puts each cell before any that can hide it.
+func (v *view) order() []int {
+	// the distance toward the viewer, less a constant, is a*i + b*j
+	a := (v.XMax - v.XMin) / float64(v.Cells) * v.cosAz
+	b := (v.YMax - v.YMin) / float64(v.Cells) * v.sinAz
+	toward := func(k int) float64 { return a*float64(k/v.Cells) + b*float64(k%v.Cells) }
+	cells := make([]int, v.Cells*v.Cells)
+	for k := range cells {
+		cells[k] = k
+	}
+	sort.Slice(cells, func(m, n int) bool {
+		tm, tn := toward(cells[m]), toward(cells[n])
+		return tm < tn || tm == tn && cells[m] < cells[n] // the same order every time
+	})
+	return cells
+}
+
+// heightColor returns the fill of a cell of height z, from blue at zmin
+// to red at zmax.
+func heightColor(z, zmin, zmax float64) color.RGBA {
+	t := 0.5
+	if zmax > zmin {
+		t = math.Max(0, math.Min(1, (z-zmin)/(zmax-zmin)))
+	}
+	return color.RGBA{uint8(255*t + 0.5), 0, uint8(255*(1-t) + 0.5), 0xff}
+}
+
+func finite(vs ...float64) bool {
+	for _, v := range vs {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return false
 		}
 	}
-	fmt.Fprintln(w, "</svg>")
+	return true
+}
+
+// zscaleFor returns the pixels per z unit that make the highest or
+// lowest point of a surface with heights in bounds zheight pixels from
+// z = 0, or the scale for heights in [-1, 1] if bounds is not finite.
+func zscaleFor(bounds eval.Interval, zheight float64) float64 {
+	zmax := math.Max(math.Abs(bounds.Lo), math.Abs(bounds.Hi))
+	if bounds.Singular || !bounds.Bounded() || zmax == 0 {
+		return zheight
+	}
+	return zheight / zmax
 }
 
 func parseAndCheck(s string) (eval.Expr, error) {
@@ -68,28 +172,154 @@ func parseAndCheck(s string) (eval.Expr, error) {
 	return expr, nil
 }
 
+var cacheBytes = flag.Int("cache", 64<<20, "bytes of plots to keep")
+
+// plots holds the recent plots by their canonical configs.
+var plots *cache
+
+// A rendered plot.
+type rendered struct {
+	body        []byte
+	contentType string
+	nonfinite   string // the X-Nonfinite-Cells header
+	etag        string
+}
+
+func (p *rendered) size() int { return len(p.body) }
+
 func plot(w http.ResponseWriter, r *http.Request) {
-	r.ParseForm()
-	expr, err := parseAndCheck(r.Form.Get("expr"))
+	c, err := parseConfig(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	expr, err := parseAndCheck(c.Expr)
 	if err != nil {
 		http.Error(w, "bad expr: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// the same plot whatever the spacing and parentheses of the expression
+	c.Expr = expr.String()
+	key, err := json.Marshal(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	w.Header().Set("Content-Type", "image/svg+xml")
+	p, err := plots.Get(string(key))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", p.etag)
+	if matchETag(r.Header.Get("If-None-Match"), p.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", p.contentType)
+	w.Header().Set("X-Nonfinite-Cells", p.nonfinite)
+	w.Write(p.body)
+}
 
-	fmt.Printf("%#v\n", expr)
+// matchETag reports whether the If-None-Match header h lists etag.
+func matchETag(h, etag string) bool {
+	for _, tag := range strings.Split(h, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/") // a weak match will do
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
 
-	surface(w, func(x, y float64) float64 {
-		r := math.Hypot(x, y)                              // distance from (0, 0)
-		return expr.Eval(eval.Env{"x": x, "y": y, "r": r}) // evaluate to result using given x, y, r
-	}) // anonymous function goes to z := f(x, y)
+// render renders the plot of key, a config in JSON whose expression
+// has been checked.
+func render(key string) (*rendered, error) {
+	var c config
+	if err := json.Unmarshal([]byte(key), &c); err != nil {
+		return nil, err
+	}
+	expr, err := eval.Parse(c.Expr)
+	if err != nil {
+		return nil, err
+	}
+
+	// compile once rather than walking the tree (and looking up x, y, r in an Env) for every corner
+	prog, err := eval.Compile(expr, []eval.Var{"x", "y", "r"})
+	if err != nil {
+		return nil, err
+	}
+
+	// bound the heights over the whole grid to scale them
+	x := eval.Interval{Lo: c.XMin, Hi: c.XMax}
+	y := eval.Interval{Lo: c.YMin, Hi: c.YMax}
+	bounds := expr.EvalInterval(eval.IntervalEnv{"x": x, "y": y, "r": radius(x, y)})
+	zheight := float64(c.Height) * 0.4 // pixels from z = 0 to the highest point
+
+	v := newView(c, zscaleFor(bounds, zheight))
+
+	h := newHeightField(func() func(x, y float64) float64 {
+		// slices of each band's own, since the bands are computed in
+		// parallel, and reused for its every corner
+		vals, scratch := make([]float64, 3), make([]float64, prog.ScratchLen())
+		return func(x, y float64) float64 {
+			vals[0], vals[1], vals[2] = x, y, math.Hypot(x, y) // r is the distance from (0, 0)
+			return prog.RunBuf(vals, scratch)
+		}
+	}, v) // anonymous function goes to z := f(x, y)
+	// repair where the surface is not finite, as for sin(r)/r at r = 0
+	rep := h.repair(c.Nonfinite)
+
+	format := formats[c.Format]
+	var buf bytes.Buffer
+	if err := surface(format.newRenderer(&buf, v), h, v, rep); err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return &rendered{
+		body:        buf.Bytes(),
+		contentType: format.contentType,
+		nonfinite:   rep.String(),
+		etag:        fmt.Sprintf(`"%x"`, sum[:16]),
+	}, nil
+}
+
+// radius returns the range of the distance from (0, 0) to the points
+// of the rectangle x × y.
+func radius(x, y eval.Interval) eval.Interval {
+	nearest := func(i eval.Interval) float64 { // to 0
+		return math.Max(0, math.Max(i.Lo, -i.Hi))
+	}
+	farthest := func(i eval.Interval) float64 {
+		return math.Max(math.Abs(i.Lo), math.Abs(i.Hi))
+	}
+	return eval.Interval{
+		Lo: math.Hypot(nearest(x), nearest(y)),
+		Hi: math.Hypot(farthest(x), farthest(y)),
+	}
 }
 
 func main() {
+	flag.Parse()
+	plots = newCache(render, *cacheBytes)
+	defer plots.Close()
 	http.HandleFunc("/plot", plot)
-	http.ListenAndServe("localhost:8000", nil)
+	log.Fatal(http.ListenAndServe("localhost:8000", nil))
 }
 
-// go run main.go
+// go run . -cache 100000000
 // localhost:8000/plot?expr=sin(-x)*pow(1.5,-r)
 // localhost:8000/plot?expr=pow(2,sin(y))*pow(2,sin(x))/12
 // localhost:8000/plot?expr=sin(x*y/10)/10
+// localhost:8000/plot?expr=sin(r)/r
+// localhost:8000/plot?expr=sin(r)/r&color=height&azimuth=20&elevation=50&cells=60
+// localhost:8000/plot?expr=x*x-y*y&xmin=-2&xmax=2&ymin=-2&ymax=2&width=800&height=600
+// localhost:8000/plot?expr=sin(r)/r&color=height&format=png
+// localhost:8000/plot?expr=sin(r)/r&nonfinite=interpolate
+// localhost:8000/plot?expr=1/(x*x-y*y)&nonfinite=clamp&color=height
+// localhost:8000/plot?expr=sin(x)*cos(y)&cells=50&format=stl
+//
+// curl -d '{"expr": "sin(x*y/10)/10", "color": "height"}' \
+//	-H 'Content-Type: application/json' localhost:8000/plot
+//
+// curl -i -H 'If-None-Match: "<the ETag of an earlier response>"' \
+//	'localhost:8000/plot?expr=sin(r)/r'
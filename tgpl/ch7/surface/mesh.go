@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// The mesh renderers write the surface itself rather than a picture of
+// it, for 3-D viewers and printers: a sheet of cells whose heights are
+// scaled as on the canvas, so that it has the shape of the plot. The
+// sheet is open, not a solid; a printer's slicer must thicken it.
+
+// vertex returns the coordinates of p in the mesh.
+func vertex(p point, zratio float64) [3]float64 {
+	return [3]float64{p.x, p.y, p.z * zratio}
+}
+
+// upward returns the corners of c anticlockwise as seen from above, so
+// that the normals of the faces of the mesh point up.
+func upward(c cell) [4]point {
+	return [4]point{c.p[0], c.p[3], c.p[2], c.p[1]}
+}
+
+// An objRenderer writes the surface as a Wavefront OBJ mesh of
+// quadrilateral faces, sharing the vertices of neighboring cells.
+type objRenderer struct {
+	w      io.Writer
+	zratio float64            // of z units to x and y units in the mesh
+	index  map[[3]float64]int // of each vertex written, from 1
+}
+
+func newOBJ(w io.Writer, v *view) renderer {
+	fmt.Fprintln(w, "o surface")
+	return &objRenderer{w, v.zscale / v.xyscale, make(map[[3]float64]int)}
+}
+
+func (r *objRenderer) cell(c cell) {
+	var face [4]int
+	for i, p := range upward(c) {
+		v := vertex(p, r.zratio)
+		n, ok := r.index[v]
+		if !ok {
+			n = len(r.index) + 1
+			r.index[v] = n
+			fmt.Fprintf(r.w, "v %g %g %g\n", v[0], v[1], v[2])
+		}
+		face[i] = n
+	}
+	fmt.Fprintf(r.w, "f %d %d %d %d\n", face[0], face[1], face[2], face[3])
+}
+
+func (r *objRenderer) end(rep repairs) error {
+	if rep.repaired > 0 || rep.skipped > 0 {
+		fmt.Fprintf(r.w, "# cells where the surface is not finite: %s\n", rep)
+	}
+	return nil
+}
+
+// An stlRenderer writes the surface as an ASCII STL mesh of two
+// triangles per cell.
+type stlRenderer struct {
+	w      io.Writer
+	zratio float64 // of z units to x and y units in the mesh
+}
+
+func newSTL(w io.Writer, v *view) renderer {
+	fmt.Fprintln(w, "solid surface")
+	return &stlRenderer{w, v.zscale / v.xyscale}
+}
+
+func (r *stlRenderer) cell(c cell) {
+	p := upward(c)
+	r.facet(vertex(p[0], r.zratio), vertex(p[1], r.zratio), vertex(p[2], r.zratio))
+	r.facet(vertex(p[2], r.zratio), vertex(p[3], r.zratio), vertex(p[0], r.zratio))
+}
+
+// facet writes the triangle a, b, c, anticlockwise about its normal.
+func (r *stlRenderer) facet(a, b, c [3]float64) {
+	var u, v, n [3]float64
+	for i := range a {
+		u[i], v[i] = b[i]-a[i], c[i]-a[i]
+	}
+	n[0] = u[1]*v[2] - u[2]*v[1]
+	n[1] = u[2]*v[0] - u[0]*v[2]
+	n[2] = u[0]*v[1] - u[1]*v[0]
+	if l := math.Sqrt(n[0]*n[0] + n[1]*n[1] + n[2]*n[2]); l > 0 {
+		n[0], n[1], n[2] = n[0]/l, n[1]/l, n[2]/l
+	}
+	fmt.Fprintf(r.w, "facet normal %g %g %g\n outer loop\n", n[0], n[1], n[2])
+	for _, p := range [][3]float64{a, b, c} {
+		fmt.Fprintf(r.w, "  vertex %g %g %g\n", p[0], p[1], p[2])
+	}
+	fmt.Fprintln(r.w, " endloop\nendfacet")
+}
+
+func (r *stlRenderer) end(rep repairs) error {
+	_, err := fmt.Fprintln(r.w, "endsolid surface")
+	return err
+}
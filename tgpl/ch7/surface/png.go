@@ -0,0 +1,116 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+)
+
+var grey = color.RGBA{0x80, 0x80, 0x80, 0xff} // of the edges of the cells
+
+// A pngRenderer paints the cells into an image, keeping for each pixel
+// the depth of the nearest cell painted there so that a cell hidden
+// behind others is hidden whatever the order of drawing.
+type pngRenderer struct {
+	w      io.Writer
+	img    *image.RGBA
+	zbuf   []float64 // depth of each pixel, row by row
+	stroke float64   // half the width of the edges in pixels
+}
+
+func newPNG(w io.Writer, v *view) renderer {
+	r := &pngRenderer{
+		w:      w,
+		img:    image.NewRGBA(image.Rect(0, 0, v.Width, v.Height)),
+		zbuf:   make([]float64, v.Width*v.Height),
+		stroke: 0.35, // as in the SVG
+	}
+	for i := range r.zbuf {
+		r.zbuf[i] = math.Inf(-1)
+	}
+	return r
+}
+
+// cell paints c as two triangles: a mask of the pixels of each that are
+// nearer than what is there already, through which draw fills the cell,
+// and another of the pixels near its edges, through which it draws them.
+func (r *pngRenderer) cell(c cell) {
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range c.p {
+		minX, maxX = math.Min(minX, p.sx), math.Max(maxX, p.sx)
+		minY, maxY = math.Min(minY, p.sy), math.Max(maxY, p.sy)
+	}
+	if !finite(minX, minY, maxX, maxY) {
+		return
+	}
+	rect := image.Rect(int(math.Floor(minX)), int(math.Floor(minY)),
+		int(math.Ceil(maxX))+1, int(math.Ceil(maxY))+1).Intersect(r.img.Bounds())
+	if rect.Empty() {
+		return
+	}
+	fill, edges := image.NewAlpha(rect), image.NewAlpha(rect)
+	// the diagonal between the triangles is not an edge of the cell
+	r.triangle(fill, edges, [3]point{c.p[0], c.p[1], c.p[2]}, [3]bool{false, true, true})
+	r.triangle(fill, edges, [3]point{c.p[2], c.p[3], c.p[0]}, [3]bool{false, true, true})
+	draw.DrawMask(r.img, rect, image.NewUniform(c.fill), image.Point{}, fill, rect.Min, draw.Over)
+	draw.DrawMask(r.img, rect, image.NewUniform(grey), image.Point{}, edges, rect.Min, draw.Over)
+}
+
+// triangle marks in fill the pixels of the triangle p, within the
+// bounds of fill, that are nearer than the z-buffer, updating it, and
+// marks in edges those of them near the sides opposite each vertex i
+// for which stroke[i] is set.
+func (r *pngRenderer) triangle(fill, edges *image.Alpha, p [3]point, stroke [3]bool) {
+	// twice the signed area, and the heights of the vertices over the
+	// opposite sides, in pixels
+	area := (p[1].sx-p[0].sx)*(p[2].sy-p[0].sy) - (p[2].sx-p[0].sx)*(p[1].sy-p[0].sy)
+	if area == 0 {
+		return
+	}
+	var height [3]float64
+	for i := range p {
+		b, c := p[(i+1)%3], p[(i+2)%3]
+		height[i] = math.Abs(area) / math.Hypot(c.sx-b.sx, c.sy-b.sy)
+	}
+	rect := fill.Bounds()
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			// barycentric coordinates of the center of the pixel
+			px, py := float64(x)+0.5, float64(y)+0.5
+			var l [3]float64
+			inside := true
+			for i := range p {
+				b, c := p[(i+1)%3], p[(i+2)%3]
+				l[i] = ((c.sx-b.sx)*(py-b.sy) - (px-b.sx)*(c.sy-b.sy)) / area
+				inside = inside && l[i] >= 0
+			}
+			if !inside {
+				continue
+			}
+			depth := l[0]*p[0].depth + l[1]*p[1].depth + l[2]*p[2].depth
+			k := y*r.img.Rect.Dx() + x
+			if depth <= r.zbuf[k] {
+				continue
+			}
+			r.zbuf[k] = depth
+			fill.SetAlpha(x, y, color.Alpha{0xff})
+			edge := false
+			for i := range p {
+				edge = edge || stroke[i] && l[i]*height[i] < r.stroke
+			}
+			if edge {
+				edges.SetAlpha(x, y, color.Alpha{0xff})
+			} else {
+				edges.SetAlpha(x, y, color.Alpha{}) // over an edge of the other triangle
+			}
+		}
+	}
+}
+
+func (r *pngRenderer) end(rep repairs) error {
+	return png.Encode(r.w, r.img)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// renderWith renders the plot of the default config changed by change.
+func renderWith(t *testing.T, change func(c *config)) *rendered {
+	t.Helper()
+	c := defaultConfig
+	change(&c)
+	key, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := render(string(key))
+	if err != nil {
+		t.Fatalf("render of %+v: %v", c, err)
+	}
+	if want := formats[c.Format].contentType; p.contentType != want {
+		t.Errorf("%s: content type %s, want %s", c.Format, p.contentType, want)
+	}
+	return p
+}
+
+// fields returns the lines of text split into fields, without any
+// that are empty.
+func fields(text string) [][]string {
+	var lines [][]string
+	for in := bufio.NewScanner(strings.NewReader(text)); in.Scan(); {
+		if f := strings.Fields(in.Text()); len(f) > 0 {
+			lines = append(lines, f)
+		}
+	}
+	return lines
+}
+
+func TestPNG(t *testing.T) {
+	for _, size := range [][2]int{{600, 320}, {1, 1}, {50, 200}} {
+		p := renderWith(t, func(c *config) {
+			c.Expr, c.Format, c.Width, c.Height, c.Cells = "sin(r)/r", "png", size[0], size[1], 20
+		})
+		img, err := png.Decode(bytes.NewReader(p.body))
+		if err != nil {
+			t.Fatalf("%dx%d: %v", size[0], size[1], err)
+		}
+		if b := img.Bounds(); b.Dx() != size[0] || b.Dy() != size[1] {
+			t.Errorf("%dx%d: image of %v", size[0], size[1], b)
+		}
+	}
+
+	// seen from straight above, a flat plot fills the middle of the
+	// canvas, white within the cells and grey on their edges
+	p := renderWith(t, func(c *config) {
+		c.Expr, c.Format, c.Width, c.Height, c.Cells = "0", "png", 101, 101, 2
+		c.Azimuth, c.Elevation = 90, 90
+	})
+	img, err := png.Decode(bytes.NewReader(p.body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		x, y int
+		want string
+	}{
+		{2, 2, "0 0 0 0"}, // outside the grid
+		{25, 25, "255 255 255 255"},
+		{50, 50, "128 128 128 255"}, // where the cells meet, at the center
+	} {
+		r, g, b, a := img.At(test.x, test.y).RGBA()
+		if got := fmt.Sprint(r>>8, g>>8, b>>8, a>>8); got != test.want {
+			t.Errorf("pixel (%d, %d) = %s, want %s", test.x, test.y, got, test.want)
+		}
+	}
+}
+
+func TestOBJ(t *testing.T) {
+	for _, cells := range []int{1, 3, 10} {
+		p := renderWith(t, func(c *config) { c.Expr, c.Format, c.Cells = "x*y/100", "obj", cells })
+		lines := fields(string(p.body))
+		if len(lines) == 0 || strings.Join(lines[0], " ") != "o surface" {
+			t.Fatalf("cells=%d: OBJ does not begin with o surface", cells)
+		}
+		var vertices, faces int
+		for _, f := range lines[1:] {
+			switch f[0] {
+			case "v":
+				vertices++
+				if len(f) != 4 {
+					t.Errorf("cells=%d: vertex %q", cells, f)
+				}
+			case "f":
+				faces++
+				if len(f) != 5 {
+					t.Errorf("cells=%d: face %q", cells, f)
+				}
+				for _, s := range f[1:] {
+					var n int
+					if _, err := fmt.Sscan(s, &n); err != nil || n < 1 || n > vertices {
+						t.Errorf("cells=%d: face %q refers to no vertex yet", cells, f)
+					}
+				}
+			default:
+				t.Errorf("cells=%d: unexpected line %q", cells, f)
+			}
+		}
+		// the cells share their corners
+		if vertices != (cells+1)*(cells+1) || faces != cells*cells {
+			t.Errorf("cells=%d: %d vertices and %d faces, want %d and %d",
+				cells, vertices, faces, (cells+1)*(cells+1), cells*cells)
+		}
+	}
+}
+
+func TestSTL(t *testing.T) {
+	for _, cells := range []int{1, 3, 10} {
+		p := renderWith(t, func(c *config) { c.Expr, c.Format, c.Cells = "0", "stl", cells })
+		lines := fields(string(p.body))
+		if len(lines) < 2 || strings.Join(lines[0], " ") != "solid surface" ||
+			strings.Join(lines[len(lines)-1], " ") != "endsolid surface" {
+			t.Fatalf("cells=%d: STL not within solid surface and endsolid surface", cells)
+		}
+		var facets, vertices int
+		for _, f := range lines[1 : len(lines)-1] {
+			switch f[0] {
+			case "facet":
+				facets++
+				// the plane z = 0, facing up
+				var n [3]float64
+				if _, err := fmt.Sscan(strings.Join(f[2:], " "), &n[0], &n[1], &n[2]); err != nil || n != [3]float64{0, 0, 1} {
+					t.Errorf("cells=%d: %q", cells, f)
+				}
+			case "vertex":
+				vertices++
+			case "outer", "endloop", "endfacet":
+			default:
+				t.Errorf("cells=%d: unexpected line %q", cells, f)
+			}
+		}
+		if facets != 2*cells*cells || vertices != 3*facets {
+			t.Errorf("cells=%d: %d facets and %d vertices, want %d and %d",
+				cells, facets, vertices, 2*cells*cells, 6*cells*cells)
+		}
+	}
+}
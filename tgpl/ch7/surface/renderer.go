@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+)
+
+// A point is a corner of a cell, on the surface and on the canvas.
+type point struct {
+	x, y, z float64 // on the surface
+	sx, sy  float64 // on the canvas
+	depth   float64 // toward the viewer, in pixels
+}
+
+// A cell is a quadrilateral of the surface.
+type cell struct {
+	p    [4]point   // corners, in order around the cell
+	fill color.RGBA // white unless the plot is colored by height
+}
+
+// A renderer draws the cells of a surface, in the order given, to the
+// writer it was made with.
+type renderer interface {
+	cell(c cell)
+	// end finishes the drawing, noting the cells where the surface
+	// was not finite at a corner.
+	end(rep repairs) error
+}
+
+// formats maps the values of the format option to their renderers.
+var formats = map[string]struct {
+	contentType string
+	newRenderer func(w io.Writer, v *view) renderer
+}{
+	"svg": {"image/svg+xml", newSVG},
+	"png": {"image/png", newPNG},
+	"obj": {"model/obj", newOBJ},
+	"stl": {"model/stl", newSTL},
+}
+
+// An svgRenderer draws each cell as an SVG polygon.
+type svgRenderer struct {
+	w     io.Writer
+	color bool // whether to fill the polygons
+}
+
+func newSVG(w io.Writer, v *view) renderer {
+	fmt.Fprintf(w, "<svg xmlns='http://www.w3.org/2000/svg' "+
+		"style='stroke: grey; fill: white; stroke-width: 0.7' "+
+		"width='%d' height='%d'>", v.Width, v.Height)
+	return &svgRenderer{w, v.Color != "none"}
+}
+
+func (r *svgRenderer) cell(c cell) {
+	style := ""
+	if r.color {
+		style = fmt.Sprintf(" style='fill: #%02x%02x%02x'", c.fill.R, c.fill.G, c.fill.B)
+	}
+	a, b, cc, d := c.p[0], c.p[1], c.p[2], c.p[3]
+	fmt.Fprintf(r.w, "<polygon points='%g,%g %g,%g %g,%g %g,%g'%s/>\n",
+		a.sx, a.sy, b.sx, b.sy, cc.sx, cc.sy, d.sx, d.sy, style)
+}
+
+func (r *svgRenderer) end(rep repairs) error {
+	if rep.repaired > 0 || rep.skipped > 0 {
+		fmt.Fprintf(r.w, "<!-- cells where the surface is not finite: %s -->\n", rep)
+	}
+	_, err := fmt.Fprintln(r.w, "</svg>")
+	return err
+}
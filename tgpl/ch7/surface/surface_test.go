@@ -0,0 +1,253 @@
+package main
+
+import (
+	"exercises-the_go_programming_language/ch7/eval"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// heights returns a maker of height functions of the surface of input,
+// as newHeightField takes.
+func heights(t testing.TB, input string) func() func(x, y float64) float64 {
+	expr, err := parseAndCheck(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := eval.Compile(expr, []eval.Var{"x", "y", "r"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() func(x, y float64) float64 {
+		vals, scratch := make([]float64, 3), make([]float64, prog.ScratchLen())
+		return func(x, y float64) float64 {
+			vals[0], vals[1], vals[2] = x, y, math.Hypot(x, y)
+			return prog.RunBuf(vals, scratch)
+		}
+	}
+}
+
+func TestHeightField(t *testing.T) {
+	newF := heights(t, "sin(x*y/10)/10")
+	f := newF()
+	for _, cells := range []int{1, 2, 7, 100} {
+		c := defaultConfig
+		c.Cells = cells
+		v := newView(c, 1)
+		h := newHeightField(newF, v)
+		for i := 0; i <= cells; i++ {
+			for j := 0; j <= cells; j++ {
+				if x, y := v.xy(i, j); h.at(i, j) != f(x, y) {
+					t.Fatalf("cells=%d: height at corner (%d, %d) = %g, want %g",
+						cells, i, j, h.at(i, j), f(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestRepair(t *testing.T) {
+	// on a grid of 4 cells along each side, x is -15, -7.5, 0, 7.5 and
+	// 15 at the corners
+	for _, test := range []struct {
+		expr, mode string
+		want       string
+		row2       float64 // height at the corners where x = 0 after repair, if finite
+	}{
+		{"x", "skip", "skipped=0", 0},
+		{"x", "interpolate", "interpolated=0 skipped=0", 0},
+		{"1/x", "skip", "skipped=8", math.Inf(1)},
+		{"1/x", "clamp", "clamped=8 skipped=0", 1 / 7.5},
+		{"1/x", "interpolate", "interpolated=8 skipped=0", 0}, // the mean of 1/-7.5 and 1/7.5
+		{"-1/(x*x)", "clamp", "clamped=8 skipped=0", -1 / 56.25},
+		{"sqrt(x)", "skip", "skipped=8", 0},
+		{"sqrt(x)", "clamp", "clamped=0 skipped=8", 0}, // NaNs are left
+		{"sqrt(x)", "interpolate", "interpolated=8 skipped=0", 0},
+		{"sqrt(-1)", "interpolate", "interpolated=0 skipped=16", math.NaN()},
+	} {
+		c := defaultConfig
+		c.Cells = 4
+		v := newView(c, 1)
+		h := newHeightField(heights(t, test.expr), v)
+		rep := h.repair(test.mode)
+		if got := rep.String(); got != test.want {
+			t.Errorf("%s with %s: %s, want %s", test.expr, test.mode, got, test.want)
+		}
+		for j := 0; j <= c.Cells; j++ {
+			if z := h.at(2, j); z != test.row2 && !(math.IsNaN(z) && math.IsNaN(test.row2)) {
+				t.Errorf("%s with %s: height %g at corner (2, %d), want %g", test.expr, test.mode, z, j, test.row2)
+				break
+			}
+		}
+	}
+}
+
+// passes interpolates the heights of h as repair did in passes over
+// the whole grid, each filling in one ring.
+func passes(h *heightField) {
+	for changed := true; changed; {
+		changed = false
+		next := append([]float64(nil), h.z...)
+		for k := range h.z {
+			if finite(h.z[k]) {
+				continue
+			}
+			sum, n := 0.0, 0
+			h.neighbors(k, func(m int) {
+				if finite(h.z[m]) {
+					sum += h.z[m]
+					n++
+				}
+			})
+			if n > 0 {
+				next[k] = sum / float64(n)
+				changed = true
+			}
+		}
+		h.z = next
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, p := range []float64{0, 0.01, 0.5, 0.99, 1} { // of a NaN
+		h := &heightField{n: 30, z: make([]float64, 31*31)}
+		for k := range h.z {
+			h.z[k] = rng.Float64()
+			if rng.Float64() < p {
+				h.z[k] = math.NaN()
+			}
+		}
+		want := &heightField{n: h.n, z: append([]float64(nil), h.z...)}
+		passes(want)
+		h.interpolate()
+		for k := range h.z {
+			if h.z[k] != want.z[k] && !(math.IsNaN(h.z[k]) && math.IsNaN(want.z[k])) {
+				t.Errorf("with NaNs at %g: height %g at corner %d, want %g", p, h.z[k], k, want.z[k])
+				break
+			}
+		}
+	}
+}
+
+func TestPlotNonfinite(t *testing.T) {
+	plots = newCache(render, 1<<20)
+	defer plots.Close()
+	for _, test := range []struct {
+		query, header, comment string // comment is "" if there should be none
+	}{
+		{"expr=x", "skipped=0", ""},
+		{"expr=1/x", "skipped=8", "skipped=8"},
+		{"expr=1/x&nonfinite=clamp", "clamped=8 skipped=0", "clamped=8 skipped=0"},
+		{"expr=sqrt(x)&nonfinite=interpolate", "interpolated=8 skipped=0", "interpolated=8 skipped=0"},
+		{"expr=1/x&format=png", "skipped=8", ""},
+		{"expr=1/x&format=obj&nonfinite=clamp", "clamped=8 skipped=0", ""},
+	} {
+		w := httptest.NewRecorder()
+		plot(w, httptest.NewRequest("GET", "/plot?cells=4&"+test.query, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d, %s", test.query, w.Code, w.Body)
+			continue
+		}
+		if got := w.Header().Get("X-Nonfinite-Cells"); got != test.header {
+			t.Errorf("%s: X-Nonfinite-Cells %q, want %q", test.query, got, test.header)
+		}
+		const prefix = "<!-- cells where the surface is not finite: "
+		body := w.Body.String()
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "image/svg") {
+			continue
+		}
+		if test.comment == "" {
+			if strings.Contains(body, "<!--") {
+				t.Errorf("%s: SVG has a comment", test.query)
+			}
+		} else if !strings.Contains(body, prefix+test.comment+" -->") {
+			t.Errorf("%s: SVG has no comment of %s", test.query, test.comment)
+		}
+	}
+}
+
+// TestOrder checks that each cell is drawn after its neighbors on the
+// far side, from every side.
+func TestOrder(t *testing.T) {
+	for az := 0.0; az < 360; az += 30 {
+		c := defaultConfig
+		c.Cells = 10
+		c.Azimuth = az
+		v := newView(c, 1)
+		pos := make(map[int]int) // of each cell in the order
+		for n, k := range v.order() {
+			pos[k] = n
+		}
+		if len(pos) != c.Cells*c.Cells {
+			t.Fatalf("azimuth %g: %d cells in order, want %d", az, len(pos), c.Cells*c.Cells)
+		}
+		for i := 0; i+1 < c.Cells; i++ {
+			for j := 0; j+1 < c.Cells; j++ {
+				k := i*c.Cells + j
+				// moving to i+1 goes toward the viewer if cos(az) > 0
+				if v.cosAz > 1e-9 && pos[k] > pos[k+c.Cells] || v.cosAz < -1e-9 && pos[k] < pos[k+c.Cells] {
+					t.Errorf("azimuth %g: cell (%d, %d) drawn in the wrong order with (%d, %d)", az, i, j, i+1, j)
+				}
+				if v.sinAz > 1e-9 && pos[k] > pos[k+1] || v.sinAz < -1e-9 && pos[k] < pos[k+1] {
+					t.Errorf("azimuth %g: cell (%d, %d) drawn in the wrong order with (%d, %d)", az, i, j, i, j+1)
+				}
+			}
+		}
+	}
+}
+
+var benchCells = []int{100, 500, 1000}
+
+// Run the benchmarks with -cpu 1,2,4 to compare the height fields
+// computed in one band and in several.
+func BenchmarkHeightField(b *testing.B) {
+	f := heights(b, "sin(r)/r")
+	for _, cells := range benchCells {
+		b.Run(fmt.Sprintf("cells=%d", cells), func(b *testing.B) {
+			c := defaultConfig
+			c.Cells = cells
+			v := newView(c, 1)
+			for i := 0; i < b.N; i++ {
+				newHeightField(f, v)
+			}
+		})
+	}
+}
+
+func BenchmarkSurface(b *testing.B) {
+	f := heights(b, "sin(r)/r")
+	for _, cells := range benchCells {
+		b.Run(fmt.Sprintf("cells=%d", cells), func(b *testing.B) {
+			c := defaultConfig
+			c.Cells = cells
+			v := newView(c, 1)
+			for i := 0; i < b.N; i++ {
+				h := newHeightField(f, v)
+				rep := h.repair(c.Nonfinite)
+				surface(newSVG(io.Discard, v), h, v, rep)
+			}
+		})
+	}
+}
+
+// BenchmarkInterpolate interpolates a surface with one finite corner,
+// from which the heights spread over 1000 rings.
+func BenchmarkInterpolate(b *testing.B) {
+	const cells = maxCells
+	z := make([]float64, (cells+1)*(cells+1))
+	for k := range z {
+		z[k] = math.NaN()
+	}
+	z[0] = 1
+	h := &heightField{n: cells, z: make([]float64, len(z))}
+	for i := 0; i < b.N; i++ {
+		copy(h.z, z)
+		h.repair("interpolate")
+	}
+}
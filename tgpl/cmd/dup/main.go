@@ -0,0 +1,164 @@
+// Dup prints each line that occurs at least -min times in the named
+// files, or the standard input if there are none or a name is "-",
+// with its count and where it occurs, in sorted order:
+//
+//	3	hello, world
+//		a.txt:4
+//		a.txt:9
+//		b.txt:1
+//
+// With -i lines differing only in case count as the same, and with -w
+// lines differing only in whitespace, and the first of them is printed.
+//
+// The files are read a line at a time. Lines beyond -mem megabytes are
+// sorted in runs on temporary files and merged from there, for inputs
+// larger than memory. So that no line's occurrences need be held to
+// print its count first, the sorted lines are read twice, once to count
+// each line and again, just behind, to print where it occurs. A file
+// that cannot be read is reported and skipped.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	fold     = flag.Bool("i", false, "ignore case in comparing lines")
+	space    = flag.Bool("w", false, "ignore whitespace at the ends of lines and how much separates words in comparing them")
+	minCount = flag.Int("min", 2, "fewest occurrences of a line to print it")
+	mem      = flag.Int("mem", 64, "megabytes of lines to sort in memory before spilling them to temporary files, or 0 for no limit")
+	tmpdir   = flag.String("tmpdir", "", "directory of the temporary files, by default the system's")
+)
+
+// key returns what line is compared by.
+func key(line string) string {
+	if *space {
+		line = strings.Join(strings.Fields(line), " ")
+	}
+	if *fold {
+		line = strings.ToLower(line)
+	}
+	return line
+}
+
+// readLines adds the lines read from r, of the file with the given
+// index, to s.
+func readLines(s *sorter, file int, r io.Reader) error {
+	in := bufio.NewReader(r) // rather than a Scanner, for lines of any length
+	for line := 1; ; line++ {
+		text, err := in.ReadString('\n')
+		if text == "" {
+			if err == io.EOF {
+				err = nil
+			}
+			return err
+		}
+		text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+		if err := s.add(record{key: key(text), text: text, file: file, line: line}); err != nil {
+			return err
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return err
+		}
+	}
+}
+
+// dup reads the files with the given names into s, reporting those it
+// cannot read to stderr, and prints the lines that occur at least min
+// times to out. It returns whether every file was read.
+func dup(out io.Writer, names []string, s *sorter, min int) (ok bool, err error) {
+	ok = true
+	for i, name := range names {
+		var err error
+		if name == "-" {
+			err = readLines(s, i, os.Stdin)
+		} else if f, openErr := os.Open(name); openErr != nil {
+			err = openErr
+		} else {
+			err = readLines(s, i, f)
+			f.Close()
+		}
+		if err != nil {
+			if _, isRun := err.(*runError); isRun {
+				return false, err // no use going on without temporary files
+			}
+			fmt.Fprintf(os.Stderr, "dup: %v\n", err)
+			ok = false
+		}
+	}
+
+	if err := s.finish(); err != nil {
+		return ok, err
+	}
+	ahead, err := s.open() // counts the records of a line
+	if err != nil {
+		return ok, err
+	}
+	defer ahead.close()
+	behind, err := s.open() // and then prints them
+	if err != nil {
+		return ok, err
+	}
+	defer behind.close()
+
+	// The records come sorted by key, so those of a line are together,
+	// the first of them first.
+	more := ahead.next()
+	for more {
+		first, n := ahead.rec, 0
+		for more && ahead.rec.key == first.key {
+			n++
+			more = ahead.next()
+		}
+		if n >= min {
+			fmt.Fprintf(out, "%d\t%s\n", n, first.text)
+		}
+		for i := 0; i < n && behind.next(); i++ {
+			if n >= min {
+				fmt.Fprintf(out, "\t%s:%d\n", names[behind.rec.file], behind.rec.line)
+			}
+		}
+	}
+	if ahead.err != nil {
+		return ok, ahead.err
+	}
+	return ok, behind.err
+}
+
+func main() {
+	flag.Parse()
+	if *minCount < 1 || *mem < 0 {
+		fmt.Fprintln(os.Stderr, "dup: need -min of 1 or more and -mem of 0 or more")
+		os.Exit(2)
+	}
+	names := flag.Args()
+	if len(names) == 0 {
+		names = []string{"-"}
+	}
+	s := &sorter{limit: int64(*mem) << 20, dir: *tmpdir}
+	out := bufio.NewWriter(os.Stdout)
+	ok, err := dup(out, names, s, *minCount)
+	if err == nil {
+		err = out.Flush()
+	}
+	s.close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dup: %v\n", err)
+		os.Exit(1)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// go run . a.txt b.txt
+// go run . -i -w -min=3 *.go
+// go run . -mem=64 huge.log | less
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// files writes the given contents to files in a temporary directory and
+// returns their names.
+func files(t *testing.T, contents ...string) []string {
+	dir := t.TempDir()
+	var names []string
+	for i, c := range contents {
+		name := filepath.Join(dir, fmt.Sprintf("%c.txt", 'a'+i))
+		if err := os.WriteFile(name, []byte(c), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// run runs dup on the files with the options, with their directory
+// removed from the output.
+func run(t *testing.T, names []string, limit int64, i, w bool, min int) string {
+	t.Helper()
+	*fold, *space = i, w
+	defer func() { *fold, *space = false, false }()
+	s := &sorter{limit: limit, dir: t.TempDir()}
+	defer s.close()
+	var out strings.Builder
+	if _, err := dup(&out, names, s, min); err != nil {
+		t.Fatal(err)
+	}
+	return strings.ReplaceAll(out.String(), filepath.Dir(names[0])+string(filepath.Separator), "")
+}
+
+func TestDup(t *testing.T) {
+	names := files(t,
+		"hello\nworld\nHello\nhello\n  hello  \n",
+		"world\nhello\r\nlast line, no newline")
+	for _, test := range []struct {
+		fold, space bool
+		min         int
+		want        string
+	}{
+		{false, false, 2, "3\thello\n\ta.txt:1\n\ta.txt:4\n\tb.txt:2\n" +
+			"2\tworld\n\ta.txt:2\n\tb.txt:1\n"},
+		{false, false, 3, "3\thello\n\ta.txt:1\n\ta.txt:4\n\tb.txt:2\n"},
+		{true, false, 4, "4\thello\n\ta.txt:1\n\ta.txt:3\n\ta.txt:4\n\tb.txt:2\n"},
+		{false, true, 4, "4\thello\n\ta.txt:1\n\ta.txt:4\n\ta.txt:5\n\tb.txt:2\n"},
+		{true, true, 5, "5\thello\n\ta.txt:1\n\ta.txt:3\n\ta.txt:4\n\ta.txt:5\n\tb.txt:2\n"},
+		{false, false, 1, "1\t  hello  \n\ta.txt:5\n" +
+			"1\tHello\n\ta.txt:3\n" +
+			"3\thello\n\ta.txt:1\n\ta.txt:4\n\tb.txt:2\n" +
+			"1\tlast line, no newline\n\tb.txt:3\n" +
+			"2\tworld\n\ta.txt:2\n\tb.txt:1\n"},
+	} {
+		for _, limit := range []int64{0, 1, 100} { // all in memory, a run per line, a few lines a run
+			got := run(t, names, limit, test.fold, test.space, test.min)
+			if got != test.want {
+				t.Errorf("dup -i=%t -w=%t -min=%d with a limit of %d:\n%s\nwant:\n%s",
+					test.fold, test.space, test.min, limit, got, test.want)
+			}
+		}
+	}
+}
+
+func TestDupMissing(t *testing.T) {
+	names := files(t, "x\ny\n", "x\n")
+	names = []string{names[0], filepath.Join(filepath.Dir(names[0]), "missing.txt"), names[1]}
+	s := &sorter{}
+	var out strings.Builder
+	ok, err := dup(&out, names, s, 2)
+	if ok || err != nil {
+		t.Errorf("dup with a missing file = %t, %v, want false, nil", ok, err)
+	}
+	if want := "2\tx\n\t" + names[0] + ":1\n\t" + names[2] + ":1\n"; out.String() != want {
+		t.Errorf("dup with a missing file printed %q, want %q", out.String(), want)
+	}
+}
+
+func TestRuns(t *testing.T) {
+	var lines []string
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i*7%100))
+	}
+	names := files(t, strings.Join(lines, "\n"))
+	want := run(t, names, 0, false, false, 2)
+
+	// The limit makes more runs than are merged in a pass.
+	const limit = 1000
+	s := &sorter{limit: limit, dir: t.TempDir()}
+	f, err := os.Open(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = readLines(s, 0, f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.runs) <= 2*mergeWidth {
+		t.Errorf("%d runs, want more than %d", len(s.runs), 2*mergeWidth)
+	}
+	s.close()
+
+	dir := t.TempDir()
+	s = &sorter{limit: limit, dir: dir}
+	defer s.close()
+	var out strings.Builder
+	if _, err := dup(&out, names, s, 2); err != nil {
+		t.Fatal(err)
+	}
+	if left, _ := os.ReadDir(dir); len(left) != 1 {
+		t.Errorf("%d temporary files after merging, want 1", len(left))
+	}
+	got := strings.ReplaceAll(out.String(), filepath.Dir(names[0])+string(filepath.Separator), "")
+	if got != want || strings.Count(got, "\t") != 1100 {
+		t.Errorf("merged runs differ from sorting in memory")
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// A record is an occurrence of a line.
+type record struct {
+	key  string // what the line is compared by
+	text string // the line itself
+	file int    // index of the file among the names
+	line int    // number of the line in it, from 1
+}
+
+// less orders records by key, and those of a key as they were read.
+func less(a, b record) bool {
+	if a.key != b.key {
+		return a.key < b.key
+	}
+	if a.file != b.file {
+		return a.file < b.file
+	}
+	return a.line < b.line
+}
+
+// A runError is a failure to write or read a temporary file.
+type runError struct{ err error }
+
+func (e *runError) Error() string { return fmt.Sprintf("temporary file: %v", e.err) }
+
+// A sorter sorts records, in memory up to limit bytes of them and
+// beyond that by writing sorted runs to temporary files in dir and
+// merging them, at most mergeWidth at a time so as to keep few files
+// open.
+type sorter struct {
+	limit int64 // 0 for no limit
+	dir   string
+	size  int64 // of recs, roughly
+	recs  []record
+	runs  []string // names of the files
+}
+
+const mergeWidth = 16
+
+func (s *sorter) add(r record) error {
+	s.recs = append(s.recs, r)
+	s.size += int64(len(r.key)) + 48 // for the record and the strings
+	if r.text != r.key {
+		s.size += int64(len(r.text))
+	}
+	if s.limit > 0 && s.size >= s.limit {
+		return s.spill()
+	}
+	return nil
+}
+
+// spill writes the records in memory to a new run.
+func (s *sorter) spill() error {
+	sort.Slice(s.recs, func(i, j int) bool { return less(s.recs[i], s.recs[j]) })
+	i := 0
+	err := s.writeRun(func() (record, bool) {
+		if i == len(s.recs) {
+			return record{}, false
+		}
+		i++
+		return s.recs[i-1], true
+	})
+	s.recs, s.size = nil, 0
+	return err
+}
+
+// writeRun writes the records that next returns to a new run, until it
+// returns false.
+func (s *sorter) writeRun(next func() (record, bool)) error {
+	f, err := os.CreateTemp(s.dir, "dup-")
+	if err != nil {
+		return &runError{err}
+	}
+	s.runs = append(s.runs, f.Name())
+	w := bufio.NewWriter(f)
+	for r, ok := next(); ok; r, ok = next() {
+		writeRecord(w, r)
+	}
+	err = w.Flush()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return &runError{err}
+	}
+	return nil
+}
+
+// finish sorts the records added, in memory or into a single run.
+func (s *sorter) finish() error {
+	if len(s.runs) == 0 {
+		sort.Slice(s.recs, func(i, j int) bool { return less(s.recs[i], s.recs[j]) })
+		return nil
+	}
+	if len(s.recs) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	for len(s.runs) > 1 {
+		n := len(s.runs)
+		if n > mergeWidth {
+			n = mergeWidth
+		}
+		if err := s.merge(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge merges the first n runs into a new one at the end.
+func (s *sorter) merge(n int) error {
+	names := s.runs[:n:n]
+	var m merge
+	defer func() {
+		for _, rr := range m {
+			rr.f.Close()
+		}
+	}()
+	for _, name := range names {
+		rr, err := openRun(name)
+		if err != nil {
+			return err
+		}
+		if rr.next() {
+			m = append(m, rr)
+		} else {
+			rr.f.Close()
+			if rr.err != nil {
+				return &runError{rr.err}
+			}
+		}
+	}
+	heap.Init(&m)
+	s.runs = s.runs[n:]
+	var err error
+	writeErr := s.writeRun(func() (record, bool) {
+		if len(m) == 0 || err != nil {
+			return record{}, false
+		}
+		rr := m[0]
+		r := rr.rec
+		if rr.next() {
+			heap.Fix(&m, 0)
+		} else {
+			heap.Pop(&m)
+			rr.f.Close()
+			err = rr.err
+		}
+		return r, true
+	})
+	for _, name := range names {
+		os.Remove(name)
+	}
+	if err != nil {
+		return &runError{err}
+	}
+	return writeErr
+}
+
+// A cursor reads the records of a finished sorter in order.
+type cursor struct {
+	recs []record   // in memory, less those read
+	rr   *runReader // or from the run
+	rec  record     // the last read
+	err  error
+}
+
+// open returns a new cursor at the first record of s, which must be
+// finished. There may be several at once.
+func (s *sorter) open() (*cursor, error) {
+	if len(s.runs) == 0 {
+		return &cursor{recs: s.recs}, nil
+	}
+	rr, err := openRun(s.runs[0])
+	if err != nil {
+		return nil, err
+	}
+	return &cursor{rr: rr}, nil
+}
+
+// next reads the next record, reporting whether there was one.
+func (c *cursor) next() bool {
+	if c.rr == nil {
+		if len(c.recs) == 0 {
+			return false
+		}
+		c.rec, c.recs = c.recs[0], c.recs[1:]
+		return true
+	}
+	if !c.rr.next() {
+		if c.rr.err != nil {
+			c.err = &runError{c.rr.err}
+		}
+		return false
+	}
+	c.rec = c.rr.rec
+	return true
+}
+
+func (c *cursor) close() {
+	if c.rr != nil {
+		c.rr.f.Close()
+	}
+}
+
+// close removes the temporary files.
+func (s *sorter) close() {
+	for _, name := range s.runs {
+		os.Remove(name)
+	}
+	s.runs = nil
+}
+
+// A run is a sequence of records, each the key, the file, the line and
+// the text, unless it is the key: numbers as uvarints, and strings as
+// their length and bytes, with the length of the text one more to tell
+// it from none.
+func writeRecord(w *bufio.Writer, r record) {
+	var buf [binary.MaxVarintLen64]byte
+	putUint := func(x uint64) { w.Write(buf[:binary.PutUvarint(buf[:], x)]) }
+	putUint(uint64(len(r.key)))
+	w.WriteString(r.key)
+	putUint(uint64(r.file))
+	putUint(uint64(r.line))
+	if r.text == r.key {
+		putUint(0)
+	} else {
+		putUint(uint64(len(r.text)) + 1)
+		w.WriteString(r.text)
+	}
+}
+
+// A runReader reads the records of a run.
+type runReader struct {
+	f   *os.File
+	in  *bufio.Reader
+	rec record // the last read
+	err error
+}
+
+func openRun(name string) (*runReader, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, &runError{err}
+	}
+	return &runReader{f: f, in: bufio.NewReader(f)}, nil
+}
+
+// next reads the next record, reporting whether there was one.
+func (rr *runReader) next() bool {
+	getUint := func() uint64 {
+		if rr.err != nil {
+			return 0
+		}
+		var x uint64
+		x, rr.err = binary.ReadUvarint(rr.in)
+		return x
+	}
+	getString := func(n uint64) string {
+		if rr.err != nil {
+			return ""
+		}
+		b := make([]byte, n)
+		_, rr.err = io.ReadFull(rr.in, b)
+		return string(b)
+	}
+	n := getUint()
+	if rr.err == io.EOF {
+		rr.err = nil // at the end of the last record
+		return false
+	}
+	rr.rec.key = getString(n)
+	rr.rec.file = int(getUint())
+	rr.rec.line = int(getUint())
+	rr.rec.text = rr.rec.key
+	if n := getUint(); n > 0 {
+		rr.rec.text = getString(n - 1)
+	}
+	if rr.err == io.EOF {
+		rr.err = io.ErrUnexpectedEOF
+	}
+	return rr.err == nil
+}
+
+// merge is a heap of the readers of runs by their last records.
+type merge []*runReader
+
+func (m merge) Len() int            { return len(m) }
+func (m merge) Less(i, j int) bool  { return less(m[i].rec, m[j].rec) }
+func (m merge) Swap(i, j int)       { m[i], m[j] = m[j], m[i] }
+func (m *merge) Push(x interface{}) { *m = append(*m, x.(*runReader)) }
+func (m *merge) Pop() interface{} {
+	old := *m
+	rr := old[len(old)-1]
+	*m = old[:len(old)-1]
+	return rr
+}
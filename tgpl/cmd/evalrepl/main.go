@@ -0,0 +1,285 @@
+// Evalrepl reads expressions of the eval package line by line and
+// prints their values.
+//
+//	> :set x = 3
+//	> pow(x, 2) + sin(y)
+//	  pow(x, 2) + sin(y)
+//	                  ^
+//	undefined variable: y
+//
+// Besides expressions, a line may be one of these commands:
+//
+//	:set x = expr     set the variable x to the value of expr
+//	:unset x          remove the variable x
+//	:vars             list the variables and their values
+//	:ast [expr]       print the tree of expr, or of the last expression
+//	:derive x [expr]  print the derivative of expr, or of the last
+//	                  expression, with respect to x
+//	:history          list the lines entered so far
+//	!n, !!            run line n of the history again, or the last line
+//	:help             list the commands
+//	:quit             exit
+//
+// Any other line starting with ! is an expression, so !!x and !(x > 1)
+// are negations; write ! 1 to negate a number rather than recall a line.
+//
+// With -history file, lines are also saved to file and read back
+// the next time.
+package main
+
+import (
+	"bufio"
+	"digest_gopl/ch7/eval"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var historyFile = flag.String("history", "", "file to keep the history in")
+
+type repl struct {
+	out     io.Writer
+	env     eval.Env
+	last    eval.Expr // the last expression evaluated
+	history []string
+	save    io.Writer // where new history lines are appended, or nil
+}
+
+func main() {
+	flag.Parse()
+	r := &repl{out: os.Stdout, env: make(eval.Env)}
+	if *historyFile != "" {
+		if data, err := os.ReadFile(*historyFile); err == nil && len(data) > 0 {
+			r.history = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		}
+		f, err := os.OpenFile(*historyFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "evalrepl: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		r.save = f
+	}
+
+	prompt := ""
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		prompt = "> " // interactive
+	}
+	r.loop(os.Stdin, prompt)
+}
+
+// loop runs the lines read from in, printing prompt before each, until
+// the end of in or :quit.
+func (r *repl) loop(in io.Reader, prompt string) {
+	lines := bufio.NewScanner(in)
+	for fmt.Fprint(r.out, prompt); lines.Scan(); fmt.Fprint(r.out, prompt) {
+		if !r.run(lines.Text()) {
+			return
+		}
+	}
+}
+
+// historyRef returns the number of the line of history that line
+// refers to, with n the length of the history, and whether it is a
+// reference: !! or ! and digits.
+func historyRef(line string, n int) (int, bool) {
+	if line == "!!" {
+		return n, true
+	}
+	if len(line) < 2 || line[0] != '!' || strings.Trim(line[1:], "0123456789") != "" {
+		return 0, false
+	}
+	i, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return 0, true // too many digits for any line
+	}
+	return i, true
+}
+
+// run executes one line of input, reporting whether to go on.
+func (r *repl) run(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return true
+	}
+	if n, ok := historyRef(line, len(r.history)); ok {
+		if n < 1 || n > len(r.history) {
+			fmt.Fprintf(r.out, "no line in history for %s\n", line)
+			return true
+		}
+		line = r.history[n-1]
+		fmt.Fprintln(r.out, line)
+	}
+	r.history = append(r.history, line)
+	if r.save != nil {
+		fmt.Fprintln(r.save, line)
+	}
+
+	if !strings.HasPrefix(line, ":") {
+		if e, ok := r.parse(line, 0); ok {
+			r.last = e
+			fmt.Fprintf(r.out, "%g\n", e.Eval(r.env))
+		}
+		return true
+	}
+	cmd, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		cmd, arg = line[:i], strings.TrimLeft(line[i:], " \t")
+	}
+	offset := len(line) - len(arg) // of arg within line, for carets
+	switch cmd {
+	case ":set":
+		eq := strings.Index(arg, "=")
+		if eq < 0 {
+			fmt.Fprintln(r.out, "usage: :set x = expr")
+			break
+		}
+		name := strings.TrimSpace(arg[:eq])
+		if !isIdent(name) {
+			fmt.Fprintf(r.out, "bad variable name %q\n", name)
+			break
+		}
+		if e, ok := r.parse(line, offset+eq+1); ok {
+			r.env[eval.Var(name)] = e.Eval(r.env)
+		}
+	case ":unset":
+		delete(r.env, eval.Var(arg))
+	case ":vars":
+		var names []string
+		for v := range r.env {
+			names = append(names, string(v))
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(r.out, "%s = %g\n", name, r.env[eval.Var(name)])
+		}
+	case ":ast":
+		if e, ok := r.expr(line, arg, offset); ok {
+			fmt.Fprint(r.out, eval.Tree(e))
+		}
+	case ":derive":
+		name, rest := arg, ""
+		if i := strings.IndexAny(arg, " \t"); i >= 0 {
+			name, rest = arg[:i], strings.TrimLeft(arg[i:], " \t")
+		}
+		if !isIdent(name) {
+			fmt.Fprintln(r.out, "usage: :derive x [expr]")
+			break
+		}
+		e, ok := r.expr(line, rest, len(line)-len(rest))
+		if !ok {
+			break
+		}
+		d, err := eval.DeriveFuncs(e, eval.Var(name), nil)
+		if err != nil {
+			fmt.Fprintln(r.out, err)
+			break
+		}
+		fmt.Fprintln(r.out, d)
+	case ":history":
+		for i, h := range r.history {
+			fmt.Fprintf(r.out, "%4d  %s\n", i+1, h)
+		}
+	case ":help":
+		fmt.Fprint(r.out, help)
+	case ":quit":
+		return false
+	default:
+		fmt.Fprintf(r.out, "unknown command %s; try :help\n", cmd)
+	}
+	return true
+}
+
+const help = `expr              print the value of expr
+:set x = expr     set the variable x to the value of expr
+:unset x          remove the variable x
+:vars             list the variables and their values
+:ast [expr]       print the tree of expr, or of the last expression
+:derive x [expr]  print the derivative of expr, or of the last expression
+:history          list the lines entered so far
+!n, !!            run line n of the history again, or the last line
+:help             list the commands
+:quit             exit
+`
+
+// expr returns the expression src, at offset in line, or if src is
+// empty the last expression.
+func (r *repl) expr(line, src string, offset int) (eval.Expr, bool) {
+	if src != "" {
+		return r.parse(line, offset)
+	}
+	if r.last == nil {
+		fmt.Fprintln(r.out, "no expression yet")
+		return nil, false
+	}
+	return r.last, true
+}
+
+// parse parses and checks the expression that starts at offset in
+// line, reporting any error with a caret under its column.
+func (r *repl) parse(line string, offset int) (eval.Expr, bool) {
+	src := line[offset:]
+	e, err := eval.Parse(src)
+	if err != nil {
+		if se, ok := err.(*eval.SyntaxError); ok {
+			r.caret(line, offset+se.Offset)
+			fmt.Fprintln(r.out, se.Msg)
+		} else {
+			fmt.Fprintln(r.out, err)
+		}
+		return nil, false
+	}
+	vars := make(map[eval.Var]bool)
+	if err := e.Check(vars); err != nil {
+		if ce, ok := err.(*eval.CheckError); ok {
+			if _, _, off, ok := eval.Position(src, ce.Expr); ok {
+				r.caret(line, offset+off)
+			}
+		}
+		fmt.Fprintln(r.out, err)
+		return nil, false
+	}
+	var undefined []string
+	for v := range vars {
+		if _, ok := r.env[v]; !ok {
+			undefined = append(undefined, string(v))
+		}
+	}
+	if undefined != nil {
+		sort.Strings(undefined)
+		if _, _, off, ok := eval.Position(src, eval.Var(undefined[0])); ok {
+			r.caret(line, offset+off)
+		}
+		fmt.Fprintf(r.out, "undefined variable: %s\n", strings.Join(undefined, ", "))
+		return nil, false
+	}
+	return e, true
+}
+
+// caret prints line with a caret under the byte at offset.
+func (r *repl) caret(line string, offset int) {
+	// copy tabs so that the caret lines up however they are shown
+	indent := []rune(line[:offset])
+	for i, c := range indent {
+		if c != '\t' {
+			indent[i] = ' '
+		}
+	}
+	fmt.Fprintf(r.out, "  %s\n  %s^\n", line, string(indent))
+}
+
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"digest_gopl/ch7/eval"
+	"strings"
+	"testing"
+)
+
+// session runs the lines of script in a new repl and returns what it
+// printed.
+func session(script string) string {
+	var out strings.Builder
+	r := &repl{out: &out, env: make(eval.Env)}
+	r.loop(strings.NewReader(script), "")
+	return out.String()
+}
+
+func TestRepl(t *testing.T) {
+	for _, test := range []struct {
+		script, want string
+	}{
+		{"1 + 2\n", "3\n"},
+		{":set x = 3\npow(x, 2)\n:vars\n", "9\nx = 3\n"},
+		{":set x = 3\n!x\n!!x\n!(x > 1)\n! 1\n", "0\n1\n0\n0\n"},
+		{":set x = 2\nx * 3\n!!\n!1\n:history\n",
+			"6\nx * 3\n6\n:set x = 2\n" +
+				"   1  :set x = 2\n   2  x * 3\n   3  x * 3\n   4  :set x = 2\n   5  :history\n"},
+		{"!!\n!7\n!99999999999999999999\n", "no line in history for !!\n" +
+			"no line in history for !7\nno line in history for !99999999999999999999\n"},
+		{"x + 1\n", "  x + 1\n  ^\nundefined variable: x\n"},
+		{"1 +\t+\n", "  1 +\t+\n     \t ^\nunexpected end of file\n"},
+		{"sin(1) + sin(1, 2)\n", "  sin(1) + sin(1, 2)\n           ^\ncall to sin has 2 args, want 1\n"},
+		{":set y = x\n", "  :set y = x\n           ^\nundefined variable: x\n"},
+		{":set x = 1\nx * x\n:derive x\n:derive x sin(x)\n:ast\n",
+			"1\nx + x\ncos(x)\nbinary *\n  var x\n  var x\n"},
+		{":derive x\n:frob\n:quit\n1\n", "no expression yet\nunknown command :frob; try :help\n"},
+	} {
+		if got := session(test.script); got != test.want {
+			t.Errorf("%q printed:\n%s\nwant:\n%s", test.script, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Fetch fetches URLs in parallel, at most -workers at a time, and
+// prints a line of JSON for each as it is done:
+//
+//	{"url":"https://go.dev/","status":200,"bytes":61870,"seconds":0.41,"attempts":1}
+//	{"url":"https://nowhere.invalid/","bytes":0,"seconds":7.02,"attempts":4,"error":"..."}
+//
+// The URLs are the arguments, or else the lines of the standard input.
+// A request that gets no response, or a status of 429 or 5xx, is tried
+// again after a pause that doubles each time up to a minute, as
+// WaitForServer in ch5/wait does, or as long as the Retry-After header
+// of a 429 or 503 asks if that is longer; a URL whose server asks to
+// wait beyond the minute fails at once. Each attempt has -timeout to
+// finish, and all of them -total together, after which the URLs left
+// fail at once.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	workers = flag.Int("workers", 8, "most URLs fetched at once")
+	timeout = flag.Duration("timeout", 30*time.Second, "time for each attempt at a URL, body and all")
+	total   = flag.Duration("total", 0, "time for all the URLs, or 0 for no limit")
+	retries = flag.Int("retries", 3, "attempts after the first at a URL that failed for a reason that may pass")
+	backoff = flag.Duration("backoff", time.Second, "pause before the first retry, doubled before each after up to a minute")
+)
+
+// A result is the outcome of fetching a URL, of its last attempt if
+// there were several.
+type result struct {
+	URL      string  `json:"url"`
+	Status   int     `json:"status,omitempty"`
+	Bytes    int64   `json:"bytes"`   // of the body
+	Seconds  float64 `json:"seconds"` // of all the attempts and the pauses between
+	Attempts int     `json:"attempts"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// maxBackoff is the longest pause between attempts at a URL.
+const maxBackoff = time.Minute
+
+type fetcher struct {
+	client  *http.Client
+	timeout time.Duration // of each attempt
+	retries int
+	backoff time.Duration // before the first retry, doubled for each after
+}
+
+// fetch fetches url, trying again while the failures may pass and ctx
+// is not done.
+func (f *fetcher) fetch(ctx context.Context, url string) result {
+	start := time.Now()
+	r := result{URL: url}
+	for {
+		r.Attempts++
+		var again bool
+		var wait time.Duration
+		var err error
+		r.Status, r.Bytes, again, wait, err = f.get(ctx, url)
+		if err == nil {
+			r.Error = ""
+			break
+		}
+		r.Error = err.Error()
+		if !again || r.Attempts > f.retries || !pause(ctx, f.delay(r.Attempts, wait)) {
+			break
+		}
+	}
+	r.Seconds = time.Since(start).Seconds()
+	return r
+}
+
+// delay returns the pause before the attempt after the given number of
+// them, the last of which the server asked to wait for at least wait.
+func (f *fetcher) delay(attempts int, wait time.Duration) time.Duration {
+	d := f.backoff // exponential back-off, doubled only while short of overflow
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	if wait > d {
+		d = wait
+	}
+	return d
+}
+
+// pause waits for d, reporting whether ctx was still not done at the
+// end.
+func pause(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// get makes one attempt at url, returning its status and the size of
+// its body, and whether a failure may pass if tried again and how long
+// the server asked to wait first, if it did.
+func (f *fetcher) get(ctx context.Context, url string) (status int, n int64, again bool, wait time.Duration, err error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, 0, false, 0, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return 0, 0, true, 0, err
+	}
+	defer resp.Body.Close()
+	n, err = io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return resp.StatusCode, n, true, 0, fmt.Errorf("while reading %s: %v", url, err)
+	}
+	if resp.StatusCode >= 400 {
+		again = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			wait = retryAfter(resp.Header.Get("Retry-After"), time.Now())
+			if wait > maxBackoff {
+				return resp.StatusCode, n, false, wait, fmt.Errorf("%s, retry after %v", resp.Status, wait)
+			}
+		}
+		return resp.StatusCode, n, again, wait, fmt.Errorf("%s", resp.Status)
+	}
+	return resp.StatusCode, n, false, 0, nil
+}
+
+// retryAfter returns the wait that a Retry-After header of h asks for
+// at now, in seconds or until a date, or 0 if h is empty or malformed.
+func retryAfter(h string, now time.Time) time.Duration {
+	if secs, err := strconv.Atoi(h); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		if int64(secs) > int64(math.MaxInt64/time.Second) {
+			return math.MaxInt64
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// run fetches the URLs received from urls with the given number of
+// workers, calling emit, from one goroutine at a time, with each result.
+func run(ctx context.Context, f *fetcher, workers int, urls <-chan string, emit func(result)) {
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for url := range urls {
+				results <- f.fetch(ctx, url)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	for r := range results {
+		emit(r)
+	}
+}
+
+func main() {
+	flag.Parse()
+	if *workers < 1 || *retries < 0 || *backoff <= 0 {
+		fmt.Fprintln(os.Stderr, "fetch: need -workers of 1 or more, -retries of 0 or more and -backoff above 0")
+		os.Exit(2)
+	}
+	ctx := context.Background()
+	if *total > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *total)
+		defer cancel()
+	}
+
+	urls := make(chan string)
+	go func() {
+		defer close(urls)
+		if flag.NArg() > 0 {
+			for _, url := range flag.Args() {
+				urls <- url
+			}
+			return
+		}
+		input := bufio.NewScanner(os.Stdin)
+		for input.Scan() {
+			if url := strings.TrimSpace(input.Text()); url != "" {
+				urls <- url
+			}
+		}
+		if err := input.Err(); err != nil {
+			fmt.Fprintf(os.Stderr, "fetch: %v\n", err)
+		}
+	}()
+
+	start := time.Now()
+	f := &fetcher{client: http.DefaultClient, timeout: *timeout, retries: *retries, backoff: *backoff}
+	enc := json.NewEncoder(os.Stdout)
+	var n, failed int
+	run(ctx, f, *workers, urls, func(r result) {
+		n++
+		if r.Error != "" {
+			failed++
+		}
+		if err := enc.Encode(r); err != nil {
+			fmt.Fprintf(os.Stderr, "fetch: %v\n", err)
+			os.Exit(1)
+		}
+	})
+	fmt.Fprintf(os.Stderr, "%.2fs elapsed, %d of %d URLs failed\n", time.Since(start).Seconds(), failed, n)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// go run . https://go.dev https://golang.org/doc https://nowhere.invalid
+// go run . -workers=2 -timeout=5s -total=1m <urls.txt | jq -r 'select(.error) | .url'
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// server serves /ok, /missing, /slow, /flaky, which fails with 503
+// until its third request, and /later, which fails with 429 and asks
+// for an hour's wait.
+func server(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	flaky := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte("hello"))
+		case "/flaky":
+			mu.Lock()
+			flaky++
+			n := flaky
+			mu.Unlock()
+			if n < 3 {
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("at last"))
+		case "/later":
+			w.Header().Set("Retry-After", "3600")
+			http.Error(w, "later", http.StatusTooManyRequests)
+		case "/slow":
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestFetch(t *testing.T) {
+	s := server(t)
+	f := &fetcher{client: s.Client(), timeout: 100 * time.Millisecond, retries: 3, backoff: time.Millisecond}
+	for _, test := range []struct {
+		path     string
+		status   int
+		bytes    int64
+		attempts int
+		err      string
+	}{
+		{"/ok", 200, 5, 1, ""},
+		{"/flaky", 200, 7, 3, ""},
+		{"/missing", 404, 19, 1, "404 Not Found"}, // not retried
+		{"/later", 429, 6, 1, "retry after 1h0m0s"},
+		{"/slow", 0, 0, 4, "deadline exceeded"},
+	} {
+		r := f.fetch(context.Background(), s.URL+test.path)
+		if r.Status != test.status || r.Bytes != test.bytes || r.Attempts != test.attempts ||
+			!strings.Contains(r.Error, test.err) || (test.err == "") != (r.Error == "") {
+			t.Errorf("fetch %s = %+v, want status %d, %d bytes, %d attempts, error %q",
+				test.path, r, test.status, test.bytes, test.attempts, test.err)
+		}
+	}
+
+	// no retries once the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	f.backoff = time.Second
+	start := time.Now()
+	if r := f.fetch(ctx, s.URL+"/slow"); r.Attempts != 1 || r.Error == "" {
+		t.Errorf("fetch /slow within 150ms = %+v", r)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("fetch /slow within 150ms took %v", d)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	f := &fetcher{backoff: time.Second}
+	for _, test := range []struct {
+		attempts int
+		wait     time.Duration
+		want     time.Duration
+	}{
+		{1, 0, time.Second},
+		{3, 0, 4 * time.Second},
+		{3, 10 * time.Second, 10 * time.Second},
+		{3, time.Second, 4 * time.Second},
+		{7, 0, time.Minute},
+		{100, 0, time.Minute}, // no overflow
+	} {
+		if got := f.delay(test.attempts, test.wait); got != test.want {
+			t.Errorf("delay(%d, %v) = %v, want %v", test.attempts, test.wait, got, test.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	for _, test := range []struct {
+		h    string
+		want time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"-1", 0},
+		{"soon", 0},
+		{"Tue, 02 Jan 2024 15:06:05 GMT", 2 * time.Minute},
+		{"Tue, 02 Jan 2024 15:00:00 GMT", 0}, // past
+		{"99999999999999999", math.MaxInt64},
+	} {
+		if got := retryAfter(test.h, now); got != test.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", test.h, got, test.want)
+		}
+	}
+}
+
+func TestRunWorkers(t *testing.T) {
+	var mu sync.Mutex
+	active, most := 0, 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		if active > most {
+			most = active
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}))
+	defer s.Close()
+
+	urls := make(chan string)
+	go func() {
+		for i := 0; i < 20; i++ {
+			urls <- s.URL
+		}
+		close(urls)
+	}()
+	f := &fetcher{client: s.Client(), timeout: time.Second}
+	n := 0
+	run(context.Background(), f, 3, urls, func(r result) {
+		n++
+		if r.Error != "" {
+			t.Error(r.Error)
+		}
+	})
+	if n != 20 || most > 3 {
+		t.Errorf("%d results with at most %d requests at once, want 20 with at most 3", n, most)
+	}
+}